@@ -10,7 +10,9 @@ import (
 	"os"
 	"os/signal"
 	"runtime/pprof"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -38,6 +40,15 @@ var (
 	remote             = flag.Bool("remote", false, "Operate in remote-controlled mode")
 	remotePort         = flag.Uint("remote-port", 7884, "Port for report control HTTP listener")
 	profile            = flag.String("profile", "", "Enable CPU profiling")
+	sessionTimeout     = flag.Duration("session-timeout", 0, "Consumer group session timeout (0 for client default)")
+	heartbeatInterval  = flag.Duration("heartbeat-interval", 0, "Consumer group heartbeat interval (0 for client default)")
+	keyed              = flag.Bool("keyed", false, "Give each token a fixed key, and count hops of a key that arrive out of order")
+	produceRate        = flag.Float64("produce-rate", 0, "If non-zero, produce this many messages/s per worker independent of consumption, rather than echoing consumed messages")
+	queueDepth         = flag.Int("queue-depth", 1024, "With -produce-rate, how many consumed tokens may wait to be produced before further ones are dropped")
+	standby            = flag.Bool("standby", false, "Warm standby: wait until the group has no members (the primary has died), then join it and take over the primary's tokens")
+	tokenDeadline      = flag.Duration("token-deadline", 0, "If non-zero, count tokens that don't come back within this time as lost (only accurate if all group members are in this process)")
+	lostTokenPolicy    = flag.String("lost-token-policy", "none", "What to do about lost tokens: 'none' to just count them, 'reinject' to replace them")
+	rebalanceTimeout   = flag.Duration("rebalance-timeout", 0, "Consumer group rebalance timeout (0 for client default)")
 )
 
 // NewAdmin returns a franz-go admin client.
@@ -58,6 +69,7 @@ func NewAdmin() (*kadm.Client, error) {
 
 func main() {
 	flag.Parse()
+	util.FlagsFromEnv("KGO_REPEATER")
 
 	if *debug {
 		log.SetLevel(log.DebugLevel)
@@ -103,39 +115,88 @@ func main() {
 	}
 
 	dataInFlightPerWorker := (*initialDataMb * 1024 * 1024) / uint64(*workers)
+	if *standby {
+		// The tokens are already in flight: we inherit the primary's
+		dataInFlightPerWorker = 0
+	}
 
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 
+	// Workers may be added and removed at runtime via HTTP
 	var verifiers []*repeater.Worker
+	var verifiersLock sync.Mutex
+	activated := false
+
+	// A standby's workers are only prepared, joining the group, once it
+	// takes over: until then there is nothing to stop
+	takenOver := !*standby
+
+	var tracker *repeater.TokenTracker
+	if *tokenDeadline > 0 {
+		policy := repeater.LostTokenPolicy(*lostTokenPolicy)
+		if policy != repeater.LostTokenIgnore && policy != repeater.LostTokenReinject {
+			util.DieConfig("Unknown lost token policy '%s'", *lostTokenPolicy)
+		}
+		tracker = repeater.NewTokenTracker(*tokenDeadline, policy)
+		trackerCtx, cancelTracker := context.WithCancel(context.Background())
+		defer cancelTracker()
+		go tracker.Run(trackerCtx)
+	}
 
 	hostName, err := os.Hostname()
 	util.Chk(err, "Error getting hostname %v", err)
 
 	pid := os.Getpid()
 
-	log.Infof("Preparing %d workers...", *workers)
-	for i := uint(0); i < *workers; i++ {
-		name := fmt.Sprintf("%s_%d_w_%d", hostName, pid, i)
+	nextWorkerIndex := uint(0)
+	newWorker := func() *repeater.Worker {
+		name := fmt.Sprintf("%s_%d_w_%d", hostName, pid, nextWorkerIndex)
+		nextWorkerIndex += 1
 		log.Debugf("Preparing worker %s...", name)
 		wConfig := worker.NewWorkerConfig(
 			name, *brokers, *trace, *topic, *linger, *maxBufferedRecords,
 		)
+		wConfig.SessionTimeout = *sessionTimeout
+		wConfig.HeartbeatInterval = *heartbeatInterval
+		wConfig.RebalanceTimeout = *rebalanceTimeout
 		config := repeater.NewRepeaterConfig(wConfig, *group, partitions, *keys, *payloadSize, dataInFlightPerWorker)
+		config.Tracker = tracker
+		config.Keyed = *keyed
+		config.ProduceRate = *produceRate
+		config.QueueDepth = *queueDepth
 		lv := repeater.NewWorker(config)
-		lv.Prepare()
-		verifiers = append(verifiers, &lv)
+		return &lv
+	}
+
+	log.Infof("Preparing %d workers...", *workers)
+	for i := uint(0); i < *workers; i++ {
+		verifiers = append(verifiers, newWorker())
+	}
+	if !*standby {
+		for _, v := range verifiers {
+			v.Prepare()
+		}
+	}
+
+	stopWorker := func(i int, v *repeater.Worker) {
+		log.Infof("Waiting for worker %d...", i)
+		result := (*v).Wait()
+		log.Infof("Waiting for worker %d complete", i)
+		log.Infof("Verifier %d result: %s", i, result.String())
 	}
 
 	do_shutdown := func() {
+		verifiersLock.Lock()
+		defer verifiersLock.Unlock()
+		if !takenOver {
+			return
+		}
 		for _, v := range verifiers {
 			(*v).Stop()
 		}
 		for i, v := range verifiers {
-			log.Infof("Waiting for worker %d...", i)
-			result := (*v).Wait()
-			log.Infof("Waiting for worker %d complete", i)
-			log.Infof("Verifier %d result: %s", i, result.String())
+			stopWorker(i, v)
 		}
 	}
 
@@ -151,10 +212,12 @@ func main() {
 	})
 
 	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		verifiersLock.Lock()
 		var results []repeater.WorkerStatus
 		for _, v := range verifiers {
 			results = append(results, v.Status())
 		}
+		verifiersLock.Unlock()
 
 		serialized, err := json.MarshalIndent(results, "", "  ")
 		util.Chk(err, "Status serialization error")
@@ -164,15 +227,123 @@ func main() {
 	})
 
 	mux.HandleFunc("/reset", func(w http.ResponseWriter, r *http.Request) {
+		verifiersLock.Lock()
 		for _, v := range verifiers {
 			v.Reset()
 		}
+		verifiersLock.Unlock()
 		w.WriteHeader(http.StatusOK)
 		w.Write(make([]byte, 0))
 	})
 
+	// Scale the number of group members in this process, e.g. to
+	// provoke rebalances during a latency experiment.
+	workerCount := func(r *http.Request) (int, error) {
+		s := r.URL.Query().Get("count")
+		if s == "" {
+			return 1, nil
+		}
+		return strconv.Atoi(s)
+	}
+
+	mux.HandleFunc("/workers/add", func(w http.ResponseWriter, r *http.Request) {
+		n, err := workerCount(r)
+		if err != nil || n < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		log.Infof("Remote request to add %d workers", n)
+
+		verifiersLock.Lock()
+		for i := 0; i < n; i++ {
+			v := newWorker()
+			if takenOver {
+				v.Prepare()
+			}
+			if activated {
+				v.Activate()
+			}
+			verifiers = append(verifiers, v)
+		}
+		total := len(verifiers)
+		verifiersLock.Unlock()
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(fmt.Sprintf("{\"workers\": %d}", total)))
+	})
+
+	mux.HandleFunc("/workers/remove", func(w http.ResponseWriter, r *http.Request) {
+		n, err := workerCount(r)
+		if err != nil || n < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		log.Infof("Remote request to remove %d workers", n)
+
+		verifiersLock.Lock()
+		if n > len(verifiers) {
+			n = len(verifiers)
+		}
+		first := len(verifiers) - n
+		removed := verifiers[first:]
+		verifiers = verifiers[:first]
+		total := len(verifiers)
+		prepared := takenOver
+		verifiersLock.Unlock()
+
+		if prepared {
+			for i, v := range removed {
+				v.Stop()
+				stopWorker(first+i, v)
+				// Leave the group promptly rather than waiting to time out
+				v.Shutdown()
+			}
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(fmt.Sprintf("{\"workers\": %d}", total)))
+	})
+
 	go http.ListenAndServe(fmt.Sprintf("0.0.0.0:%d", *remotePort), mux)
 
+	if *standby {
+		admin, err := NewAdmin()
+		util.Chk(err, "Failed to set up admin client: %v", err)
+
+		// The primary's members leave the group once their sessions
+		// time out after it dies.
+		log.Infof("Standby: waiting for group %s to have no members", *group)
+		for {
+			describedGroups, err := admin.DescribeGroups(context.Background(), *group)
+			if err != nil {
+				log.Infof("Retrying on DescribeGroups error %v", err)
+			} else {
+				described := describedGroups[*group]
+				if described.Err == nil && len(described.Members) == 0 {
+					log.Infof("Standby: group is %s, taking over", described.State)
+					break
+				}
+			}
+
+			select {
+			case <-c:
+				log.Info("Stopping on signal...")
+				admin.Close()
+				return
+			case <-time.After(time.Second):
+			}
+		}
+		admin.Close()
+
+		verifiersLock.Lock()
+		for _, v := range verifiers {
+			v.MarkTakeover()
+			v.Prepare()
+		}
+		takenOver = true
+		verifiersLock.Unlock()
+	}
+
 	if !*remote {
 		admin, err := NewAdmin()
 		util.Chk(err, "Failed to set up admin client: %v", err)
@@ -228,11 +399,14 @@ func main() {
 		}
 	}
 
+	verifiersLock.Lock()
 	log.Infof("Activating %d workers", len(verifiers))
 	for i, v := range verifiers {
 		log.Debugf("Activating worker %d...", i)
 		v.Activate()
 	}
+	activated = true
+	verifiersLock.Unlock()
 
 	select {
 	case <-c:
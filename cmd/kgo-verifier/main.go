@@ -14,10 +14,16 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"math/rand"
 	"net/http"
 	"os"
 	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/redpanda-data/kgo-verifier/pkg/util"
 	log "github.com/sirupsen/logrus"
@@ -37,42 +43,267 @@ var (
 	username           = flag.String("username", "", "SASL username")
 	password           = flag.String("password", "", "SASL password")
 	mSize              = flag.Int("msg_size", 16384, "Size of messages to produce")
+	mSizeDist          = flag.String("msg-size-dist", "", "Producer: instead of --msg_size, draw message sizes from 'uniform:MIN:MAX', 'lognormal:MEAN:STDDEV[:MAX]' or 'weighted:SIZE=WEIGHT,...'")
 	pCount             = flag.Int("produce_msgs", 0, "Number of messages to produce")
+	pBytes             = flag.String("produce-bytes", "", "Producer: produce about this much data (keys and values, e.g. '500GB' or '1TiB') instead of a number of messages")
 	cCount             = flag.Int("rand_read_msgs", 0, "Number of validation reads to do from each random reader")
 	seqRead            = flag.Bool("seq_read", false, "Whether to do sequential read validation")
+	rawRead            = flag.Bool("raw-read", false, "Read the whole topic with raw fetches from partition leaders, checking batch CRCs client-side instead of relying on the client library's decoding")
+	replicaCompare     = flag.Bool("replica-compare", false, "Read each partition from its leader and from each follower directly, and check that the records are byte for byte the same")
+	seqReadShards      = flag.Int("seq-read-shards", 1, "Sequential reader: split the partitions between this many readers, each with its own client")
 	parallelRead       = flag.Int("parallel", 1, "How many readers to run in parallel")
 	batchMaxBytes      = flag.Int("batch_max_bytes", 1048576, "the maximum batch size to allow per-partition (must be less than Kafka's max.message.bytes, producing)")
 	cgReaders          = flag.Int("consumer_group_readers", 0, "Number of parallel readers in the consumer group")
 	linger             = flag.Duration("linger", 0, "if non-zero, linger to use when producing")
+	latencyWeighting   = flag.Duration("latency-weight-threshold", 0, "Producer: if non-zero, shift traffic away from partitions whose moving average ack latency is over this, and back once it recovers")
+	maxMsgsPerSec      = flag.Float64("max-msgs-per-sec", 0, "Producer: produce at most this many messages/s, for steady soak tests (0 for no limit)")
+	maxBytesPerSec     = flag.String("max-bytes-per-sec", "", "Producer: produce at most this much key and value data per second before compression, e.g. '10MB' (default no limit)")
+	freezeOnFailure    = flag.Bool("freeze-on-failure", false, "On the first validation failure, hold every worker where it is with its clients open instead of exiting, for inspecting the cluster and /status, /freeze live; exit on a signal")
+	maxAnomalies       = flag.Int("max-anomalies", 0, "Stop the run, logging every worker's status, after this many anomalies that workers otherwise carry on past: records sent to --dlq-topic and unexplained bad offsets (0 for no limit)")
+	produceRack        = flag.String("produce-rack", "", "Producer: only produce to partitions whose leaders are in this rack, following leadership as it moves")
+	seed               = flag.Int64("seed", 0, "Producer: seed for choosing partitions, to repeat an earlier run's choices (0 for a random seed, which is logged and in the producer status)")
+	compression        = flag.String("compression", "none", "Producer: batch compression, one of none, gzip, snappy, lz4 or zstd")
 	maxBufferedRecords = flag.Uint("max-buffered-records", 1024, "Producer buffer size: the default of 1 is makes roughly one event per batch, useful for measurement.  Set to something higher to make it easier to max out bandwidth.")
 	remote             = flag.Bool("remote", false, "Remote control mode, driven by HTTP calls, for use in automated tests")
 	remotePort         = flag.Uint("remote-port", 7884, "HTTP listen port for remote control/query")
 	loop               = flag.Bool("loop", false, "For readers, run indefinitely until stopped via signal or HTTP call")
 	name               = flag.String("client-name", "kgo", "Name of kafka client")
+	clientId           = flag.String("client-id", "", "Client ID sent with every request, for telling verifier instances apart in broker metrics and quotas (overrides --client-name)")
+	softwareName       = flag.String("software-name", "", "Client software name reported to brokers (KIP-511), with --software-version (empty for the franz-go default)")
+	softwareVersion    = flag.String("software-version", "", "Client software version reported to brokers, with --software-name")
 	fakeTimestampMs    = flag.Int64("fake-timestamp-ms", -1, "Producer: set artificial batch timestamps on an incrementing basis, starting from this number")
+	refetchInvalid     = flag.Bool("refetch-invalid", false, "Readers: on a validation failure, re-fetch the record to distinguish transient from durable corruption")
+	refetchFollower    = flag.Bool("refetch-follower", false, "Readers: when re-fetching invalid records, also read directly from a follower replica")
+	stuckWindow        = flag.Duration("stuck-partition-window", 0, "Readers: if non-zero, flag partitions that deliver no records for this long while data remains")
+	stuckRecover       = flag.Bool("stuck-partition-recover", false, "Readers: try to recover stuck partitions by seeking and refreshing metadata")
+	skewFactor         = flag.Float64("arrival-skew-factor", 0, "Readers: if non-zero, flag partitions whose records arrive this many times later after being produced than the median partition's")
+	skewMin            = flag.Duration("arrival-skew-min", time.Second, "Readers: with --arrival-skew-factor, only flag partitions at least this much later than the median")
+	topicChecksum      = flag.Bool("topic-checksum", false, "Sequential readers: compute a checksum of each partition's record keys and values, for comparing copies of a topic")
+	sessionTimeout     = flag.Duration("session-timeout", 0, "Consumer group readers: session timeout (0 for client default)")
+	heartbeatInterval  = flag.Duration("heartbeat-interval", 0, "Consumer group readers: heartbeat interval (0 for client default)")
+	rebalanceTimeout   = flag.Duration("rebalance-timeout", 0, "Consumer group readers: rebalance timeout (0 for client default)")
+	evictionTest       = flag.Bool("group-eviction-test", false, "Consumer group readers: make one reader exceed its timeouts during a rebalance, to check that it is evicted and the group carries on")
+	startDelay         = flag.Duration("start-delay", 0, "Wait this long before starting, e.g. to stagger fleets of instances")
+	startJitter        = flag.Duration("start-jitter", 0, "Wait a further random time up to this long before starting, so that instances launched together don't all connect at once")
+	runId              = flag.String("run-id", "", "Stamp produced records with this run ID, and only validate records from this run, so that many runs may share a topic")
+	offsetsUrl         = flag.String("offsets-url", "", "Readers: fetch valid offsets from a producer's /valid_offsets endpoint (e.g. http://producer:7884/valid_offsets) instead of the local file")
+	exitFile           = flag.String("exit-file", "", "On exit, write the reason (ok/infrastructure/validation/config) and exit code as JSON to this file")
+	panicRestarts      = flag.Int("panic-restarts", 0, "How many times to restart a producer or reader loop after a panic, before stopping with the panic reported in status")
+	requestRetries     = flag.Int("request-retries", 0, "Client: how many times to retry a failed request (0 for client default)")
+	retryTimeout       = flag.Duration("retry-timeout", 0, "Client: how long to keep retrying a failed request (0 for client default)")
+	retryBackoffMin    = flag.Duration("retry-backoff-min", 0, "Client: initial backoff between retries, doubling on each retry (0 for client default)")
+	retryBackoffMax    = flag.Duration("retry-backoff-max", 0, "Client: maximum backoff between retries (0 for client default)")
+	requestTimeout     = flag.Duration("request-timeout-overhead", 0, "Client: time allowed for a request on top of any timeout in the request itself (0 for client default)")
+	offlineDump        = flag.String("offline-dump", "", "Validate records from this dump file against the producer's valid offsets, without connecting to the cluster")
+	dumpFormat         = flag.String("dump-format", "segment", "With -offline-dump: 'segment' for a raw log segment, or 'kcat-json' for kcat -C -J output")
+	dumpPartition      = flag.Int("dump-partition", 0, "With -offline-dump of a segment: the partition the segment belongs to")
+	expectations       = flag.String("expectations", "", "Readers: validate against this expectations manifest (offset ranges and key pattern) rather than our producer's valid offsets, e.g. for data written by another tool")
+	payloadFormat      = flag.String("payload-format", "zeros", "Producer: record values as 'zeros', 'franz-bench' (like the franz-go bench tool) or 'verifiable-producer' (like Kafka's VerifiableProducer)")
+	produceRateMsgs    = flag.Float64("produce-rate-msgs", 0, "Client: cap each worker's produce rate to this many messages/s (0 for no limit)")
+	produceRateMb      = flag.Float64("produce-rate-mb", 0, "Client: cap each worker's produce requests to this many MB/s on the wire (0 for no limit)")
+	fetchRateMb        = flag.Float64("fetch-rate-mb", 0, "Client: cap each worker's fetch responses to this many MB/s on the wire (0 for no limit)")
+	resourceInterval   = flag.Duration("resource-interval", 30*time.Second, "How often to sample the verifier's own heap usage and goroutine count (0 to disable)")
+	maxHeapMb          = flag.Uint64("max-heap-mb", 0, "Fail the run if the verifier's heap in use exceeds this many MB, e.g. to catch leaks in soak tests (0 for no limit)")
+	resourceHost       = flag.Bool("resource-host", false, "Also sample host network and disk throughput and the verifier's CPU use (Linux only), and include the resource history in the --exit-file")
+	maxGoroutines      = flag.Int("max-goroutines", 0, "Fail the run if the verifier has more than this many goroutines (0 for no limit)")
+	legacyKeys         = flag.String("legacy-keys", "validate", "Readers: 'validate' records keyed by older verifier versions (before keys were versioned) using the old rules, or 'skip' them, counted as foreign")
+	foreignRecords     = flag.String("foreign-records", "ignore", "Readers: for records not written by the verifier, 'ignore' (treat as out of scope), 'count' (as foreign_reads) or 'fail' validation")
+	slis               = flag.String("slis", "ack-latency,e2e-latency,error-ratio", "Comma separated SLIs to export in OpenMetrics format on /slis: ack-latency, e2e-latency, error-ratio")
+	fetchSessions      = flag.String("fetch-sessions", "off", "Readers: 'track' fetch session (incremental fetch) usage and evictions in status, or 'require' them to be used, failing validation otherwise")
+	hotPartition       = flag.Int("hot-partition", -1, "Producer: send --hot-fraction of messages to this partition, for testing under partition skew (-1 to spread evenly)")
+	hotFraction        = flag.Float64("hot-fraction", 0.8, "Producer: with --hot-partition, the fraction of messages sent to the hot partition")
+	coordinateGroup    = flag.String("coordinate-group", "", "Share the topic's partitions with other verifier processes using this coordination group name: each producer and sequential/random reader works only on its own share")
+	coordinateSettle   = flag.Duration("coordinate-settle", 10*time.Second, "With --coordinate-group, how long the partition assignment must be stable before starting work")
+	lagGroup           = flag.String("lag-group", "", "Producer: pause while this consumer group's lag on the topic exceeds --lag-pause messages, resuming when it falls to --lag-resume")
+	lagPause           = flag.Int64("lag-pause", 100000, "Producer: with --lag-group, pause while the group's lag exceeds this many messages")
+	lagResume          = flag.Int64("lag-resume", -1, "Producer: with --lag-group, resume once the group's lag is down to this many messages (-1 for half of --lag-pause)")
+	lagInterval        = flag.Duration("lag-interval", time.Second, "Producer: with --lag-group, how often to check the group's lag")
+	processingFailures = flag.Float64("processing-failure-fraction", 0, "Sequential readers: pretend to fail processing this fraction of records, retrying each by pausing its partition and seeking back, and check every record is processed exactly once")
+	processingBackoff  = flag.Duration("processing-retry-backoff", 100*time.Millisecond, "Sequential readers: with --processing-failure-fraction, how long to wait before retrying a failed record")
+	auditTime          = flag.Duration("audit-time-budget", 10*time.Minute, "audit: give up if the audit takes longer than this")
+	auditBytes         = flag.String("audit-byte-budget", "", "audit: give up after reading this much data, e.g. 10GB (default no limit)")
+	auditConcurrency   = flag.Int("audit-max-concurrency", 16, "audit: read at most this many partitions at once, adding readers only while they add throughput")
+	dlqTopic           = flag.String("dlq-topic", "", "Readers: send records that fail validation to this topic, with headers saying why and where they came from, and carry on; fail at the end of the pass if there were any")
+	scrubRate          = flag.Float64("scrub-rate", 0, "Throughout the run, re-read random windows of acked offsets at this many records/s in the background, to catch data that changes after it was validated (0 to disable)")
+	ephemeralPrefix    = flag.String("ephemeral-topic-prefix", "", "Instead of --topic, create a uniquely named topic with this prefix for the run, and delete it afterwards")
+	ephemeralParts     = flag.Int("ephemeral-topic-partitions", 16, "With --ephemeral-topic-prefix, how many partitions the topic has")
+	ephemeralReplicas  = flag.Int("ephemeral-topic-replicas", -1, "With --ephemeral-topic-prefix, the topic's replication factor (-1 for the cluster default)")
+	ephemeralKeep      = flag.Bool("ephemeral-topic-keep-on-failure", false, "With --ephemeral-topic-prefix, keep the topic if the run fails, for investigation")
+	tenants            = flag.Int("tenants", 0, "Instead of the usual workers, run this many small tenants at once, each producing to and validating its own topic <topic>-tenant-NNN with its own client ID")
+	tenantPartitions   = flag.Int("tenant-partitions", 1, "With --tenants, partitions for tenant topics that don't exist yet")
+	tenantReplicas     = flag.Int("tenant-replicas", -1, "With --tenants, replication factor for tenant topics that don't exist yet (-1 for the cluster default)")
+	tenantMsgs         = flag.Int("tenant-msgs", 1000, "With --tenants, how many messages each tenant produces and then validates")
+	tenantRateMsgs     = flag.Float64("tenant-rate-msgs", 10, "With --tenants, cap each tenant's produce rate to this many messages/s (0 for no limit)")
+	tenantUser         = flag.String("tenant-user", "", "With --tenants, SASL username for each tenant, with %d for the tenant number, e.g. 'tenant-%d' (default --username)")
+	tenantPassword     = flag.String("tenant-password", "", "With --tenant-user, SASL password for each tenant, with %d for the tenant number")
+	preHook            = flag.String("pre-hook", "", "Run this shell command once the topic is ready, before any work starts (failing the run if it fails), with the run's details in KGO_VERIFIER_HOOK_* environment variables")
+	postHook           = flag.String("post-hook", "", "Run this shell command on the way out, however the run ends, with the run's details and KGO_VERIFIER_HOOK_EXIT_REASON in its environment")
+	recordTrace        = flag.String("record", "", "Producer: write each record's partition, size and timing to this workload trace file, for --replay")
+	replayTrace        = flag.String("replay", "", "Producer: instead of choosing partitions, sizes and counts, produce exactly what this workload trace recorded, with the same timing")
+	replaySpeed        = flag.String("replay-speed", "1", "Producer: with --replay, replay this many times faster than recorded (e.g. 0.5 or 2), or 'max' for as fast as possible")
 )
 
+// How many times in a row to retry a raw read or replica comparison pass
+// that failed, e.g. on an unavailable cluster, before giving up
+const maxPassRetries = 10
+
+// What brokers accept as a client software name or version (KIP-511)
+var softwareNameRegex = regexp.MustCompile(`^[a-zA-Z0-9](?:[a-zA-Z0-9.-]*[a-zA-Z0-9])?$`)
+
+// Set once we have joined the coordination group, if any
+var coordinator *worker.Coordinator
+
+// Parsed from --compression
+var compressionCodec kgo.CompressionCodec
+
+// Set with --freeze-on-failure
+var freeze *worker.Freeze
+
+// Set once workers are about to start, with --max-anomalies
+var anomalies *worker.AnomalyBudget
+
+// Parsed from --max-bytes-per-sec
+var produceByteRate int64
+
 func makeWorkerConfig() worker.WorkerConfig {
 	c := worker.WorkerConfig{
-		Brokers:            *brokers,
-		Trace:              *trace,
-		Topic:              *topic,
-		Linger:             *linger,
-		MaxBufferedRecords: *maxBufferedRecords,
-		BatchMaxbytes:      uint(*batchMaxBytes),
-		SaslUser:           *username,
-		SaslPass:           *password,
-		Name:               *name,
+		Brokers:                   *brokers,
+		Trace:                     *trace,
+		Topic:                     *topic,
+		Linger:                    *linger,
+		MaxBufferedRecords:        *maxBufferedRecords,
+		BatchMaxbytes:             uint(*batchMaxBytes),
+		SaslUser:                  *username,
+		SaslPass:                  *password,
+		Name:                      *name,
+		SoftwareName:              *softwareName,
+		SoftwareVersion:           *softwareVersion,
+		RefetchInvalid:            *refetchInvalid || *refetchFollower,
+		RefetchFollower:           *refetchFollower,
+		ProcessingFailureFraction: *processingFailures,
+		ProcessingRetryBackoff:    *processingBackoff,
+		DeadLetterTopic:           *dlqTopic,
+		StuckPartitionWindow:      *stuckWindow,
+		StuckPartitionRecover:     *stuckRecover,
+		ArrivalSkewFactor:         *skewFactor,
+		ArrivalSkewMin:            *skewMin,
+		TopicChecksum:             *topicChecksum,
+		SessionTimeout:            *sessionTimeout,
+		HeartbeatInterval:         *heartbeatInterval,
+		RebalanceTimeout:          *rebalanceTimeout,
+		RunId:                     *runId,
+		OffsetsUrl:                *offsetsUrl,
+		ExpectationsFile:          *expectations,
+		LegacyKeys:                *legacyKeys,
+		ForeignRecords:            *foreignRecords,
+		PanicRestarts:             *panicRestarts,
+		RequestRetries:            *requestRetries,
+		RetryTimeout:              *retryTimeout,
+		RetryBackoffMin:           *retryBackoffMin,
+		RetryBackoffMax:           *retryBackoffMax,
+		RequestTimeoutOverhead:    *requestTimeout,
+		Retries:                   worker.NewRetryStats(),
+		ClientStats:               worker.NewClientStats(),
+		Anomalies:                 anomalies,
+		Freeze:                    freeze,
+		SLIs:                      worker.NewSLIStats(),
+		Coordinator:               coordinator,
+		Compression:               compressionCodec,
+		LatencyWeightThreshold:    *latencyWeighting,
+		ProduceMsgRate:            *maxMsgsPerSec,
+		ProduceByteRate:           float64(produceByteRate),
+		ProduceRack:               *produceRack,
+		Seed:                      *seed,
+	}
+
+	if *clientId != "" {
+		c.Name = *clientId
+	}
+
+	switch *fetchSessions {
+	case "off":
+	case "track", "require":
+		c.FetchSessions = worker.NewFetchSessionStats()
+		c.RequireFetchSessions = *fetchSessions == "require"
+	default:
+		util.DieConfig("Unknown fetch session mode '%s'", *fetchSessions)
+	}
+
+	if *produceRateMsgs > 0 || *produceRateMb > 0 || *fetchRateMb > 0 {
+		c.RateLimits = worker.NewClientRateLimits(*produceRateMsgs, *produceRateMb*1024*1024, *fetchRateMb*1024*1024)
 	}
 
 	return c
 }
 
+// Parse a comma separated list of partition IDs from an HTTP query parameter
+func parsePartitions(s string) ([]int32, error) {
+	var partitions []int32
+	for _, f := range strings.Split(s, ",") {
+		p, err := strconv.ParseInt(strings.TrimSpace(f), 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		partitions = append(partitions, int32(p))
+	}
+	return partitions, nil
+}
+
 func main() {
+	// Subcommands come first, e.g. kgo-verifier audit --brokers ...
+	audit := len(os.Args) > 1 && os.Args[1] == "audit"
+	if audit {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
 	flag.Parse()
+	util.FlagsFromEnv("KGO_VERIFIER")
+	util.SetExitFile(*exitFile)
+	defer util.WriteExitFile(util.ExitOk, "")
+	defer util.RunExitHooks(util.ExitOk)
+
+	if *ephemeralPrefix != "" {
+		if *topic != "" {
+			util.DieConfig("Use only one of -topic and -ephemeral-topic-prefix")
+		}
+		if *offlineDump != "" {
+			util.DieConfig("Ephemeral topics can't be used with -offline-dump")
+		}
+		if audit {
+			util.DieConfig("Ephemeral topics can't be audited")
+		}
+		*topic = fmt.Sprintf("%s-%d-%d", *ephemeralPrefix, time.Now().Unix(), os.Getpid())
+	} else if *topic == "" {
+		util.DieConfig("No topic specified (use -topic)")
+	}
 
-	if *topic == "" {
-		util.Die("No topic specified (use -topic)")
+	if (*softwareName == "") != (*softwareVersion == "") {
+		util.DieConfig("Use --software-name and --software-version together")
+	}
+	for _, s := range []string{*softwareName, *softwareVersion} {
+		if s != "" && !softwareNameRegex.MatchString(s) {
+			util.DieConfig("Bad client software name or version '%s': must match %s", s, softwareNameRegex)
+		}
+	}
+
+	enabledSLIs, err := worker.ParseSLIs(*slis)
+	if err != nil {
+		util.DieConfig("%v", err)
+	}
+
+	compressionCodec, err = worker.ParseCompression(*compression)
+	if err != nil {
+		util.DieConfig("%v", err)
+	}
+
+	if *maxBytesPerSec != "" {
+		produceByteRate, err = util.ParseBytes(*maxBytesPerSec)
+		if err != nil {
+			util.DieConfig("%v", err)
+		}
+	}
+
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
 	}
 
 	if *debug || *trace {
@@ -81,9 +312,58 @@ func main() {
 		log.SetLevel(log.InfoLevel)
 	}
 
+	log.AddHook(worker.PhaseLogHook{})
+
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, os.Interrupt)
 
+	if *freezeOnFailure {
+		freeze = worker.NewFreeze()
+		util.OnValidationFailure(freeze.Hold)
+		// Nor does finishing, if a background worker froze us
+		defer freeze.Wait()
+		go func() {
+			<-freeze.Frozen()
+			// Signals no longer stop workers gracefully: they end the run
+			signal.Stop(signalChan)
+			stop := make(chan os.Signal, 1)
+			signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+			log.Errorf("Workers frozen for inspection on port %d: send a signal to exit", *remotePort)
+			<-stop
+			util.DieWith(util.ExitValidation, "Stopped while frozen: %s", freeze.Status().Reason)
+		}()
+	}
+
+	if *startDelay > 0 || *startJitter > 0 {
+		wait := *startDelay
+		if *startJitter > 0 {
+			r := rand.New(rand.NewSource(time.Now().UnixNano()))
+			wait += time.Duration(r.Int63n(int64(*startJitter)))
+		}
+		log.Infof("Delaying start by %v", wait)
+		select {
+		case <-signalChan:
+			log.Info("Stopping on signal...")
+			return
+		case <-time.After(wait):
+		}
+	}
+
+	if *offlineDump != "" {
+		status, err := verifier.ValidateDump(verifier.NewOfflineValidateConfig(
+			makeWorkerConfig(), *offlineDump, verifier.DumpFormat(*dumpFormat), int32(*dumpPartition)))
+		serialized, serr := json.Marshal(status)
+		util.Chk(serr, "Status serialization error")
+		log.Infof("Offline validation status: %s", serialized)
+		if err != nil {
+			util.DieConfig("Error reading dump %s: %v", *offlineDump, err)
+		}
+		if status.BadCrcBatches > 0 {
+			util.DieValidation("%d batches with bad CRCs in %s", status.BadCrcBatches, *offlineDump)
+		}
+		return
+	}
+
 	// Once we are done, keep the process alive until this channel is fired
 	shutdownChan := make(chan int, 1)
 
@@ -97,8 +377,29 @@ func main() {
 	client, err := kgo.NewClient(opts...)
 	util.Chk(err, "Error creating kafka client: %v", err)
 
+	if *tenants > 0 && *ephemeralPrefix != "" {
+		util.DieConfig("Tenants have topics of their own: don't use --tenants with --ephemeral-topic-prefix")
+	}
+
+	if *ephemeralPrefix != "" {
+		log.Infof("Creating ephemeral topic %s", *topic)
+		err := verifier.CreateTopic(client, *topic, int32(*ephemeralParts), int16(*ephemeralReplicas))
+		util.Chk(err, "Error creating topic %s: %v", *topic, err)
+		util.OnExit(func(reason util.ExitReason) {
+			if reason != util.ExitOk && *ephemeralKeep {
+				log.Infof("Keeping topic %s after failure (%s)", *topic, reason)
+				return
+			}
+			log.Infof("Deleting ephemeral topic %s", *topic)
+			if err := verifier.DeleteTopic(client, *topic); err != nil {
+				log.Warnf("Error deleting topic %s: %v", *topic, err)
+			}
+		})
+	}
+
+	// Tenants have topics of their own, so --topic is only their prefix
 	var t kmsg.MetadataResponseTopic
-	{
+	if *tenants == 0 {
 		req := kmsg.NewPtrMetadataRequest()
 		reqTopic := kmsg.NewMetadataRequestTopic()
 		reqTopic.Topic = kmsg.StringPtr(*topic)
@@ -118,7 +419,99 @@ func main() {
 	nPartitions := int32(len(t.Partitions))
 	log.Debugf("Targeting topic %s with %d partitions", *topic, nPartitions)
 
+	if *coordinateGroup != "" {
+		c, err := worker.NewCoordinator(makeWorkerConfig(), fmt.Sprintf("kgo-verifier-coordinate-%s", *coordinateGroup))
+		util.Chk(err, "Error joining coordination group: %v", err)
+		defer c.Close()
+		c.WaitSettled(*coordinateSettle)
+		coordinator = c
+	}
+
+	// Prefixed so as not to be taken for our own flags, if a hook runs
+	// another verifier
+	hookEnv := func(phase string) map[string]string {
+		return map[string]string{
+			"KGO_VERIFIER_HOOK_PHASE":      phase,
+			"KGO_VERIFIER_HOOK_BROKERS":    *brokers,
+			"KGO_VERIFIER_HOOK_TOPIC":      *topic,
+			"KGO_VERIFIER_HOOK_PARTITIONS": strconv.Itoa(int(nPartitions)),
+			"KGO_VERIFIER_HOOK_RUN_ID":     *runId,
+		}
+	}
+	if *preHook != "" {
+		log.Infof("Running pre-run hook: %s", *preHook)
+		if err := util.RunHook(*preHook, hookEnv("pre")); err != nil {
+			util.DieWith(util.ExitInfrastructure, "Pre-run hook failed: %v", err)
+		}
+	}
+	if *postHook != "" {
+		util.OnExit(func(reason util.ExitReason) {
+			log.Infof("Running post-run hook: %s", *postHook)
+			env := hookEnv("post")
+			env["KGO_VERIFIER_HOOK_EXIT_REASON"] = string(reason)
+			if err := util.RunHook(*postHook, env); err != nil {
+				log.Warnf("Post-run hook failed: %v", err)
+			}
+		})
+	}
+
 	var workers []worker.Worker
+	// Set once the producer is started, and read by the HTTP handlers.
+	var producer *verifier.ProducerWorker
+	var producerLock sync.Mutex
+
+	resources := worker.NewResourceMonitor(*maxHeapMb*1024*1024, *maxGoroutines)
+	if *resourceHost {
+		if *resourceInterval <= 0 {
+			util.DieConfig("--resource-host needs a non-zero --resource-interval")
+		}
+		err := resources.EnableHostStats()
+		util.Chk(err, "Error reading host resource counters: %v", err)
+		util.AddExitDetail("resources", func() interface{} {
+			return resources.Status()
+		})
+	}
+	if *resourceInterval > 0 {
+		go resources.Run(context.Background(), *resourceInterval)
+	}
+
+	if *maxAnomalies > 0 {
+		anomalies = worker.NewAnomalyBudget(int64(*maxAnomalies), func(status worker.AnomalyStatus) {
+			for _, w := range workers {
+				serialized, err := json.Marshal(w.GetStatus())
+				util.Chk(err, "Status serialization error")
+				log.Infof("Worker status: %s", serialized)
+			}
+			serialized, err := json.Marshal(status)
+			util.Chk(err, "Status serialization error")
+			log.Infof("Anomalies: %s", serialized)
+			util.DieValidation("Stopping after %d anomalies (--max-anomalies %d): the first, at %s, was %s",
+				status.Count, status.Max, status.FirstTime.Format(time.RFC3339), status.First)
+		})
+	}
+
+	// A worker panicked more often than --panic-restarts allows: log the
+	// final status of every worker, and in remote mode stay up until told
+	// to shut down so that the status can still be collected.
+	checkPanic := func(err error) {
+		perr, ok := err.(*worker.PanicError)
+		if !ok {
+			return
+		}
+		for _, v := range workers {
+			serialized, err := json.Marshal(v.GetStatus())
+			util.Chk(err, "Status serialization error")
+			log.Infof("Final status: %s", serialized)
+		}
+		if *remote {
+			log.Info("Waiting for remote shutdown request")
+			select {
+			case <-signalChan:
+			case <-shutdownChan:
+			}
+		}
+		util.DieWith(util.ExitInternal, "%v", perr)
+	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
@@ -134,6 +527,69 @@ func main() {
 		w.Write(serialized)
 	})
 
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		stats := make(map[string]*worker.ClientStats)
+		for i, v := range workers {
+			if csw, ok := v.(worker.ClientStatsWorker); ok {
+				stats[fmt.Sprintf("%d", i)] = csw.GetClientStats()
+			}
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.WriteHeader(http.StatusOK)
+		worker.WritePrometheus(w, stats)
+		worker.WritePhasePrometheus(w)
+	})
+
+	mux.HandleFunc("/slis", func(w http.ResponseWriter, r *http.Request) {
+		stats := make(map[string]*worker.SLIStats)
+		for i, v := range workers {
+			if sw, ok := v.(worker.SLIWorker); ok {
+				stats[fmt.Sprintf("%d", i)] = sw.GetSLIStats()
+			}
+		}
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		worker.WriteOpenMetrics(w, stats, enabledSLIs)
+	})
+
+	// With ?label=..., set the phase label attached to what we record from
+	// now on; either way, the current phase and its history
+	var phaseExitDetail sync.Once
+	mux.HandleFunc("/phase", func(w http.ResponseWriter, r *http.Request) {
+		if labels, ok := r.URL.Query()["label"]; ok {
+			log.Infof("Remote request /phase: '%s'", labels[0])
+			worker.SetPhase(labels[0])
+			phaseExitDetail.Do(func() {
+				util.AddExitDetail("phases", func() interface{} {
+					return worker.Phases()
+				})
+			})
+		}
+		serialized, err := json.MarshalIndent(worker.Phases(), "", "  ")
+		util.Chk(err, "Status serialization error")
+		w.WriteHeader(http.StatusOK)
+		w.Write(serialized)
+	})
+
+	mux.HandleFunc("/freeze", func(w http.ResponseWriter, r *http.Request) {
+		var status worker.FreezeStatus
+		if freeze != nil {
+			status = freeze.Status()
+		}
+		serialized, err := json.MarshalIndent(status, "", "  ")
+		util.Chk(err, "Status serialization error")
+		w.WriteHeader(http.StatusOK)
+		w.Write(serialized)
+	})
+
+	mux.HandleFunc("/resources", func(w http.ResponseWriter, r *http.Request) {
+		serialized, err := json.MarshalIndent(resources.Status(), "", "  ")
+		util.Chk(err, "Status serialization error")
+
+		w.WriteHeader(http.StatusOK)
+		w.Write(serialized)
+	})
+
 	mux.HandleFunc("/reset", func(w http.ResponseWriter, r *http.Request) {
 		log.Info("Remote request /reset")
 		for _, v := range workers {
@@ -152,21 +608,274 @@ func main() {
 		lastPassChan <- 1
 	})
 
+	maintenance := worker.NewMaintenanceWindow()
+	mux.HandleFunc("/maintenance/start", func(w http.ResponseWriter, r *http.Request) {
+		log.Info("Remote request /maintenance/start")
+		if err := maintenance.Start(); err != nil {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	maintenanceReport := func(w http.ResponseWriter, report worker.MaintenanceReport) {
+		serialized, err := json.MarshalIndent(report, "", "  ")
+		util.Chk(err, "Status serialization error")
+
+		w.WriteHeader(http.StatusOK)
+		w.Write(serialized)
+	}
+	mux.HandleFunc("/maintenance/stop", func(w http.ResponseWriter, r *http.Request) {
+		log.Info("Remote request /maintenance/stop")
+		report, err := maintenance.Stop()
+		if err != nil {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		serialized, err := json.Marshal(report)
+		util.Chk(err, "Status serialization error")
+		log.Infof("Maintenance window report: %s", serialized)
+		maintenanceReport(w, report)
+	})
+	mux.HandleFunc("/maintenance", func(w http.ResponseWriter, r *http.Request) {
+		maintenanceReport(w, maintenance.Report())
+	})
+
+	mux.HandleFunc("/valid_offsets", func(w http.ResponseWriter, r *http.Request) {
+		producerLock.Lock()
+		pw := producer
+		producerLock.Unlock()
+		if pw == nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("No producer running"))
+			return
+		}
+		serialized, err := pw.ValidOffsetsJSON()
+		util.Chk(err, "Offsets serialization error")
+
+		w.WriteHeader(http.StatusOK)
+		w.Write(serialized)
+	})
+
+	pauseHandler := func(pause bool) func(w http.ResponseWriter, r *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) {
+			partitions, err := parsePartitions(r.URL.Query().Get("partitions"))
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(fmt.Sprintf("Bad partitions parameter: %v", err)))
+				return
+			}
+			log.Infof("Remote request %s %v", r.URL.Path, partitions)
+
+			var results [][]int32
+			for _, v := range workers {
+				if pw, ok := v.(worker.PausableWorker); ok {
+					if pause {
+						results = append(results, pw.PausePartitions(partitions))
+					} else {
+						results = append(results, pw.ResumePartitions(partitions))
+					}
+				}
+			}
+
+			serialized, err := json.MarshalIndent(results, "", "  ")
+			util.Chk(err, "Status serialization error")
+
+			w.WriteHeader(http.StatusOK)
+			w.Write(serialized)
+		}
+	}
+	mux.HandleFunc("/pause", pauseHandler(true))
+	mux.HandleFunc("/resume", pauseHandler(false))
+
 	go http.ListenAndServe(fmt.Sprintf("0.0.0.0:%d", *remotePort), mux)
 
+	if *tenants > 0 {
+		tw := verifier.NewTenantWorker(verifier.NewTenantConfig(conf, *tenants, int32(*tenantPartitions), int16(*tenantReplicas),
+			*mSize, *tenantMsgs, *tenantRateMsgs, *tenantUser, *tenantPassword))
+		workers = append(workers, tw)
+		waitErr := tw.Wait()
+		serialized, err := json.Marshal(tw.GetStatus())
+		util.Chk(err, "Status serialization error")
+		log.Infof("Tenant status: %s", serialized)
+		if waitErr != nil {
+			util.DieWith(util.ExitInfrastructure, "Tenants failed: %v", waitErr)
+		}
+		return
+	}
+
+	if audit {
+		var byteBudget int64
+		if *auditBytes != "" {
+			byteBudget, err = util.ParseBytes(*auditBytes)
+			if err != nil {
+				util.DieConfig("%v", err)
+			}
+		}
+		if *auditConcurrency < 1 {
+			util.DieConfig("Audit concurrency must be at least 1")
+		}
+		aw := verifier.NewAuditWorker(verifier.NewAuditConfig(makeWorkerConfig(), "audit", nPartitions,
+			*auditTime, byteBudget, *auditConcurrency))
+		workers = append(workers, &aw)
+		waitErr := aw.Wait()
+		serialized, err := json.Marshal(aw.GetStatus())
+		util.Chk(err, "Status serialization error")
+		log.Infof("Audit status: %s", serialized)
+		if waitErr != nil {
+			util.DieWith(util.ExitInfrastructure, "Audit incomplete: %v", waitErr)
+		}
+		log.Info("Audit complete")
+		return
+	}
+
+	var scrubber *verifier.ScrubWorker
+	if *scrubRate > 0 {
+		sw := verifier.NewScrubWorker(verifier.NewScrubConfig(makeWorkerConfig(), "scrubber", nPartitions, *scrubRate))
+		scrubber = &sw
+		workers = append(workers, scrubber)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go scrubber.Run(ctx)
+	}
+
+	format, err := verifier.ParsePayloadFormat(*payloadFormat)
+	if err != nil {
+		util.DieConfig("%v", err)
+	}
+	var sizes *verifier.SizeDistribution
+	meanSize := *mSize
+	if *mSizeDist != "" {
+		sizes, err = verifier.ParseSizeDistribution(*mSizeDist)
+		if err != nil {
+			util.DieConfig("%v", err)
+		}
+		meanSize = sizes.Mean()
+	}
+	if *pBytes != "" {
+		if *pCount > 0 {
+			util.DieConfig("Use only one of --produce_msgs and --produce-bytes")
+		}
+		totalBytes, err := util.ParseBytes(*pBytes)
+		if err != nil {
+			util.DieConfig("%v", err)
+		}
+		*pCount = verifier.MessagesForBytes(totalBytes, format, meanSize)
+		log.Infof("Producing %d messages for %s", *pCount, *pBytes)
+	}
+
+	var traceReplay *worker.TraceReplay
+	if *replayTrace != "" {
+		if *pCount > 0 {
+			util.DieConfig("--replay decides how much to produce: don't use it with --produce_msgs or --produce-bytes")
+		}
+		if *coordinateGroup != "" || *hotPartition >= 0 || *latencyWeighting > 0 || *produceRack != "" {
+			util.DieConfig("--replay decides which partitions to produce to: don't use it with --coordinate-group, --hot-partition, --latency-weight-threshold or --produce-rack")
+		}
+		speed, err := worker.ParseReplaySpeed(*replaySpeed)
+		if err != nil {
+			util.DieConfig("%v", err)
+		}
+		traceReplay, err = worker.LoadTrace(*replayTrace)
+		if err != nil {
+			util.DieConfig("Error loading workload trace: %v", err)
+		}
+		traceReplay.SetSpeed(speed)
+		if max := traceReplay.MaxPartition(); max >= nPartitions {
+			util.DieConfig("Workload trace produces to partition %d, topic has %d partitions", max, nPartitions)
+		}
+		*pCount = traceReplay.Len()
+		log.Infof("Replaying %d messages from %s", *pCount, *replayTrace)
+	}
+
 	if *pCount > 0 {
-		log.Info("Starting producer...")
-		pwc := verifier.NewProducerConfig(makeWorkerConfig(), "producer", nPartitions, *mSize, *pCount, *fakeTimestampMs)
+		log.Infof("Starting producer (seed %d)...", *seed)
+		pwcfg := makeWorkerConfig()
+		pwcfg.Maintenance = maintenance
+		pwcfg.TraceReplay = traceReplay
+		if *recordTrace != "" {
+			recorder, err := worker.NewTraceRecorder(*recordTrace)
+			util.Chk(err, "Error creating workload trace: %v", err)
+			pwcfg.TraceRecorder = recorder
+			// Keep what we have if we are stopped early
+			util.OnExit(func(reason util.ExitReason) {
+				if err := recorder.Close(); err != nil {
+					log.Warnf("Error writing workload trace: %v", err)
+				}
+			})
+		}
+		if *lagGroup != "" {
+			resumeAt := *lagResume
+			if resumeAt < 0 {
+				resumeAt = *lagPause / 2
+			}
+			lagCfg := makeWorkerConfig()
+			lagClient, err := kgo.NewClient(lagCfg.MakeKgoOpts()...)
+			util.Chk(err, "Error creating Kafka client: %v", err)
+			pwcfg.LagGate = worker.NewLagGate(*topic, *lagGroup, *lagPause, resumeAt)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go pwcfg.LagGate.Run(ctx, lagClient, *lagInterval)
+		}
+		if *hotPartition >= int(nPartitions) {
+			util.DieConfig("Hot partition %d out of range, topic has %d partitions", *hotPartition, nPartitions)
+		}
+		if *hotFraction < 0 || *hotFraction > 1 {
+			util.DieConfig("Hot fraction must be between 0 and 1")
+		}
+		pwc := verifier.NewProducerConfig(pwcfg, "producer", nPartitions, *mSize, sizes, *pCount, *fakeTimestampMs, format,
+			int32(*hotPartition), *hotFraction)
 		pw := verifier.NewProducerWorker(pwc)
 		workers = append(workers, &pw)
+		producerLock.Lock()
+		producer = &pw
+		producerLock.Unlock()
+
+		// On a signal, let what is in flight be acked and checkpointed
+		// rather than losing track of it.  SIGTERM is only caught while we
+		// produce: otherwise it kills us as it always has.
+		termChan := make(chan os.Signal, 1)
+		signal.Notify(termChan, syscall.SIGTERM)
+		produced := make(chan struct{})
+		go func() {
+			select {
+			case <-signalChan:
+			case <-termChan:
+			case <-produced:
+				return
+			}
+			log.Info("Stopping producer on signal, waiting for acks...")
+			pw.Stop()
+		}()
 		waitErr := pw.Wait()
-		util.Chk(err, "Producer error: %v", waitErr)
+		close(produced)
+		signal.Stop(termChan)
+		checkPanic(waitErr)
+		if waitErr != nil {
+			util.DieWith(util.ExitInfrastructure, "Producer error: %v", waitErr)
+		}
+		if recorder := pwcfg.TraceRecorder; recorder != nil {
+			err := recorder.Close()
+			util.Chk(err, "Error writing workload trace: %v", err)
+			log.Infof("Recorded %d operations to %s", recorder.Ops(), *recordTrace)
+		}
+		if pw.Stopped() {
+			serialized, err := json.Marshal(pw.GetStatus())
+			util.Chk(err, "Status serialization error")
+			log.Infof("Final producer status: %s", serialized)
+			if *seqRead || *rawRead || *replicaCompare || *cCount > 0 || *cgReaders > 0 {
+				log.Info("Stopped on signal, not starting readers")
+			}
+			return
+		}
 		log.Info("Finished producer.")
 	}
 
 	if *seqRead {
 		srw := verifier.NewSeqReadWorker(verifier.NewSeqReadConfig(
-			makeWorkerConfig(), "sequential", nPartitions,
+			makeWorkerConfig(), "sequential", nPartitions, *seqReadShards,
 		))
 		workers = append(workers, &srw)
 
@@ -175,10 +884,59 @@ func main() {
 			log.Info("Starting sequential read pass")
 			firstPass = false
 			waitErr := srw.Wait()
+			checkPanic(waitErr)
 			if waitErr != nil {
 				// Proceed around the loop, to be tolerant of e.g. kafka client
 				// construct failures on unavailable cluster
-				log.Warnf("Error from sequeqntial read worker: %v", err)
+				log.Warnf("Error from sequential read worker: %v", waitErr)
+			}
+		}
+	}
+
+	if *rawRead {
+		rrw := verifier.NewRawReadWorker(verifier.NewRawReadConfig(makeWorkerConfig(), "raw", nPartitions))
+		workers = append(workers, &rrw)
+
+		firstPass := true
+		retries := 0
+		for firstPass || (len(lastPassChan) == 0 && *loop) {
+			log.Info("Starting raw read pass")
+			firstPass = false
+			waitErr := rrw.Wait()
+			checkPanic(waitErr)
+			if waitErr != nil {
+				retries += 1
+				if retries > maxPassRetries {
+					util.DieWith(util.ExitInfrastructure, "Raw read worker failed %d passes in a row: %v", retries, waitErr)
+				}
+				log.Warnf("Error from raw read worker, retrying pass: %v", waitErr)
+				firstPass = true
+			} else {
+				retries = 0
+			}
+		}
+	}
+
+	if *replicaCompare {
+		rcw := verifier.NewReplicaCompareWorker(verifier.NewReplicaCompareConfig(makeWorkerConfig(), "replicas", nPartitions))
+		workers = append(workers, &rcw)
+
+		firstPass := true
+		retries := 0
+		for firstPass || (len(lastPassChan) == 0 && *loop) {
+			log.Info("Starting replica comparison pass")
+			firstPass = false
+			waitErr := rcw.Wait()
+			checkPanic(waitErr)
+			if waitErr != nil {
+				retries += 1
+				if retries > maxPassRetries {
+					util.DieWith(util.ExitInfrastructure, "Replica comparison worker failed %d passes in a row: %v", retries, waitErr)
+				}
+				log.Warnf("Error from replica comparison worker, retrying pass: %v", waitErr)
+				firstPass = true
+			} else {
+				retries = 0
 			}
 		}
 	}
@@ -202,10 +960,11 @@ func main() {
 				wg.Add(1)
 				go func(worker *verifier.RandomReadWorker) {
 					waitErr := worker.Wait()
+					checkPanic(waitErr)
 					if waitErr != nil {
 						// Proceed around the loop, to be tolerant of e.g. kafka client
 						// construct failures on unavailable cluster
-						log.Warnf("Error from random worker: %v", err)
+						log.Warnf("Error from random worker: %v", waitErr)
 					}
 					worker.Status.Validator.Checkpoint()
 					wg.Done()
@@ -216,10 +975,11 @@ func main() {
 	}
 
 	if *cgReaders > 0 {
-		grw := verifier.NewGroupReadWorker(verifier.NewGroupReadConfig(makeWorkerConfig(), "groupReader", nPartitions, *cgReaders))
+		grw := verifier.NewGroupReadWorker(verifier.NewGroupReadConfig(makeWorkerConfig(), "groupReader", nPartitions, *cgReaders, *evictionTest))
 		workers = append(workers, &grw)
 		waitErr := grw.Wait()
-		util.Chk(waitErr, "Consumer error: %v", err)
+		checkPanic(waitErr)
+		util.Chk(waitErr, "Consumer error: %v", waitErr)
 	}
 
 	if *remote {
@@ -233,4 +993,10 @@ func main() {
 		}
 	}
 
+	if scrubber != nil {
+		serialized, err := json.Marshal(scrubber.GetStatus())
+		util.Chk(err, "Status serialization error")
+		log.Infof("Scrub status: %s", serialized)
+		scrubber.Check()
+	}
 }
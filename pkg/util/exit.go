@@ -0,0 +1,155 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Why the process exited, for test harnesses and job controllers that want
+// to tell a bug in the system under test from a problem with the test itself.
+type ExitReason string
+
+const (
+	ExitOk ExitReason = "ok"
+
+	// Couldn't talk to the cluster, or something else went wrong that
+	// says nothing either way about the correctness of the data
+	ExitInfrastructure ExitReason = "infrastructure"
+
+	// The data read back was not what was written: a potential bug
+	ExitValidation ExitReason = "validation"
+
+	// Bad flags or inputs
+	ExitConfig ExitReason = "config"
+
+	// A bug in the verifier itself, such as a panic
+	ExitInternal ExitReason = "internal"
+)
+
+var exitCodes = map[ExitReason]int{
+	ExitOk:             0,
+	ExitInfrastructure: 1,
+	ExitValidation:     2,
+	ExitConfig:         3,
+	ExitInternal:       4,
+}
+
+func (r ExitReason) Code() int {
+	return exitCodes[r]
+}
+
+type ExitStatus struct {
+	Reason  ExitReason `json:"reason"`
+	Code    int        `json:"code"`
+	Message string     `json:"message"`
+	Time    time.Time  `json:"time"`
+
+	// Anything else registered with AddExitDetail, by name
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+var exitFile string
+
+// If set, the reason for exiting is written to this path as JSON on the way out
+func SetExitFile(path string) {
+	exitFile = path
+}
+
+// Details may be added while running, e.g. from HTTP handlers, as we exit
+var exitDetailsLock sync.Mutex
+var exitDetails = map[string]func() interface{}{}
+
+// Include what f returns in the exit file under this name, e.g. to keep
+// a run's measurements with its result
+func AddExitDetail(name string, f func() interface{}) {
+	exitDetailsLock.Lock()
+	defer exitDetailsLock.Unlock()
+	exitDetails[name] = f
+}
+
+func WriteExitFile(reason ExitReason, message string) {
+	if exitFile == "" {
+		return
+	}
+	status := ExitStatus{
+		Reason:  reason,
+		Code:    reason.Code(),
+		Message: message,
+		Time:    time.Now(),
+	}
+	exitDetailsLock.Lock()
+	details := make(map[string]func() interface{}, len(exitDetails))
+	for name, f := range exitDetails {
+		details[name] = f
+	}
+	exitDetailsLock.Unlock()
+	if len(details) > 0 {
+		status.Details = make(map[string]interface{})
+		for name, f := range details {
+			status.Details[name] = f()
+		}
+	}
+	data, err := json.Marshal(status)
+	if err == nil {
+		err = ioutil.WriteFile(exitFile, data, 0644)
+	}
+	if err != nil {
+		log.Errorf("Error writing exit file %s: %v", exitFile, err)
+	}
+}
+
+// Several workers may die at once, each running the hooks
+var exitHooksLock sync.Mutex
+var exitHooks []func(ExitReason)
+
+// Run f on the way out, whether we exit normally (via RunExitHooks) or die
+func OnExit(f func(ExitReason)) {
+	exitHooksLock.Lock()
+	defer exitHooksLock.Unlock()
+	exitHooks = append(exitHooks, f)
+}
+
+// Each hook runs at most once, even if one of them dies
+func RunExitHooks(reason ExitReason) {
+	exitHooksLock.Lock()
+	hooks := exitHooks
+	exitHooks = nil
+	exitHooksLock.Unlock()
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hooks[i](reason)
+	}
+}
+
+func DieWith(reason ExitReason, msg string, args ...interface{}) {
+	formatted := fmt.Sprintf(msg, args...)
+	log.Error(formatted)
+	RunExitHooks(reason)
+	WriteExitFile(reason, formatted)
+	os.Exit(reason.Code())
+}
+
+var validationHook func(string)
+
+// Run f on a validation failure before dying: f may block, to hold on to
+// the process as it was when validation failed
+func OnValidationFailure(f func(message string)) {
+	validationHook = f
+}
+
+// Validation failed: the data read back is not what was written
+func DieValidation(msg string, args ...interface{}) {
+	if validationHook != nil {
+		validationHook(fmt.Sprintf(msg, args...))
+	}
+	DieWith(ExitValidation, msg, args...)
+}
+
+func DieConfig(msg string, args ...interface{}) {
+	DieWith(ExitConfig, msg, args...)
+}
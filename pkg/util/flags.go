@@ -0,0 +1,57 @@
+package util
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Name of the environment variable that may set a flag, e.g. with prefix
+// KGO_VERIFIER, flag "produce_msgs" is KGO_VERIFIER_PRODUCE_MSGS and
+// flag "remote-port" is KGO_VERIFIER_REMOTE_PORT
+func FlagEnvName(prefix string, name string) string {
+	name = strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+	return fmt.Sprintf("%s_%s", prefix, name)
+}
+
+// Give any flags not set on the command line a value from the environment,
+// for deployments (e.g. Kubernetes) where environment variables are more
+// convenient than arguments.  Call after flag.Parse.  In order of precedence:
+//
+//   - the command line
+//   - <PREFIX>_<FLAG>, the value itself
+//   - <PREFIX>_<FLAG>_FILE, a path to a file containing the value, for
+//     secrets such as passwords that are mounted as files
+//   - the flag's default
+func FlagsFromEnv(prefix string) {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	flag.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] {
+			return
+		}
+
+		envName := FlagEnvName(prefix, f.Name)
+		value, ok := os.LookupEnv(envName)
+		if !ok {
+			path, ok := os.LookupEnv(envName + "_FILE")
+			if !ok {
+				return
+			}
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				DieConfig("Error reading %s_FILE: %v", envName, err)
+			}
+			value = strings.TrimRight(string(data), "\r\n")
+		}
+
+		if err := f.Value.Set(value); err != nil {
+			DieConfig("Bad value for %s: %v", envName, err)
+		}
+	})
+}
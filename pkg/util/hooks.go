@@ -0,0 +1,26 @@
+package util
+
+import (
+	"os"
+	"os/exec"
+	"sort"
+)
+
+// Run a hook command with sh, with our output as its output and these
+// variables added to our environment
+func RunHook(command string, env map[string]string) error {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+
+	var names []string
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cmd.Env = append(cmd.Env, name+"="+env[name])
+	}
+	return cmd.Run()
+}
@@ -1,18 +1,8 @@
 
 package util
 
-import (
-	"fmt"
-	"os"
-
-	log "github.com/sirupsen/logrus"
-)
-
-
 func Die(msg string, args ...interface{}) {
-	formatted := fmt.Sprintf(msg, args...)
-	log.Error(formatted)
-	os.Exit(1)
+	DieWith(ExitInfrastructure, msg, args...)
 }
 
 func Chk(err error, msg string, args ...interface{}) {
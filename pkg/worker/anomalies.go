@@ -0,0 +1,87 @@
+package worker
+
+import (
+	"sync"
+	"time"
+)
+
+type AnomalyStatus struct {
+	// Anomalies seen, by kind, and how many we stop after
+	Count  int64            `json:"count"`
+	ByKind map[string]int64 `json:"by_kind"`
+	Max    int64            `json:"max"`
+
+	// Anomalies by the orchestrator's phase label when they were seen,
+	// if phases are set
+	ByPhase map[string]int64 `json:"by_phase,omitempty"`
+
+	// The first anomaly, closest to whatever went wrong
+	First      string    `json:"first"`
+	FirstTime  time.Time `json:"first_time"`
+	FirstPhase string    `json:"first_phase,omitempty"`
+}
+
+// Counts anomalies that workers carry on past, such as records sent to a
+// dead-letter topic or unexplained bad offsets, and stops the run once
+// there have been too many of them, rather than let it continue for hours
+// after things went wrong.  Nil-safe: without a budget, anomalies are only
+// counted by their workers.
+type AnomalyBudget struct {
+	lock      sync.Mutex
+	status    AnomalyStatus
+	exhausted bool
+
+	// Called once, on a goroutine of its own, when the budget runs out
+	onExhausted func(AnomalyStatus)
+}
+
+func NewAnomalyBudget(max int64, onExhausted func(AnomalyStatus)) *AnomalyBudget {
+	return &AnomalyBudget{
+		status:      AnomalyStatus{Max: max, ByKind: make(map[string]int64), ByPhase: make(map[string]int64)},
+		onExhausted: onExhausted,
+	}
+}
+
+// Callers may hold their own locks: the budget running out is handled
+// asynchronously
+func (ab *AnomalyBudget) Observe(kind string, description string) {
+	if ab == nil {
+		return
+	}
+	p := CurrentPhase()
+	ab.lock.Lock()
+	defer ab.lock.Unlock()
+	if ab.status.Count == 0 {
+		ab.status.First = description
+		ab.status.FirstTime = time.Now()
+		ab.status.FirstPhase = p
+	}
+	ab.status.Count += 1
+	ab.status.ByKind[kind] += 1
+	if p != "" {
+		ab.status.ByPhase[p] += 1
+	}
+	if ab.status.Count >= ab.status.Max && !ab.exhausted {
+		ab.exhausted = true
+		go ab.onExhausted(ab.statusLocked())
+	}
+}
+
+func (ab *AnomalyBudget) Status() AnomalyStatus {
+	ab.lock.Lock()
+	defer ab.lock.Unlock()
+	return ab.statusLocked()
+}
+
+func (ab *AnomalyBudget) statusLocked() AnomalyStatus {
+	status := ab.status
+	status.ByKind = make(map[string]int64)
+	for k, n := range ab.status.ByKind {
+		status.ByKind[k] = n
+	}
+	status.ByPhase = make(map[string]int64)
+	for p, n := range ab.status.ByPhase {
+		status.ByPhase[p] = n
+	}
+	return status
+}
@@ -0,0 +1,53 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// Rate caps enforced inside the client via hooks, to emulate a client held
+// to a quota: records are held up as they are produced, and byte rates are
+// enforced on what actually goes over the wire by holding up the connection
+// after each produce request is written or fetch response is read, much as
+// a broker enforcing a quota would.  Shared by all of a worker's clients.
+type ClientRateLimits struct {
+	produceMsgs  *RateLimiter
+	produceBytes *RateLimiter
+	fetchBytes   *RateLimiter
+}
+
+// Rates are per second, and zero for no limit
+func NewClientRateLimits(produceMsgs float64, produceBytes float64, fetchBytes float64) *ClientRateLimits {
+	rl := &ClientRateLimits{}
+	if produceMsgs > 0 {
+		rl.produceMsgs = NewRateLimiter(produceMsgs, 0)
+	}
+	if produceBytes > 0 {
+		rl.produceBytes = NewRateLimiter(produceBytes, 0)
+	}
+	if fetchBytes > 0 {
+		rl.fetchBytes = NewRateLimiter(fetchBytes, 0)
+	}
+	return rl
+}
+
+func (rl *ClientRateLimits) OnProduceRecordBuffered(r *kgo.Record) {
+	if rl.produceMsgs != nil {
+		rl.produceMsgs.Wait(context.Background(), 1)
+	}
+}
+
+func (rl *ClientRateLimits) OnBrokerWrite(meta kgo.BrokerMetadata, key int16, bytesWritten int, writeWait, timeToWrite time.Duration, err error) {
+	if rl.produceBytes != nil && key == kmsg.Produce.Int16() && err == nil {
+		rl.produceBytes.Wait(context.Background(), float64(bytesWritten))
+	}
+}
+
+func (rl *ClientRateLimits) OnBrokerRead(meta kgo.BrokerMetadata, key int16, bytesRead int, readWait, timeToRead time.Duration, err error) {
+	if rl.fetchBytes != nil && key == kmsg.Fetch.Int16() && err == nil {
+		rl.fetchBytes.Wait(context.Background(), float64(bytesRead))
+	}
+}
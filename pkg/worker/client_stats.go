@@ -0,0 +1,365 @@
+package worker
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	metrics "github.com/rcrowley/go-metrics"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// Statistics gathered from kgo hooks, shared by all of a worker's clients,
+// to see what is happening at the wire level rather than just what the
+// application sees.
+type ClientStats struct {
+	lock    sync.Mutex
+	brokers map[int32]*brokerStats
+
+	connects      int64
+	connectErrors int64
+	disconnects   int64
+
+	throttles    int64
+	throttleTime time.Duration
+
+	produced BatchTotals
+	fetched  BatchTotals
+
+	// Distributions of what we put on the wire, to check that linger and
+	// batching settings have the intended effect.  kgo doesn't tell us
+	// which batches went in the same request, so requests are measured in
+	// bytes rather than records.
+	produceBatchBytes   metrics.Histogram
+	produceBatchRecords metrics.Histogram
+	produceRequestBytes metrics.Histogram
+
+	// Each broker's latest produce request round trip, for splitting a
+	// record's ack latency into client and broker time
+	lastProduce map[int32]time.Duration
+}
+
+// Record batches written or read, and how well they compressed
+type BatchTotals struct {
+	Batches           int64 `json:"batches"`
+	Records           int64 `json:"records"`
+	UncompressedBytes int64 `json:"uncompressed_bytes"`
+	CompressedBytes   int64 `json:"compressed_bytes"`
+
+	// Uncompressed over compressed size, filled in for summaries
+	CompressionRatio float64 `json:"compression_ratio"`
+}
+
+func (bt *BatchTotals) add(records int, uncompressed int, compressed int) {
+	bt.Batches += 1
+	bt.Records += int64(records)
+	bt.UncompressedBytes += int64(uncompressed)
+	bt.CompressedBytes += int64(compressed)
+}
+
+func (bt BatchTotals) summary() BatchTotals {
+	if bt.CompressedBytes > 0 {
+		bt.CompressionRatio = float64(bt.UncompressedBytes) / float64(bt.CompressedBytes)
+	}
+	return bt
+}
+
+// Produce and fetch latency for one broker, so that one slow or failing
+// broker stands out.  Note that fetch latency includes the time the broker
+// waits for data to arrive, so on an idle topic it tends to the fetch max
+// wait.
+type brokerStats struct {
+	produceLatency metrics.Histogram
+	fetchLatency   metrics.Histogram
+	produceErrors  int64
+	fetchErrors    int64
+}
+
+type BrokerSummary struct {
+	ProduceLatency HistogramSummary `json:"produce_latency"`
+	FetchLatency   HistogramSummary `json:"fetch_latency"`
+	ProduceErrors  int64            `json:"produce_errors"`
+	FetchErrors    int64            `json:"fetch_errors"`
+}
+
+// Wire-level statistics from a worker's clients, embedded in its status
+// and filled in when status is requested
+type ClientSummary struct {
+	Brokers map[int32]BrokerSummary `json:"brokers"`
+
+	// Connections opened (and failed to open) and closed
+	Connects      int64 `json:"connects"`
+	ConnectErrors int64 `json:"connect_errors"`
+	Disconnects   int64 `json:"disconnects"`
+
+	// How many times were we throttled by quotas, and for how long in total
+	Throttles    int64 `json:"throttles"`
+	ThrottleTime int64 `json:"throttle_time_ms"`
+
+	Produced BatchTotals `json:"produced"`
+	Fetched  BatchTotals `json:"fetched"`
+
+	// Uncompressed bytes and records per produced batch, and bytes
+	// per produce request
+	ProduceBatchBytes   HistogramSummary `json:"produce_batch_bytes"`
+	ProduceBatchRecords HistogramSummary `json:"produce_batch_records"`
+	ProduceRequestBytes HistogramSummary `json:"produce_request_bytes"`
+}
+
+func NewClientStats() *ClientStats {
+	return &ClientStats{
+		brokers:             make(map[int32]*brokerStats),
+		lastProduce:         make(map[int32]time.Duration),
+		produceBatchBytes:   metrics.NewHistogram(metrics.NewExpDecaySample(1024, 0.015)),
+		produceBatchRecords: metrics.NewHistogram(metrics.NewExpDecaySample(1024, 0.015)),
+		produceRequestBytes: metrics.NewHistogram(metrics.NewExpDecaySample(1024, 0.015)),
+	}
+}
+
+func (cs *ClientStats) broker(id int32) *brokerStats {
+	b, ok := cs.brokers[id]
+	if !ok {
+		b = &brokerStats{
+			produceLatency: metrics.NewHistogram(metrics.NewExpDecaySample(1024, 0.015)),
+			fetchLatency:   metrics.NewHistogram(metrics.NewExpDecaySample(1024, 0.015)),
+		}
+		cs.brokers[id] = b
+	}
+	return b
+}
+
+func (cs *ClientStats) OnBrokerE2E(meta kgo.BrokerMetadata, key int16, e2e kgo.BrokerE2E) {
+	if key != kmsg.Produce.Int16() && key != kmsg.Fetch.Int16() {
+		return
+	}
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	b := cs.broker(meta.NodeID)
+	failed := e2e.Err() != nil
+	if key == kmsg.Produce.Int16() {
+		if failed {
+			b.produceErrors += 1
+		} else {
+			b.produceLatency.Update(e2e.DurationE2E().Microseconds())
+			cs.lastProduce[meta.NodeID] = e2e.DurationE2E()
+		}
+	} else {
+		if failed {
+			b.fetchErrors += 1
+		} else {
+			b.fetchLatency.Update(e2e.DurationE2E().Microseconds())
+		}
+	}
+}
+
+// The round trip of the latest produce request to a broker, from starting
+// to write it to reading its response.  Responses are handled as they are
+// read, so from an ack callback this is most likely the request that
+// carried the acked record.
+func (cs *ClientStats) LastProduceTime(broker int32) (time.Duration, bool) {
+	if cs == nil {
+		return 0, false
+	}
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	d, ok := cs.lastProduce[broker]
+	return d, ok
+}
+
+func (cs *ClientStats) OnBrokerConnect(meta kgo.BrokerMetadata, dialDur time.Duration, conn net.Conn, err error) {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	if err != nil {
+		cs.connectErrors += 1
+	} else {
+		cs.connects += 1
+	}
+}
+
+func (cs *ClientStats) OnBrokerDisconnect(meta kgo.BrokerMetadata, conn net.Conn) {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	cs.disconnects += 1
+}
+
+func (cs *ClientStats) OnBrokerThrottle(meta kgo.BrokerMetadata, throttleInterval time.Duration, throttledAfterResponse bool) {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	cs.throttles += 1
+	cs.throttleTime += throttleInterval
+}
+
+func (cs *ClientStats) OnProduceBatchWritten(meta kgo.BrokerMetadata, topic string, partition int32, m kgo.ProduceBatchMetrics) {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	cs.produced.add(m.NumRecords, m.UncompressedBytes, m.CompressedBytes)
+	cs.produceBatchBytes.Update(int64(m.UncompressedBytes))
+	cs.produceBatchRecords.Update(int64(m.NumRecords))
+}
+
+func (cs *ClientStats) OnBrokerWrite(meta kgo.BrokerMetadata, key int16, bytesWritten int, writeWait, timeToWrite time.Duration, err error) {
+	if key != kmsg.Produce.Int16() || err != nil {
+		return
+	}
+	// Histograms do their own locking
+	cs.produceRequestBytes.Update(int64(bytesWritten))
+}
+
+func (cs *ClientStats) OnFetchBatchRead(meta kgo.BrokerMetadata, topic string, partition int32, m kgo.FetchBatchMetrics) {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	cs.fetched.add(m.NumRecords, m.UncompressedBytes, m.CompressedBytes)
+}
+
+func (cs *ClientStats) Summary() ClientSummary {
+	r := ClientSummary{Brokers: make(map[int32]BrokerSummary)}
+	if cs == nil {
+		return r
+	}
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	for id, b := range cs.brokers {
+		r.Brokers[id] = BrokerSummary{
+			ProduceLatency: SummarizeHistogram(&b.produceLatency),
+			FetchLatency:   SummarizeHistogram(&b.fetchLatency),
+			ProduceErrors:  b.produceErrors,
+			FetchErrors:    b.fetchErrors,
+		}
+	}
+	r.Connects = cs.connects
+	r.ConnectErrors = cs.connectErrors
+	r.Disconnects = cs.disconnects
+	r.Throttles = cs.throttles
+	r.ThrottleTime = cs.throttleTime.Milliseconds()
+	r.Produced = cs.produced.summary()
+	r.Fetched = cs.fetched.summary()
+	r.ProduceBatchBytes = SummarizeHistogram(&cs.produceBatchBytes)
+	r.ProduceBatchRecords = SummarizeHistogram(&cs.produceBatchRecords)
+	r.ProduceRequestBytes = SummarizeHistogram(&cs.produceRequestBytes)
+	return r
+}
+
+func (cs *ClientStats) Reset() {
+	if cs == nil {
+		return
+	}
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	cs.brokers = make(map[int32]*brokerStats)
+	cs.lastProduce = make(map[int32]time.Duration)
+	cs.connects = 0
+	cs.connectErrors = 0
+	cs.disconnects = 0
+	cs.throttles = 0
+	cs.throttleTime = 0
+	cs.produced = BatchTotals{}
+	cs.fetched = BatchTotals{}
+	cs.produceBatchBytes.Clear()
+	cs.produceBatchRecords.Clear()
+	cs.produceRequestBytes.Clear()
+}
+
+type promSample struct {
+	labels string
+	value  float64
+}
+
+type promFamily struct {
+	name    string
+	kind    string
+	help    string
+	samples []promSample
+}
+
+// Write the statistics of several workers' clients in the Prometheus text
+// format, labelled by worker name.
+func WritePrometheus(w io.Writer, stats map[string]*ClientStats) {
+	var families []*promFamily
+	byName := make(map[string]*promFamily)
+	add := func(name string, kind string, help string, labels string, value float64) {
+		f, ok := byName[name]
+		if !ok {
+			f = &promFamily{name: name, kind: kind, help: help}
+			byName[name] = f
+			families = append(families, f)
+		}
+		f.samples = append(f.samples, promSample{labels, value})
+	}
+
+	names := make([]string, 0, len(stats))
+	for n := range stats {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		s := stats[n].Summary()
+		l := fmt.Sprintf("worker=%q", n)
+		add("kgo_verifier_connects_total", "counter", "Broker connections opened", l, float64(s.Connects))
+		add("kgo_verifier_connect_errors_total", "counter", "Broker connection attempts that failed", l, float64(s.ConnectErrors))
+		add("kgo_verifier_disconnects_total", "counter", "Broker connections closed", l, float64(s.Disconnects))
+		add("kgo_verifier_throttles_total", "counter", "Responses that throttled the client", l, float64(s.Throttles))
+		add("kgo_verifier_throttle_seconds_total", "counter", "Time spent throttled", l, float64(s.ThrottleTime)/1000)
+		for _, d := range []struct {
+			dir string
+			bt  BatchTotals
+		}{{"produce", s.Produced}, {"fetch", s.Fetched}} {
+			dl := fmt.Sprintf("%s,direction=%q", l, d.dir)
+			add("kgo_verifier_batches_total", "counter", "Record batches produced or fetched", dl, float64(d.bt.Batches))
+			add("kgo_verifier_batch_records_total", "counter", "Records in batches produced or fetched", dl, float64(d.bt.Records))
+			add("kgo_verifier_batch_uncompressed_bytes_total", "counter", "Uncompressed size of batches produced or fetched", dl, float64(d.bt.UncompressedBytes))
+			add("kgo_verifier_batch_compressed_bytes_total", "counter", "Compressed (wire) size of batches produced or fetched", dl, float64(d.bt.CompressedBytes))
+		}
+
+		for _, h := range []struct {
+			name string
+			help string
+			hs   HistogramSummary
+		}{
+			{"kgo_verifier_produce_batch_bytes", "Uncompressed bytes per produced batch", s.ProduceBatchBytes},
+			{"kgo_verifier_produce_batch_records", "Records per produced batch", s.ProduceBatchRecords},
+			{"kgo_verifier_produce_request_bytes", "Bytes per produce request", s.ProduceRequestBytes},
+		} {
+			add(h.name, "gauge", h.help, fmt.Sprintf("%s,quantile=\"0.5\"", l), h.hs.P50)
+			add(h.name, "gauge", h.help, fmt.Sprintf("%s,quantile=\"0.9\"", l), h.hs.P90)
+			add(h.name, "gauge", h.help, fmt.Sprintf("%s,quantile=\"0.99\"", l), h.hs.P99)
+		}
+
+		brokers := make([]int32, 0, len(s.Brokers))
+		for id := range s.Brokers {
+			brokers = append(brokers, id)
+		}
+		sort.Slice(brokers, func(i, j int) bool { return brokers[i] < brokers[j] })
+		for _, id := range brokers {
+			b := s.Brokers[id]
+			bl := fmt.Sprintf("%s,broker=\"%d\"", l, id)
+			for _, q := range []struct {
+				q       string
+				produce float64
+				fetch   float64
+			}{
+				{"0.5", b.ProduceLatency.P50, b.FetchLatency.P50},
+				{"0.9", b.ProduceLatency.P90, b.FetchLatency.P90},
+				{"0.99", b.ProduceLatency.P99, b.FetchLatency.P99},
+			} {
+				add("kgo_verifier_produce_latency_seconds", "gauge", "Produce request latency by broker", fmt.Sprintf("%s,quantile=%q", bl, q.q), q.produce/1e6)
+				add("kgo_verifier_fetch_latency_seconds", "gauge", "Fetch request latency by broker", fmt.Sprintf("%s,quantile=%q", bl, q.q), q.fetch/1e6)
+			}
+			add("kgo_verifier_produce_errors_total", "counter", "Failed produce requests by broker", bl, float64(b.ProduceErrors))
+			add("kgo_verifier_fetch_errors_total", "counter", "Failed fetch requests by broker", bl, float64(b.FetchErrors))
+		}
+	}
+
+	for _, f := range families {
+		fmt.Fprintf(w, "# HELP %s %s\n", f.name, f.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+		for _, s := range f.samples {
+			fmt.Fprintf(w, "%s{%s} %g\n", f.name, s.labels, s.value)
+		}
+	}
+}
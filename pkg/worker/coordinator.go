@@ -0,0 +1,116 @@
+package worker
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Shares a topic's partitions between identical verifier processes, so that
+// a set of pods launched with the same flags split the work between them.
+// Each process joins a consumer group on the topic, and works on the
+// partitions the group assigns it.  The group never consumes anything.
+type Coordinator struct {
+	lock       sync.Mutex
+	client     *kgo.Client
+	topic      string
+	partitions []int32
+	assigned   bool
+	changed    time.Time
+}
+
+func NewCoordinator(wc WorkerConfig, group string) (*Coordinator, error) {
+	c := &Coordinator{topic: wc.Topic}
+
+	opts := wc.MakeKgoOpts()
+	opts = append(opts, []kgo.Opt{
+		kgo.ConsumerGroup(group),
+		kgo.ConsumeTopics(wc.Topic),
+		kgo.Balancers(kgo.RangeBalancer()),
+		kgo.DisableAutoCommit(),
+		kgo.OnPartitionsAssigned(func(_ context.Context, _ *kgo.Client, assigned map[string][]int32) {
+			c.update(assigned[c.topic], nil)
+		}),
+		kgo.OnPartitionsRevoked(func(_ context.Context, _ *kgo.Client, revoked map[string][]int32) {
+			c.update(nil, revoked[c.topic])
+		}),
+		kgo.OnPartitionsLost(func(_ context.Context, _ *kgo.Client, lost map[string][]int32) {
+			c.update(nil, lost[c.topic])
+		}),
+	}...)
+	opts = append(opts, wc.MakeGroupOpts()...)
+
+	client, err := kgo.NewClient(opts...)
+	if err != nil {
+		return nil, err
+	}
+	client.PauseFetchTopics(wc.Topic)
+	c.client = client
+	return c, nil
+}
+
+func (c *Coordinator) update(added []int32, removed []int32) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	owned := make(map[int32]bool)
+	for _, p := range c.partitions {
+		owned[p] = true
+	}
+	for _, p := range added {
+		owned[p] = true
+	}
+	for _, p := range removed {
+		delete(owned, p)
+	}
+
+	c.partitions = make([]int32, 0, len(owned))
+	for p := range owned {
+		c.partitions = append(c.partitions, p)
+	}
+	sort.Slice(c.partitions, func(i, j int) bool { return c.partitions[i] < c.partitions[j] })
+	c.assigned = true
+	c.changed = time.Now()
+	log.Infof("Coordinator: now working on partitions %v", c.partitions)
+}
+
+// Block until we have an assignment that hasn't changed for a while, i.e.
+// the other processes that are going to join have probably joined.
+func (c *Coordinator) WaitSettled(settle time.Duration) {
+	log.Infof("Coordinator: waiting for partition assignment to settle...")
+	for {
+		c.lock.Lock()
+		settled := c.assigned && time.Since(c.changed) >= settle
+		c.lock.Unlock()
+		if settled {
+			return
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// The partitions this process currently owns
+func (c *Coordinator) Partitions() []int32 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]int32(nil), c.partitions...)
+}
+
+func (c *Coordinator) Owns(p int32) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, o := range c.partitions {
+		if o == p {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Coordinator) Close() {
+	c.client.Close()
+}
@@ -0,0 +1,235 @@
+package worker
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// Fetch session (KIP-227) usage by our clients.  franz-go's hooks don't
+// expose requests, so we wrap client connections and decode the session
+// fields of each Fetch request as it is written, and count the session
+// resets that franz-go logs.
+type FetchSessionStats struct {
+	lock    sync.Mutex
+	brokers map[string]*FetchSessionBrokerSummary
+
+	evictions int64
+	resets    int64
+	maxed     int64
+}
+
+type FetchSessionBrokerSummary struct {
+	// Fetches that open a session, continue one incrementally, or
+	// are sent without one
+	FullFetches        int64 `json:"full_fetches"`
+	IncrementalFetches int64 `json:"incremental_fetches"`
+	SessionlessFetches int64 `json:"sessionless_fetches"`
+
+	// Distinct session IDs used
+	Sessions int64 `json:"sessions"`
+}
+
+type FetchSessionSummary struct {
+	Brokers map[string]FetchSessionBrokerSummary `json:"brokers"`
+
+	// Sessions the broker evicted, sessions reset for other errors,
+	// and brokers that couldn't give us a session at all
+	Evictions int64 `json:"evictions"`
+	Resets    int64 `json:"resets"`
+	Maxed     int64 `json:"maxed"`
+}
+
+func NewFetchSessionStats() *FetchSessionStats {
+	return &FetchSessionStats{
+		brokers: make(map[string]*FetchSessionBrokerSummary),
+	}
+}
+
+var fetchSessionDialer = &net.Dialer{Timeout: 10 * time.Second}
+
+func (fs *FetchSessionStats) dial(ctx context.Context, network, host string) (net.Conn, error) {
+	conn, err := fetchSessionDialer.DialContext(ctx, network, host)
+	if err != nil {
+		return nil, err
+	}
+	return &fetchSessionConn{Conn: conn, host: host, stats: fs}, nil
+}
+
+func (fs *FetchSessionStats) onFetch(host string, sessionId int32, epoch int32, lastSession *int32) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	b, ok := fs.brokers[host]
+	if !ok {
+		b = &FetchSessionBrokerSummary{}
+		fs.brokers[host] = b
+	}
+	switch {
+	case epoch < 0:
+		b.SessionlessFetches += 1
+	case epoch == 0:
+		b.FullFetches += 1
+	default:
+		b.IncrementalFetches += 1
+		if sessionId != *lastSession {
+			b.Sessions += 1
+			*lastSession = sessionId
+		}
+	}
+}
+
+// A connection that watches the Fetch requests written to it.  franz-go
+// writes each request with a single Write.
+type fetchSessionConn struct {
+	net.Conn
+	host        string
+	stats       *FetchSessionStats
+	lastSession int32
+}
+
+func (c *fetchSessionConn) Write(b []byte) (int, error) {
+	if sessionId, epoch, ok := parseFetchSession(b); ok {
+		c.stats.onFetch(c.host, sessionId, epoch, &c.lastSession)
+	}
+	return c.Conn.Write(b)
+}
+
+// The session ID and epoch from a size-prefixed Fetch request (v7+)
+func parseFetchSession(b []byte) (int32, int32, bool) {
+	if len(b) < 14 || int16(binary.BigEndian.Uint16(b[4:])) != int16(kmsg.Fetch) {
+		return 0, 0, false
+	}
+	version := int16(binary.BigEndian.Uint16(b[6:]))
+	if version < 7 {
+		return 0, 0, false
+	}
+
+	// Skip the correlation ID and client ID, and on flexible versions
+	// the header's tagged fields
+	pos := 12
+	clientIdLen := int(int16(binary.BigEndian.Uint16(b[pos:])))
+	pos += 2
+	if clientIdLen > 0 {
+		pos += clientIdLen
+	}
+	if version >= 12 {
+		numTags, n := binary.Uvarint(b[min(pos, len(b)):])
+		if n <= 0 {
+			return 0, 0, false
+		}
+		pos += n
+		for i := uint64(0); i < numTags; i++ {
+			if _, n = binary.Uvarint(b[min(pos, len(b)):]); n <= 0 {
+				return 0, 0, false
+			}
+			pos += n
+			size, n := binary.Uvarint(b[min(pos, len(b)):])
+			if n <= 0 {
+				return 0, 0, false
+			}
+			pos += n + int(size)
+		}
+	}
+
+	// ReplicaID, MaxWaitMillis, MinBytes, MaxBytes, IsolationLevel
+	pos += 17
+	if pos+8 > len(b) {
+		return 0, 0, false
+	}
+	return int32(binary.BigEndian.Uint32(b[pos:])), int32(binary.BigEndian.Uint32(b[pos+4:])), true
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Passes the client's logs through to another logger (if any), counting the
+// fetch session resets along the way.
+type fetchSessionLogger struct {
+	inner kgo.Logger
+	stats *FetchSessionStats
+}
+
+func (l *fetchSessionLogger) Level() kgo.LogLevel {
+	if l.inner != nil && l.inner.Level() > kgo.LogLevelInfo {
+		return l.inner.Level()
+	}
+	return kgo.LogLevelInfo
+}
+
+func (l *fetchSessionLogger) Log(level kgo.LogLevel, msg string, keyvals ...interface{}) {
+	if strings.Contains(msg, "session") {
+		l.stats.lock.Lock()
+		switch {
+		case strings.Contains(msg, "likely evicted"):
+			l.stats.evictions += 1
+		case strings.Contains(msg, "maxed on sessions"):
+			l.stats.maxed += 1
+		case strings.Contains(msg, "resetting"):
+			l.stats.resets += 1
+		}
+		l.stats.lock.Unlock()
+	}
+	if l.inner != nil && level <= l.inner.Level() {
+		l.inner.Log(level, msg, keyvals...)
+	}
+}
+
+func (fs *FetchSessionStats) Summary() FetchSessionSummary {
+	s := FetchSessionSummary{Brokers: make(map[string]FetchSessionBrokerSummary)}
+	if fs == nil {
+		return s
+	}
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	for host, b := range fs.brokers {
+		s.Brokers[host] = *b
+	}
+	s.Evictions = fs.evictions
+	s.Resets = fs.resets
+	s.Maxed = fs.maxed
+	return s
+}
+
+func (fs *FetchSessionStats) Reset() {
+	if fs == nil {
+		return
+	}
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	fs.brokers = make(map[string]*FetchSessionBrokerSummary)
+	fs.evictions = 0
+	fs.resets = 0
+	fs.maxed = 0
+}
+
+// Fetching this much without a single incremental fetch means sessions
+// aren't working
+const minFetchesForSessions = 10
+
+// An error if fetch sessions weren't used as they should have been
+func (fs *FetchSessionStats) Check() error {
+	s := fs.Summary()
+	if s.Maxed > 0 {
+		return fmt.Errorf("%d brokers could not give us a fetch session", s.Maxed)
+	}
+	for host, b := range s.Brokers {
+		if b.SessionlessFetches > 0 {
+			return fmt.Errorf("%d fetches to %s without a fetch session", b.SessionlessFetches, host)
+		}
+		if b.FullFetches+b.IncrementalFetches >= minFetchesForSessions && b.IncrementalFetches == 0 {
+			return fmt.Errorf("no incremental fetches to %s in %d fetches", host, b.FullFetches)
+		}
+	}
+	return nil
+}
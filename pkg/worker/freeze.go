@@ -0,0 +1,69 @@
+package worker
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type FreezeStatus struct {
+	Frozen bool      `json:"frozen"`
+	Reason string    `json:"reason,omitempty"`
+	Since  time.Time `json:"since,omitempty"`
+	Phase  string    `json:"phase,omitempty"`
+}
+
+// Instead of exiting on the first validation failure, holds every worker
+// where it is, clients open, so that the cluster and the verifier can be
+// inspected at the moment things went wrong.  Workers call Wait between
+// operations; the goroutine that failed validation stays where it failed.
+// Nil-safe: without a freeze, nothing waits.
+type Freeze struct {
+	lock   sync.Mutex
+	status FreezeStatus
+
+	// Closed when we freeze
+	frozen chan struct{}
+}
+
+func NewFreeze() *Freeze {
+	return &Freeze{frozen: make(chan struct{})}
+}
+
+// Freeze, if we haven't already, then block for good
+func (f *Freeze) Hold(reason string) {
+	f.lock.Lock()
+	if !f.status.Frozen {
+		f.status = FreezeStatus{Frozen: true, Reason: reason, Since: time.Now(), Phase: CurrentPhase()}
+		close(f.frozen)
+		log.Errorf("Frozen on validation failure: %s", reason)
+	} else {
+		log.Errorf("Validation failure while frozen: %s", reason)
+	}
+	f.lock.Unlock()
+	select {}
+}
+
+// Block for good if we are frozen
+func (f *Freeze) Wait() {
+	if f == nil {
+		return
+	}
+	select {
+	case <-f.frozen:
+		select {}
+	default:
+	}
+}
+
+// Closed once we are frozen
+func (f *Freeze) Frozen() <-chan struct{} {
+	return f.frozen
+}
+
+func (f *Freeze) Status() FreezeStatus {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.status
+}
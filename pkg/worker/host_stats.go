@@ -0,0 +1,137 @@
+package worker
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Linux reports process CPU time in ticks of this many per second
+const procClockTicks = 100
+
+// Disk sectors in /proc/diskstats are always this size
+const procSectorBytes = 512
+
+// Cumulative host network and disk traffic, and our own CPU time, as of
+// when they were read
+type hostCounters struct {
+	time      time.Time
+	netRx     uint64
+	netTx     uint64
+	diskRead  uint64
+	diskWrite uint64
+	cpu       time.Duration
+}
+
+// Host-wide totals from /proc, so only on Linux
+func readHostCounters() (hostCounters, error) {
+	c := hostCounters{time: time.Now()}
+	var err error
+	if c.netRx, c.netTx, err = readNetDev(); err != nil {
+		return c, err
+	}
+	if c.diskRead, c.diskWrite, err = readDiskStats(); err != nil {
+		return c, err
+	}
+	c.cpu, err = readProcessCpu()
+	return c, err
+}
+
+// Bytes received and sent on all interfaces but loopback
+func readNetDev() (uint64, uint64, error) {
+	f, err := os.Open("/proc/net/dev")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	var rx, tx uint64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			// Headers
+			continue
+		}
+		if strings.TrimSpace(line[:colon]) == "lo" {
+			continue
+		}
+		fields := strings.Fields(line[colon+1:])
+		if len(fields) < 9 {
+			return 0, 0, fmt.Errorf("unexpected /proc/net/dev line '%s'", line)
+		}
+		r, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		t, err := strconv.ParseUint(fields[8], 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		rx += r
+		tx += t
+	}
+	return rx, tx, scanner.Err()
+}
+
+// Bytes read and written on whole disks, leaving out their partitions so
+// as not to count traffic twice
+func readDiskStats() (uint64, uint64, error) {
+	f, err := os.Open("/proc/diskstats")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	var read, written uint64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		if _, err := os.Stat("/sys/block/" + fields[2]); err != nil {
+			continue
+		}
+		r, err := strconv.ParseUint(fields[5], 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		w, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		read += r * procSectorBytes
+		written += w * procSectorBytes
+	}
+	return read, written, scanner.Err()
+}
+
+// User and system CPU time used by this process
+func readProcessCpu() (time.Duration, error) {
+	data, err := ioutil.ReadFile("/proc/self/stat")
+	if err != nil {
+		return 0, err
+	}
+	// The command name may contain spaces, so count fields from after it:
+	// utime and stime are the 14th and 15th fields of the whole line
+	s := string(data)
+	fields := strings.Fields(s[strings.LastIndex(s, ")")+1:])
+	if len(fields) < 13 {
+		return 0, fmt.Errorf("unexpected /proc/self/stat '%s'", s)
+	}
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(utime+stime) * time.Second / procClockTicks, nil
+}
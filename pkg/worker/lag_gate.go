@@ -0,0 +1,181 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+type LagGateStatus struct {
+	Group string `json:"group"`
+
+	// The group's lag as of our last look, whether that has us
+	// paused, and how often and for how long it has paused us
+	Lag        int64   `json:"lag"`
+	Paused     bool    `json:"paused"`
+	Pauses     int64   `json:"pauses"`
+	PausedTime float64 `json:"paused_seconds"`
+}
+
+// Holds a producer back while a linked consumer group is too far behind,
+// like an application with end to end backpressure.  Pauses when the
+// group's total lag on the topic exceeds pauseAt, and resumes once it falls
+// to resumeAt.  Partitions the group hasn't committed on don't count.
+type LagGate struct {
+	lock     sync.Mutex
+	topic    string
+	pauseAt  int64
+	resumeAt int64
+
+	status      LagGateStatus
+	pausedSince time.Time
+
+	// Closed when a pause ends
+	resumed chan struct{}
+}
+
+func NewLagGate(topic string, group string, pauseAt int64, resumeAt int64) *LagGate {
+	return &LagGate{
+		topic:    topic,
+		pauseAt:  pauseAt,
+		resumeAt: resumeAt,
+		status:   LagGateStatus{Group: group},
+	}
+}
+
+func (lg *LagGate) Run(ctx context.Context, client *kgo.Client, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		lag, err := groupLag(ctx, client, lg.status.Group, lg.topic)
+		if err != nil {
+			log.Warnf("Error checking lag of group %s: %v", lg.status.Group, err)
+		} else {
+			lg.update(lag)
+		}
+
+		select {
+		case <-ctx.Done():
+			lg.update(0)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (lg *LagGate) update(lag int64) {
+	lg.lock.Lock()
+	defer lg.lock.Unlock()
+	lg.status.Lag = lag
+	if !lg.status.Paused && lag > lg.pauseAt {
+		log.Infof("Pausing produce: group %s lag %d > %d", lg.status.Group, lag, lg.pauseAt)
+		lg.status.Paused = true
+		lg.status.Pauses += 1
+		lg.pausedSince = time.Now()
+		lg.resumed = make(chan struct{})
+	} else if lg.status.Paused && lag <= lg.resumeAt {
+		log.Infof("Resuming produce: group %s lag %d <= %d", lg.status.Group, lag, lg.resumeAt)
+		lg.status.Paused = false
+		lg.status.PausedTime += time.Since(lg.pausedSince).Seconds()
+		close(lg.resumed)
+	}
+}
+
+// Block while the gate is paused, or until stop is closed
+func (lg *LagGate) Wait(stop <-chan struct{}) {
+	if lg == nil {
+		return
+	}
+	lg.lock.Lock()
+	paused, resumed := lg.status.Paused, lg.resumed
+	lg.lock.Unlock()
+	if paused {
+		select {
+		case <-resumed:
+		case <-stop:
+		}
+	}
+}
+
+func (lg *LagGate) Status() LagGateStatus {
+	if lg == nil {
+		return LagGateStatus{}
+	}
+	lg.lock.Lock()
+	defer lg.lock.Unlock()
+	s := lg.status
+	if s.Paused {
+		s.PausedTime += time.Since(lg.pausedSince).Seconds()
+	}
+	return s
+}
+
+// Total of high watermark minus committed offset, over the partitions of
+// the topic that the group has committed on
+func groupLag(ctx context.Context, client *kgo.Client, group string, topic string) (int64, error) {
+	fetchReq := kmsg.NewPtrOffsetFetchRequest()
+	fetchReq.Group = group
+	fetchTopic := kmsg.NewOffsetFetchRequestTopic()
+	fetchTopic.Topic = topic
+	fetchReq.Topics = []kmsg.OffsetFetchRequestTopic{fetchTopic}
+	fetchResp, err := fetchReq.RequestWith(ctx, client)
+	if err != nil {
+		return 0, err
+	}
+	if err := kerr.ErrorForCode(fetchResp.ErrorCode); err != nil {
+		return 0, err
+	}
+
+	committed := make(map[int32]int64)
+	for _, t := range fetchResp.Topics {
+		for _, p := range t.Partitions {
+			if p.ErrorCode == 0 && p.Offset >= 0 {
+				committed[p.Partition] = p.Offset
+			}
+		}
+	}
+	if len(committed) == 0 {
+		return 0, nil
+	}
+
+	listReq := kmsg.NewPtrListOffsetsRequest()
+	listReq.ReplicaID = -1
+	listTopic := kmsg.NewListOffsetsRequestTopic()
+	listTopic.Topic = topic
+	for p := range committed {
+		part := kmsg.NewListOffsetsRequestTopicPartition()
+		part.Partition = p
+		part.Timestamp = -1
+		listTopic.Partitions = append(listTopic.Partitions, part)
+	}
+	listReq.Topics = append(listReq.Topics, listTopic)
+
+	var lag int64
+	var seen int
+	for _, shard := range client.RequestSharded(ctx, listReq) {
+		if shard.Err != nil {
+			return 0, shard.Err
+		}
+		for _, t := range shard.Resp.(*kmsg.ListOffsetsResponse).Topics {
+			for _, p := range t.Partitions {
+				if err := kerr.ErrorForCode(p.ErrorCode); err != nil {
+					return 0, err
+				}
+				if p.Offset > committed[p.Partition] {
+					lag += p.Offset - committed[p.Partition]
+				}
+				seen += 1
+			}
+		}
+	}
+	if seen < len(committed) {
+		return 0, errors.New("didn't get high watermarks for all partitions")
+	}
+	return lag, nil
+}
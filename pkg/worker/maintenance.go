@@ -0,0 +1,186 @@
+package worker
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	metrics "github.com/rcrowley/go-metrics"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// The impact of a maintenance window (e.g. a rolling restart) on producers
+type MaintenanceReport struct {
+	Active          bool      `json:"active"`
+	Start           time.Time `json:"start"`
+	End             time.Time `json:"end"`
+	DurationSeconds float64   `json:"duration_seconds"`
+
+	Acked      int64 `json:"acked"`
+	BadOffsets int64 `json:"bad_offsets"`
+
+	// Worst ack latency in the window, against the p99 from outside it
+	MaxAckLatencyMs      float64 `json:"max_ack_latency_ms"`
+	BaselineAckLatencyMs float64 `json:"baseline_ack_latency_p99_ms"`
+	AckLatencySpikeMs    float64 `json:"ack_latency_spike_ms"`
+
+	// Whole seconds of the window in which nothing was acked, in total
+	// and in the longest unbroken stretch
+	ZeroThroughputSeconds        int64 `json:"zero_throughput_seconds"`
+	LongestZeroThroughputSeconds int64 `json:"longest_zero_throughput_seconds"`
+
+	// Produce requests that failed at the connection level, failed
+	// connection attempts, and connections closed
+	ProduceErrors int64 `json:"produce_errors"`
+	ConnectErrors int64 `json:"connect_errors"`
+	Disconnects   int64 `json:"disconnects"`
+}
+
+// Collects producer availability during a window declared from outside,
+// e.g. by a test harness around a rolling restart.  Acks outside any
+// window form the latency baseline.
+type MaintenanceWindow struct {
+	lock   sync.Mutex
+	active bool
+	report MaintenanceReport
+
+	// Acks in each second since the window started
+	acksPerSecond []int64
+	maxAckLatency time.Duration
+	baseline      metrics.Histogram
+}
+
+func NewMaintenanceWindow() *MaintenanceWindow {
+	return &MaintenanceWindow{
+		baseline: metrics.NewHistogram(metrics.NewExpDecaySample(1024, 0.015)),
+	}
+}
+
+func (mw *MaintenanceWindow) Start() error {
+	mw.lock.Lock()
+	defer mw.lock.Unlock()
+	if mw.active {
+		return errors.New("maintenance window already started")
+	}
+	mw.active = true
+	mw.report = MaintenanceReport{Start: time.Now()}
+	mw.acksPerSecond = nil
+	mw.maxAckLatency = 0
+	return nil
+}
+
+func (mw *MaintenanceWindow) Stop() (MaintenanceReport, error) {
+	mw.lock.Lock()
+	defer mw.lock.Unlock()
+	if !mw.active {
+		return MaintenanceReport{}, errors.New("no maintenance window started")
+	}
+	mw.active = false
+	mw.report.End = time.Now()
+	return mw.summarize(), nil
+}
+
+// The last window's report, or the current one's so far
+func (mw *MaintenanceWindow) Report() MaintenanceReport {
+	mw.lock.Lock()
+	defer mw.lock.Unlock()
+	return mw.summarize()
+}
+
+func (mw *MaintenanceWindow) summarize() MaintenanceReport {
+	r := mw.report
+	r.Active = mw.active
+	end := r.End
+	if mw.active {
+		end = time.Now()
+	}
+	if r.Start.IsZero() {
+		return r
+	}
+	r.DurationSeconds = end.Sub(r.Start).Seconds()
+
+	r.MaxAckLatencyMs = float64(mw.maxAckLatency.Microseconds()) / 1000
+	r.BaselineAckLatencyMs = mw.baseline.Percentile(0.99) / 1000
+	if r.MaxAckLatencyMs > r.BaselineAckLatencyMs {
+		r.AckLatencySpikeMs = r.MaxAckLatencyMs - r.BaselineAckLatencyMs
+	}
+
+	// Only whole seconds: the one in progress may yet see acks
+	var run int64
+	for i := 0; i < int(end.Sub(r.Start)/time.Second); i++ {
+		if i < len(mw.acksPerSecond) && mw.acksPerSecond[i] > 0 {
+			run = 0
+			continue
+		}
+		r.ZeroThroughputSeconds += 1
+		run += 1
+		if run > r.LongestZeroThroughputSeconds {
+			r.LongestZeroThroughputSeconds = run
+		}
+	}
+	return r
+}
+
+func (mw *MaintenanceWindow) OnAck(latency time.Duration) {
+	if mw == nil {
+		return
+	}
+	mw.lock.Lock()
+	defer mw.lock.Unlock()
+	if !mw.active {
+		mw.baseline.Update(latency.Microseconds())
+		return
+	}
+	mw.report.Acked += 1
+	if latency > mw.maxAckLatency {
+		mw.maxAckLatency = latency
+	}
+	second := int(time.Since(mw.report.Start) / time.Second)
+	for len(mw.acksPerSecond) <= second {
+		mw.acksPerSecond = append(mw.acksPerSecond, 0)
+	}
+	mw.acksPerSecond[second] += 1
+}
+
+func (mw *MaintenanceWindow) OnBadOffset() {
+	if mw == nil {
+		return
+	}
+	mw.lock.Lock()
+	defer mw.lock.Unlock()
+	if mw.active {
+		mw.report.BadOffsets += 1
+	}
+}
+
+func (mw *MaintenanceWindow) OnBrokerE2E(meta kgo.BrokerMetadata, key int16, e2e kgo.BrokerE2E) {
+	if key != int16(kmsg.Produce) || e2e.Err() == nil {
+		return
+	}
+	mw.lock.Lock()
+	defer mw.lock.Unlock()
+	if mw.active {
+		mw.report.ProduceErrors += 1
+	}
+}
+
+func (mw *MaintenanceWindow) OnBrokerConnect(meta kgo.BrokerMetadata, dialDur time.Duration, conn net.Conn, err error) {
+	if err == nil {
+		return
+	}
+	mw.lock.Lock()
+	defer mw.lock.Unlock()
+	if mw.active {
+		mw.report.ConnectErrors += 1
+	}
+}
+
+func (mw *MaintenanceWindow) OnBrokerDisconnect(meta kgo.BrokerMetadata, conn net.Conn) {
+	mw.lock.Lock()
+	defer mw.lock.Unlock()
+	if mw.active {
+		mw.report.Disconnects += 1
+	}
+}
@@ -0,0 +1,88 @@
+package worker
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Keep this many phase changes of history
+const maxPhaseChanges = 1024
+
+type PhaseChange struct {
+	Time  time.Time `json:"time"`
+	Phase string    `json:"phase"`
+}
+
+type PhaseStatus struct {
+	Phase string    `json:"phase"`
+	Since time.Time `json:"since"`
+
+	// Oldest first
+	History []PhaseChange `json:"history"`
+}
+
+// An opaque label set by whatever is orchestrating the run, such as the
+// failure injection step in progress, and attached to what we record from
+// then on: log lines, resource samples, anomalies and metrics.  Lets our
+// observations be lined up with the orchestrator's steps without relying on
+// clocks agreeing.  There is one per process.
+var phase struct {
+	lock   sync.Mutex
+	status PhaseStatus
+}
+
+func SetPhase(label string) {
+	phase.lock.Lock()
+	defer phase.lock.Unlock()
+	now := time.Now()
+	phase.status.Phase = label
+	phase.status.Since = now
+	phase.status.History = append(phase.status.History, PhaseChange{Time: now, Phase: label})
+	if len(phase.status.History) > maxPhaseChanges {
+		phase.status.History = phase.status.History[1:]
+	}
+}
+
+// Empty until a phase is set
+func CurrentPhase() string {
+	phase.lock.Lock()
+	defer phase.lock.Unlock()
+	return phase.status.Phase
+}
+
+func Phases() PhaseStatus {
+	phase.lock.Lock()
+	defer phase.lock.Unlock()
+	status := phase.status
+	status.History = append([]PhaseChange{}, phase.status.History...)
+	return status
+}
+
+// Adds the current phase, if any, to every log line
+type PhaseLogHook struct{}
+
+func (PhaseLogHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (PhaseLogHook) Fire(entry *log.Entry) error {
+	if p := CurrentPhase(); p != "" {
+		entry.Data["phase"] = p
+	}
+	return nil
+}
+
+// The current phase as an info-style metric, for lining up with the rest
+func WritePhasePrometheus(w io.Writer) {
+	p := CurrentPhase()
+	if p == "" {
+		return
+	}
+	fmt.Fprintf(w, "# HELP kgo_verifier_phase The phase label last set by the orchestrator\n")
+	fmt.Fprintf(w, "# TYPE kgo_verifier_phase gauge\n")
+	fmt.Fprintf(w, "kgo_verifier_phase{phase=%q} 1\n", p)
+}
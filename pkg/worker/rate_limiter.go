@@ -0,0 +1,65 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A token bucket for pacing work to a target rate, in whatever units the
+// caller likes (messages, bytes...).  Requests larger than the burst size
+// are allowed, and simply wait longer.
+type RateLimiter struct {
+	lock  sync.Mutex
+	rate  float64
+	burst float64
+	avail float64
+	last  time.Time
+}
+
+// rate is in units per second.  burst is how many units may be used at once
+// after being idle: if zero, one second's worth.
+func NewRateLimiter(rate float64, burst float64) *RateLimiter {
+	if burst <= 0 {
+		burst = rate
+	}
+	return &RateLimiter{
+		rate:  rate,
+		burst: burst,
+		avail: burst,
+		last:  time.Now(),
+	}
+}
+
+// Block until n units are available, or the context is cancelled
+func (rl *RateLimiter) Wait(ctx context.Context, n float64) error {
+	rl.lock.Lock()
+	now := time.Now()
+	rl.avail += now.Sub(rl.last).Seconds() * rl.rate
+	if rl.avail > rl.burst {
+		rl.avail = rl.burst
+	}
+	rl.last = now
+
+	// Reserve the units now, even if that takes us into debt: later
+	// callers will wait for the debt to be paid off.
+	rl.avail -= n
+	var wait time.Duration
+	if rl.avail < 0 {
+		wait = time.Duration(-rl.avail / rl.rate * float64(time.Second))
+	}
+	rl.lock.Unlock()
+
+	if wait == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
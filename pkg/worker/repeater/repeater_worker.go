@@ -25,6 +25,7 @@ import (
 	"math/rand"
 	_ "net/http/pprof"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -46,6 +47,19 @@ func newRecord(producerId int, sequence int64) *kgo.Record {
 type MessageBody struct {
 	Token  int64 `json:"token"`
 	SentAt int64 `json:"sent_at"`
+
+	// How many times the token has been around the loop, for
+	// checking per-key ordering in keyed mode
+	Hop int64 `json:"hop"`
+}
+
+// A token waiting to be sent.  In keyed mode each token keeps the same key
+// for its whole life, so that its hops all land on the same partition and
+// must be consumed in order.
+type Token struct {
+	Id  int64
+	Key []byte
+	Hop int64
 }
 
 type RepeaterConfig struct {
@@ -55,6 +69,19 @@ type RepeaterConfig struct {
 	KeySpace       worker.KeySpace
 	ValueGenerator worker.ValueGenerator
 	DataInFlight   uint64
+
+	// If set, track tokens in flight to detect loss.  Shared by
+	// all the Workers in the process.
+	Tracker *TokenTracker
+
+	// Give each token a fixed key and check hops arrive in order
+	Keyed bool
+
+	// If non-zero, produce at this many messages per second regardless
+	// of how fast tokens come back, with at most QueueDepth consumed
+	// tokens waiting to be sent (excess tokens are dropped).
+	ProduceRate float64
+	QueueDepth  int
 }
 
 func NewRepeaterConfig(cfg worker.WorkerConfig, group string, partitions []int32, keys uint64, payloadSize uint64, dataInFlight uint64) RepeaterConfig {
@@ -91,7 +118,27 @@ type Worker struct {
 	capacity int64
 
 	// Tokens not currently in flight
-	pending chan int64
+	pending chan Token
+
+	// Keyed mode: highest hop seen for each key, and how many times
+	// we saw a hop that was not later than the last one for its key.
+	// Keys we drop the token for are forgotten, as are any not seen
+	// for lastHopIdle, whose tokens were lost or consumed elsewhere.
+	lastHop         map[string]hopSeen
+	lastHopPruned   time.Time
+	orderViolations int64
+
+	// Decoupled mode: paces the producer, and counts tokens dropped
+	// because the queue was full, or minted because it was empty
+	rateLimiter  *worker.RateLimiter
+	queueDropped int64
+	queueMinted  int64
+
+	// Set if this Worker took over from a primary as a warm standby,
+	// and set with it until the first ack after takeover, so that acks
+	// don't take the lock otherwise
+	failover         *FailoverStatus
+	awaitingFirstAck int32
 
 	// For adding tokens, next ID to allocate
 	nextTokenId int64
@@ -103,6 +150,13 @@ type Worker struct {
 	// been created on this Worker)?
 	tokenRevokeCount int
 
+	// Tokens sent by this Worker that never came back within the
+	// tracker's deadline, by the partition they were last acked on,
+	// and how many of those came back after all.
+	lostTokens      int64
+	lateTokens      int64
+	lostByPartition map[int32]int64
+
 	// Junk data used to bulk out the message body
 	payload []byte
 
@@ -112,17 +166,48 @@ type Worker struct {
 	client *kgo.Client
 }
 
+// Keyed mode: forget keys not seen for this long
+const lastHopIdle = 10 * time.Minute
+
+type hopSeen struct {
+	hop int64
+	at  time.Time
+}
+
 type LatencyReport struct {
 	Ack worker.HistogramSummary `json:"ack"`
 	E2e worker.HistogramSummary `json:"e2e"`
 }
 
 type WorkerStatus struct {
-	Produced int64         `json:"produced"`
-	Consumed int64         `json:"consumed"`
-	Enqueued int           `json:"enqueued"`
-	Errors   int64         `json:"errors"`
-	Latency  LatencyReport `json:"latency"`
+	Produced        int64           `json:"produced"`
+	Consumed        int64           `json:"consumed"`
+	Enqueued        int             `json:"enqueued"`
+	Errors          int64           `json:"errors"`
+	Latency         LatencyReport   `json:"latency"`
+	Lost            int64           `json:"lost"`
+	LostByPartition map[int32]int64 `json:"lost_by_partition"`
+	Late            int64           `json:"late"`
+	OrderViolations int64           `json:"order_violations"`
+	QueueCapacity   int             `json:"queue_capacity"`
+	QueueDropped    int64           `json:"queue_dropped"`
+	QueueMinted     int64           `json:"queue_minted"`
+	Failover        *FailoverStatus `json:"failover,omitempty"`
+}
+
+// For a warm standby Worker, how long the echo loop was disrupted when we
+// took over from the primary.  Comparing the primary's send times with our
+// ack times makes this subject to clock skew between their hosts.
+type FailoverStatus struct {
+	TakeoverAt time.Time `json:"takeover_at"`
+
+	// Latest send time among messages from the primary that we consumed
+	LastPrimarySend time.Time `json:"last_primary_send"`
+
+	// When we first successfully echoed a message
+	FirstAck time.Time `json:"first_ack"`
+
+	DisruptionMs int64 `json:"disruption_ms"`
 }
 
 /**
@@ -130,6 +215,22 @@ type WorkerStatus struct {
  * worker's progress and performance.
  */
 func (v *Worker) Status() WorkerStatus {
+	v.lock.Lock()
+	lostByPartition := make(map[int32]int64, len(v.lostByPartition))
+	for p, n := range v.lostByPartition {
+		lostByPartition[p] = n
+	}
+	var failover *FailoverStatus
+	if v.failover != nil {
+		f := *v.failover
+		if !f.FirstAck.IsZero() && !f.LastPrimarySend.IsZero() {
+			f.DisruptionMs = f.FirstAck.Sub(f.LastPrimarySend).Milliseconds()
+		}
+		failover = &f
+	}
+	orderViolations := v.orderViolations
+	v.lock.Unlock()
+
 	return WorkerStatus{
 		Produced: v.totalProduced,
 		Consumed: v.totalConsumed,
@@ -139,6 +240,14 @@ func (v *Worker) Status() WorkerStatus {
 			Ack: worker.SummarizeHistogram(&v.globalStats.Ack_latency),
 			E2e: worker.SummarizeHistogram(&v.globalStats.E2e_latency),
 		},
+		Lost:            atomic.LoadInt64(&v.lostTokens),
+		LostByPartition: lostByPartition,
+		Late:            atomic.LoadInt64(&v.lateTokens),
+		OrderViolations: orderViolations,
+		QueueCapacity:   cap(v.pending),
+		QueueDropped:    atomic.LoadInt64(&v.queueDropped),
+		QueueMinted:     atomic.LoadInt64(&v.queueMinted),
+		Failover:        failover,
 	}
 }
 
@@ -149,6 +258,15 @@ func (v *Worker) Reset() {
 	v.globalStats = worker.NewMessageStats()
 	v.totalConsumed = 0
 	v.totalProduced = 0
+	atomic.StoreInt64(&v.queueDropped, 0)
+	atomic.StoreInt64(&v.queueMinted, 0)
+
+	v.lock.Lock()
+	v.orderViolations = 0
+	atomic.StoreInt64(&v.lostTokens, 0)
+	atomic.StoreInt64(&v.lateTokens, 0)
+	v.lostByPartition = make(map[int32]int64)
+	v.lock.Unlock()
 }
 
 func (v *Worker) TokenBacklog() int {
@@ -168,15 +286,57 @@ func (v *Worker) TokenCapacity() int {
 }
 
 func (v *Worker) TokenIssue() {
-	v.pending <- v.nextTokenId
-	v.nextTokenId += 1
+	v.pending <- v.newToken()
 	v.tokenIssueCount += 1
 }
 
+func (v *Worker) newToken() Token {
+	var t Token
+	if v.config.Tracker != nil {
+		t.Id = v.config.Tracker.NextTokenId()
+	} else {
+		t.Id = v.nextTokenId
+		v.nextTokenId += 1
+	}
+	if v.config.Keyed {
+		// Worker names are unique across processes, token IDs within a Worker
+		t.Key = []byte(fmt.Sprintf("%s.%d", v.config.workerCfg.Name, t.Id))
+	}
+	return t
+}
+
+func (v *Worker) onLostToken(partition int32, reinject bool) {
+	v.lock.Lock()
+	atomic.AddInt64(&v.lostTokens, 1)
+	v.lostByPartition[partition] += 1
+	v.lock.Unlock()
+
+	// Outside our lock: the queue may be full until our consumer, which
+	// takes the lock, makes room in it
+	if reinject {
+		v.TokenIssue()
+	}
+}
+
+// Called with the consuming Worker's lock held, which may be this one
+func (v *Worker) onLateToken() {
+	atomic.AddInt64(&v.lateTokens, 1)
+}
+
 func (v *Worker) TokenRevoke() {
 	// Drop token on the floor
-	<-v.pending
+	t := <-v.pending
 	v.tokenRevokeCount += 1
+	v.forgetKey(t.Key)
+}
+
+func (v *Worker) forgetKey(key []byte) {
+	if !v.config.Keyed {
+		return
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	delete(v.lastHop, string(key))
 }
 
 func (v *Worker) TokenSize() int {
@@ -199,16 +359,27 @@ func NewWorker(config RepeaterConfig) Worker {
 		total_initial_tokens, config.DataInFlight)
 
 	var max_size int64 = 128000
+	var rateLimiter *worker.RateLimiter
+	if config.ProduceRate > 0 {
+		max_size = int64(config.QueueDepth)
+		if total_initial_tokens > uint64(max_size) {
+			total_initial_tokens = uint64(max_size)
+		}
+		rateLimiter = worker.NewRateLimiter(config.ProduceRate, 0)
+	}
 	v := Worker{
-		config:        config,
-		consumeCtx:    consumeCtx,
-		produceCtx:    produceCtx,
-		cancelConsume: cancelConsume,
-		cancelProduce: cancelProduce,
-		payload:       payload,
-		globalStats:   worker.NewMessageStats(),
-		capacity:      max_size,
-		pending:       make(chan int64, max_size),
+		config:          config,
+		consumeCtx:      consumeCtx,
+		produceCtx:      produceCtx,
+		cancelConsume:   cancelConsume,
+		cancelProduce:   cancelProduce,
+		payload:         payload,
+		globalStats:     worker.NewMessageStats(),
+		capacity:        max_size,
+		pending:         make(chan Token, max_size),
+		lostByPartition: make(map[int32]int64),
+		lastHop:         make(map[string]hopSeen),
+		rateLimiter:     rateLimiter,
 	}
 
 	var i int64
@@ -244,10 +415,60 @@ func (v *Worker) ConsumeRecord(r *kgo.Record) {
 	now := time.Now()
 	e2e_latency := now.UnixMicro() - message.SentAt
 
+	if v.failover != nil {
+		sentAt := time.UnixMicro(message.SentAt)
+		if sentAt.Before(v.failover.TakeoverAt) && sentAt.After(v.failover.LastPrimarySend) {
+			v.failover.LastPrimarySend = sentAt
+		}
+	}
+
 	v.globalStats.E2e_latency.Update(e2e_latency)
 
 	log.Debugf("Consume %s token %06d, total latency %s", v.config.workerCfg.Name, token, e2e_latency)
-	v.pending <- int64(token)
+	if v.config.Keyed {
+		key := string(r.Key)
+		if last, ok := v.lastHop[key]; ok && message.Hop <= last.hop {
+			log.Warnf("Consume %s key %s hop %d out of order (last hop %d) on partition %d offset %d",
+				v.config.workerCfg.Name, key, message.Hop, last.hop, r.Partition, r.Offset)
+			v.orderViolations += 1
+		} else {
+			v.lastHop[key] = hopSeen{hop: message.Hop, at: now}
+		}
+		if now.Sub(v.lastHopPruned) > lastHopIdle/10 {
+			for k, seen := range v.lastHop {
+				if now.Sub(seen.at) > lastHopIdle {
+					delete(v.lastHop, k)
+				}
+			}
+			v.lastHopPruned = now
+		}
+	}
+
+	if v.config.Tracker != nil && !v.config.Tracker.OnConsume(token) {
+		// Already replaced: drop it so we don't end up with extra tokens
+		v.tokenRevokeCount += 1
+		delete(v.lastHop, string(r.Key))
+		return
+	}
+
+	next := Token{Id: token, Hop: message.Hop + 1}
+	if v.config.Keyed {
+		next.Key = r.Key
+	}
+	if v.rateLimiter != nil {
+		// Decoupled: never hold up consumption waiting for the producer
+		select {
+		case v.pending <- next:
+		default:
+			atomic.AddInt64(&v.queueDropped, 1)
+			delete(v.lastHop, string(r.Key))
+			if v.config.Tracker != nil {
+				v.config.Tracker.Forget(token)
+			}
+		}
+		return
+	}
+	v.pending <- next
 }
 
 func (v *Worker) Init() {
@@ -264,6 +485,7 @@ func (v *Worker) Init() {
 
 	if v.config.Group != "" {
 		opts = append(opts, kgo.ConsumerGroup(v.config.Group))
+		opts = append(opts, v.config.workerCfg.MakeGroupOpts()...)
 	}
 
 	client, err := kgo.NewClient(opts...)
@@ -299,23 +521,44 @@ loop:
 		// Drop out if signalled to stop
 		log.Debugf("Produce %s checking for token...", v.config.workerCfg.Name)
 
-		var token int64
-		select {
-		case <-v.produceCtx.Done():
-			log.Infof("Produce %s got Done signal", v.config.workerCfg.Name)
-			break loop
-		case token = <-v.pending:
-			log.Debugf("Produce %s sending token %d", v.config.workerCfg.Name, token)
+		var t Token
+		if v.rateLimiter != nil {
+			// Decoupled: send at our own pace, making up new tokens if
+			// none have come back for us to reuse.
+			if v.rateLimiter.Wait(v.produceCtx, 1) != nil {
+				log.Infof("Produce %s got Done signal", v.config.workerCfg.Name)
+				break loop
+			}
+			select {
+			case t = <-v.pending:
+			default:
+				t = v.newToken()
+				atomic.AddInt64(&v.queueMinted, 1)
+			}
+			log.Debugf("Produce %s sending token %d", v.config.workerCfg.Name, t.Id)
+		} else {
+			select {
+			case <-v.produceCtx.Done():
+				log.Infof("Produce %s got Done signal", v.config.workerCfg.Name)
+				break loop
+			case t = <-v.pending:
+				log.Debugf("Produce %s sending token %d", v.config.workerCfg.Name, t.Id)
+			}
 		}
+		token := t.Id
 
-		var maxKey uint64
-		if v.config.KeySpace.UniqueCount > 0 {
-			maxKey = v.config.KeySpace.UniqueCount
+		var key bytes.Buffer
+		if v.config.Keyed {
+			key.Write(t.Key)
 		} else {
-			maxKey = ^uint64(0)
+			var maxKey uint64
+			if v.config.KeySpace.UniqueCount > 0 {
+				maxKey = v.config.KeySpace.UniqueCount
+			} else {
+				maxKey = ^uint64(0)
+			}
+			fmt.Fprintf(&key, "%d", rand.Uint64()%maxKey)
 		}
-		var key bytes.Buffer
-		fmt.Fprintf(&key, "%d", rand.Uint64()%maxKey)
 
 		var r *kgo.Record
 
@@ -323,6 +566,7 @@ loop:
 		message := MessageBody{
 			Token:  token,
 			SentAt: sentAt.UnixMicro(),
+			Hop:    t.Hop,
 		}
 		var messageBytes bytes.Buffer
 		err := binary.Write(&messageBytes, binary.BigEndian, message)
@@ -341,15 +585,26 @@ loop:
 				log.Errorf("Produce %s error, dropped token %d: %v", v.config.workerCfg.Name, token, err)
 				v.globalStats.Errors += 1
 				v.tokenRevokeCount += 1
+				v.forgetKey(r.Key)
+				if v.config.Tracker != nil {
+					v.config.Tracker.Forget(token)
+				}
 			} else {
+				if v.config.Tracker != nil {
+					v.config.Tracker.OnAck(token, r.Partition)
+				}
 				ackLatency := time.Since(sentAt)
 				v.globalStats.Ack_latency.Update(ackLatency.Microseconds())
 				v.totalProduced += 1
+				v.onAckForFailover()
 			}
 			ackWait.Done()
 		}
 
 		ackWait.Add(1)
+		if v.config.Tracker != nil {
+			v.config.Tracker.OnSend(token, v)
+		}
 		v.client.Produce(v.produceCtx, r, handler)
 	}
 
@@ -403,6 +658,28 @@ loop:
 	v.client.CommitUncommittedOffsets(sync_ctx)
 }
 
+// Record that this Worker is a warm standby taking over a group from
+// a primary that has gone away, so that we measure the disruption.
+func (v *Worker) MarkTakeover() {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.failover = &FailoverStatus{TakeoverAt: time.Now()}
+	atomic.StoreInt32(&v.awaitingFirstAck, 1)
+}
+
+func (v *Worker) onAckForFailover() {
+	if !atomic.CompareAndSwapInt32(&v.awaitingFirstAck, 1, 0) {
+		return
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if v.failover != nil && v.failover.FirstAck.IsZero() {
+		v.failover.FirstAck = time.Now()
+		log.Infof("Standby %s first echo %v after takeover",
+			v.config.workerCfg.Name, v.failover.FirstAck.Sub(v.failover.TakeoverAt))
+	}
+}
+
 func (v *Worker) Prepare() {
 	v.Init()
 
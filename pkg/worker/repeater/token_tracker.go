@@ -0,0 +1,160 @@
+package loop
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type LostTokenPolicy string
+
+const (
+	// Just count lost tokens
+	LostTokenIgnore LostTokenPolicy = "none"
+
+	// Replace each lost token with a fresh one, so that the quantity of
+	// data in flight doesn't decay as tokens are lost.  Lost tokens that
+	// turn up late are dropped, so as not to end up with a surplus.
+	LostTokenReinject LostTokenPolicy = "reinject"
+)
+
+// Lost tokens are remembered, in case they turn up late, for this many
+// deadlines
+const lostTokenMemory = 10
+
+type lostToken struct {
+	origin *Worker
+	lostAt time.Time
+}
+
+type inFlightToken struct {
+	origin *Worker
+	sentAt time.Time
+
+	// Partition of the most recent successful produce, or -1 if the
+	// token has not been acked yet
+	partition int32
+}
+
+// Tokens may be consumed by a different Worker to the one that sent them,
+// so tracking which tokens are in flight is done for all the Workers in
+// the process together.  This implies that loss detection is only accurate
+// when all the members of the consumer group are in this process: tokens
+// consumed by another process will look lost.
+type TokenTracker struct {
+	lock     sync.Mutex
+	deadline time.Duration
+	policy   LostTokenPolicy
+
+	nextTokenId int64
+	inFlight    map[int64]*inFlightToken
+
+	// Tokens we gave up on, in case they come back
+	lost map[int64]lostToken
+}
+
+func NewTokenTracker(deadline time.Duration, policy LostTokenPolicy) *TokenTracker {
+	return &TokenTracker{
+		deadline: deadline,
+		policy:   policy,
+		inFlight: make(map[int64]*inFlightToken),
+		lost:     make(map[int64]lostToken),
+	}
+}
+
+// Token IDs must be unique across all the Workers sharing a tracker
+func (tt *TokenTracker) NextTokenId() int64 {
+	tt.lock.Lock()
+	defer tt.lock.Unlock()
+	id := tt.nextTokenId
+	tt.nextTokenId += 1
+	return id
+}
+
+func (tt *TokenTracker) OnSend(token int64, origin *Worker) {
+	tt.lock.Lock()
+	defer tt.lock.Unlock()
+	tt.inFlight[token] = &inFlightToken{
+		origin:    origin,
+		sentAt:    time.Now(),
+		partition: -1,
+	}
+}
+
+func (tt *TokenTracker) OnAck(token int64, partition int32) {
+	tt.lock.Lock()
+	defer tt.lock.Unlock()
+	if t, ok := tt.inFlight[token]; ok {
+		t.partition = partition
+	}
+}
+
+// A token that will never come back because we know its produce failed
+func (tt *TokenTracker) Forget(token int64) {
+	tt.lock.Lock()
+	defer tt.lock.Unlock()
+	delete(tt.inFlight, token)
+}
+
+// Returns false if the token should be dropped rather than sent again
+func (tt *TokenTracker) OnConsume(token int64) bool {
+	tt.lock.Lock()
+	defer tt.lock.Unlock()
+	delete(tt.inFlight, token)
+
+	if lost, ok := tt.lost[token]; ok {
+		delete(tt.lost, token)
+		log.Warnf("Token %d returned after it was counted lost", token)
+		lost.origin.onLateToken()
+		if tt.policy == LostTokenReinject {
+			return false
+		}
+	}
+	return true
+}
+
+func (tt *TokenTracker) checkDeadlines() {
+	tt.lock.Lock()
+	var expired []*inFlightToken
+	for token, t := range tt.inFlight {
+		if time.Since(t.sentAt) > tt.deadline {
+			log.Warnf("Token %d lost in flight (last acked on partition %d, sent %v ago)", token, t.partition, time.Since(t.sentAt))
+			expired = append(expired, t)
+			tt.lost[token] = lostToken{origin: t.origin, lostAt: time.Now()}
+			delete(tt.inFlight, token)
+		}
+	}
+	for token, t := range tt.lost {
+		if time.Since(t.lostAt) > lostTokenMemory*tt.deadline {
+			// Not coming back: if it does, it is just counted as any token
+			delete(tt.lost, token)
+		}
+	}
+	tt.lock.Unlock()
+
+	// Outside our lock, as reinjecting takes the Worker's lock
+	for _, t := range expired {
+		t.origin.onLostToken(t.partition, tt.policy == LostTokenReinject)
+	}
+}
+
+// Periodically look for tokens that are overdue until the context is cancelled
+func (tt *TokenTracker) Run(ctx context.Context) {
+	interval := tt.deadline / 4
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			tt.checkDeadlines()
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package worker
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/redpanda-data/kgo-verifier/pkg/util"
+	log "github.com/sirupsen/logrus"
+)
+
+type ResourceSample struct {
+	Time       time.Time `json:"time"`
+	HeapInuse  uint64    `json:"heap_inuse_bytes"`
+	Goroutines int       `json:"goroutines"`
+
+	// The orchestrator's phase label when sampled, if any
+	Phase string `json:"phase,omitempty"`
+
+	// With host sampling, host network and disk throughput and our CPU
+	// use (in cores) since the sample before
+	NetRxBytesPerSec     float64 `json:"net_rx_bytes_per_sec,omitempty"`
+	NetTxBytesPerSec     float64 `json:"net_tx_bytes_per_sec,omitempty"`
+	DiskReadBytesPerSec  float64 `json:"disk_read_bytes_per_sec,omitempty"`
+	DiskWriteBytesPerSec float64 `json:"disk_write_bytes_per_sec,omitempty"`
+	CpuCores             float64 `json:"cpu_cores,omitempty"`
+}
+
+// Host traffic and our CPU time between the first sample and the last
+type HostTotals struct {
+	NetRxBytes     uint64  `json:"net_rx_bytes"`
+	NetTxBytes     uint64  `json:"net_tx_bytes"`
+	DiskReadBytes  uint64  `json:"disk_read_bytes"`
+	DiskWriteBytes uint64  `json:"disk_write_bytes"`
+	CpuSeconds     float64 `json:"cpu_seconds"`
+}
+
+type ResourceStatus struct {
+	First ResourceSample `json:"first"`
+	Last  ResourceSample `json:"last"`
+
+	// Highest values seen, not necessarily at the same time
+	PeakHeapInuse  uint64 `json:"peak_heap_inuse_bytes"`
+	PeakGoroutines int    `json:"peak_goroutines"`
+
+	// With host sampling
+	Host *HostTotals `json:"host,omitempty"`
+
+	// Recent history, oldest first
+	Samples []ResourceSample `json:"samples"`
+}
+
+// Keep this many samples of history
+const maxResourceSamples = 1024
+
+// Watch the process's own heap and goroutine count, so that long soak runs
+// also catch leaks in the verifier and in franz-go.  If bounds are set, the
+// run fails once they are exceeded.
+type ResourceMonitor struct {
+	lock          sync.Mutex
+	maxHeapInuse  uint64
+	maxGoroutines int
+	status        ResourceStatus
+
+	// If sampling the host, its counters at the first sample and the
+	// latest
+	host      bool
+	hostFirst hostCounters
+	hostLast  hostCounters
+}
+
+// Zero bounds are not enforced
+func NewResourceMonitor(maxHeapInuse uint64, maxGoroutines int) *ResourceMonitor {
+	return &ResourceMonitor{
+		maxHeapInuse:  maxHeapInuse,
+		maxGoroutines: maxGoroutines,
+	}
+}
+
+// Also sample host network and disk throughput and our own CPU use, so
+// that performance results can be read without correlating them with
+// separate host monitoring.  Linux only.
+func (rm *ResourceMonitor) EnableHostStats() error {
+	c, err := readHostCounters()
+	if err != nil {
+		return err
+	}
+	rm.lock.Lock()
+	defer rm.lock.Unlock()
+	rm.host = true
+	rm.hostFirst = c
+	rm.hostLast = c
+	rm.status.Host = &HostTotals{}
+	return nil
+}
+
+// Rates since the previous host sample
+func (rm *ResourceMonitor) sampleHost(s *ResourceSample) {
+	c, err := readHostCounters()
+	if err != nil {
+		log.Warnf("Error sampling host resources: %v", err)
+		return
+	}
+	prev := rm.hostLast
+	rm.hostLast = c
+	secs := c.time.Sub(prev.time).Seconds()
+	if secs <= 0 {
+		return
+	}
+	rate := func(now uint64, before uint64) float64 {
+		return float64(counterDelta(now, before)) / secs
+	}
+	s.NetRxBytesPerSec = rate(c.netRx, prev.netRx)
+	s.NetTxBytesPerSec = rate(c.netTx, prev.netTx)
+	s.DiskReadBytesPerSec = rate(c.diskRead, prev.diskRead)
+	s.DiskWriteBytesPerSec = rate(c.diskWrite, prev.diskWrite)
+	s.CpuCores = (c.cpu - prev.cpu).Seconds() / secs
+
+	first := rm.hostFirst
+	rm.status.Host = &HostTotals{
+		NetRxBytes:     counterDelta(c.netRx, first.netRx),
+		NetTxBytes:     counterDelta(c.netTx, first.netTx),
+		DiskReadBytes:  counterDelta(c.diskRead, first.diskRead),
+		DiskWriteBytes: counterDelta(c.diskWrite, first.diskWrite),
+		CpuSeconds:     (c.cpu - first.cpu).Seconds(),
+	}
+}
+
+// Interfaces and disks can come and go, taking their counts with them
+func counterDelta(now uint64, before uint64) uint64 {
+	if now < before {
+		return 0
+	}
+	return now - before
+}
+
+func (rm *ResourceMonitor) sample() {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	s := ResourceSample{
+		Time:       time.Now(),
+		HeapInuse:  ms.HeapInuse,
+		Goroutines: runtime.NumGoroutine(),
+		Phase:      CurrentPhase(),
+	}
+	log.Debugf("Resources: heap in use %d bytes, %d goroutines", s.HeapInuse, s.Goroutines)
+
+	rm.lock.Lock()
+	if rm.host {
+		rm.sampleHost(&s)
+	}
+	if rm.status.First.Time.IsZero() {
+		rm.status.First = s
+	}
+	rm.status.Last = s
+	if s.HeapInuse > rm.status.PeakHeapInuse {
+		rm.status.PeakHeapInuse = s.HeapInuse
+	}
+	if s.Goroutines > rm.status.PeakGoroutines {
+		rm.status.PeakGoroutines = s.Goroutines
+	}
+	rm.status.Samples = append(rm.status.Samples, s)
+	if len(rm.status.Samples) > maxResourceSamples {
+		rm.status.Samples = rm.status.Samples[1:]
+	}
+	rm.lock.Unlock()
+
+	if rm.maxHeapInuse > 0 && s.HeapInuse > rm.maxHeapInuse {
+		util.DieWith(util.ExitInternal, "Heap in use grew to %d bytes (limit %d): possible leak", s.HeapInuse, rm.maxHeapInuse)
+	}
+	if rm.maxGoroutines > 0 && s.Goroutines > rm.maxGoroutines {
+		util.DieWith(util.ExitInternal, "Goroutine count grew to %d (limit %d): possible leak", s.Goroutines, rm.maxGoroutines)
+	}
+}
+
+// Sample periodically until the context is cancelled
+func (rm *ResourceMonitor) Run(ctx context.Context, interval time.Duration) {
+	rm.sample()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rm.sample()
+		}
+	}
+}
+
+func (rm *ResourceMonitor) Status() ResourceStatus {
+	rm.lock.Lock()
+	defer rm.lock.Unlock()
+	r := rm.status
+	r.Samples = append([]ResourceSample(nil), rm.status.Samples...)
+	if rm.status.Host != nil {
+		host := *rm.status.Host
+		r.Host = &host
+	}
+	return r
+}
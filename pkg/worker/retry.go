@@ -0,0 +1,114 @@
+package worker
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// franz-go's defaults, for when only one end of the backoff range is configured
+const (
+	defaultRetryBackoffMin = 250 * time.Millisecond
+	defaultRetryBackoffMax = 5 * time.Second / 2
+)
+
+// The same exponential backoff with jitter that franz-go uses by default,
+// with a configurable range.
+func makeRetryBackoff(min time.Duration, max time.Duration, onBackoff func()) func(int) time.Duration {
+	if min == 0 {
+		min = defaultRetryBackoffMin
+	}
+	if max == 0 {
+		max = defaultRetryBackoffMax
+	}
+
+	var rngMu sync.Mutex
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return func(fails int) time.Duration {
+		onBackoff()
+		if fails <= 0 {
+			return min
+		}
+		if fails > 10 {
+			return max
+		}
+
+		backoff := min * time.Duration(1<<(fails-1))
+
+		rngMu.Lock()
+		jitter := 0.8 + 0.4*rng.Float64()
+		rngMu.Unlock()
+
+		backoff = time.Duration(float64(backoff) * jitter)
+		if backoff > max {
+			return max
+		}
+		return backoff
+	}
+}
+
+// Counts of client retries, for comparing the behaviour of different retry
+// policies when the cluster is misbehaving.  franz-go doesn't tell us
+// directly when it retries a request, so we count the two things that lead
+// to retries: backoffs taken (for any reason), and requests that failed at
+// the connection level (broken down by request type).
+type RetryStats struct {
+	lock     sync.Mutex
+	backoffs int64
+	failed   map[int16]int64
+}
+
+// Retries by a worker's clients, embedded in its status and filled in
+// from the worker config's counters when status is requested
+type RetrySummary struct {
+	Backoffs       int64            `json:"backoffs"`
+	FailedRequests map[string]int64 `json:"failed_requests"`
+}
+
+func NewRetryStats() *RetryStats {
+	return &RetryStats{
+		failed: make(map[int16]int64),
+	}
+}
+
+func (rs *RetryStats) onBackoff() {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	rs.backoffs += 1
+}
+
+func (rs *RetryStats) OnBrokerE2E(meta kgo.BrokerMetadata, key int16, e2e kgo.BrokerE2E) {
+	if e2e.Err() == nil {
+		return
+	}
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	rs.failed[key] += 1
+}
+
+func (rs *RetryStats) Summary() RetrySummary {
+	r := RetrySummary{FailedRequests: make(map[string]int64)}
+	if rs == nil {
+		return r
+	}
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	r.Backoffs = rs.backoffs
+	for k, n := range rs.failed {
+		r.FailedRequests[kmsg.NameForKey(k)] = n
+	}
+	return r
+}
+
+func (rs *RetryStats) Reset() {
+	if rs == nil {
+		return
+	}
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	rs.backoffs = 0
+	rs.failed = make(map[int16]int64)
+}
@@ -0,0 +1,234 @@
+package worker
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	metrics "github.com/rcrowley/go-metrics"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// The SLIs that may be exported on /slis
+const (
+	SLIAckLatency = "ack-latency"
+	SLIE2ELatency = "e2e-latency"
+	SLIErrorRatio = "error-ratio"
+)
+
+func ParseSLIs(s string) (map[string]bool, error) {
+	enabled := make(map[string]bool)
+	for _, name := range strings.Split(s, ",") {
+		switch name = strings.TrimSpace(name); name {
+		case "":
+		case SLIAckLatency, SLIE2ELatency, SLIErrorRatio:
+			enabled[name] = true
+		default:
+			return nil, fmt.Errorf("unknown SLI '%s'", name)
+		}
+	}
+	return enabled, nil
+}
+
+// A record that stood out, so that SLO tooling can point at it
+type Exemplar struct {
+	Partition int32
+	Offset    int64
+	Value     float64
+	Time      time.Time
+}
+
+// Exemplars are replaced by worse ones, or once they are this old
+const exemplarMaxAge = time.Minute
+
+// Latencies for a summary: quantiles from a decaying sample, and a count
+// and sum over the worker's lifetime.  Unlike worker status, these are
+// never reset, so that they behave as counters to a scraper.
+type LatencyTracker struct {
+	lock     sync.Mutex
+	hist     metrics.Histogram
+	count    int64
+	sum      time.Duration
+	exemplar *Exemplar
+}
+
+func NewLatencyTracker() *LatencyTracker {
+	return &LatencyTracker{
+		hist: metrics.NewHistogram(metrics.NewExpDecaySample(1024, 0.015)),
+	}
+}
+
+func (lt *LatencyTracker) Observe(latency time.Duration, r *kgo.Record) {
+	lt.lock.Lock()
+	defer lt.lock.Unlock()
+	lt.hist.Update(latency.Microseconds())
+	lt.count += 1
+	lt.sum += latency
+	now := time.Now()
+	if lt.exemplar == nil || latency.Seconds() > lt.exemplar.Value || now.Sub(lt.exemplar.Time) > exemplarMaxAge {
+		lt.exemplar = &Exemplar{Partition: r.Partition, Offset: r.Offset, Value: latency.Seconds(), Time: now}
+	}
+}
+
+// Per-worker SLI measurements.  Operations are produces for producers and
+// fetches for readers; the error ratio is failed operations over all.
+type SLIStats struct {
+	AckLatency *LatencyTracker
+	E2ELatency *LatencyTracker
+
+	lock          sync.Mutex
+	operations    int64
+	errors        int64
+	errorExemplar *Exemplar
+}
+
+func NewSLIStats() *SLIStats {
+	return &SLIStats{
+		AckLatency: NewLatencyTracker(),
+		E2ELatency: NewLatencyTracker(),
+	}
+}
+
+func (s *SLIStats) ObserveAck(latency time.Duration, r *kgo.Record) {
+	if s == nil {
+		return
+	}
+	s.AckLatency.Observe(latency, r)
+}
+
+// Time from a record's timestamp to our reading it: only meaningful for
+// readers keeping up with a producer that doesn't fake its timestamps
+func (s *SLIStats) ObserveE2E(r *kgo.Record) {
+	if s == nil {
+		return
+	}
+	s.E2ELatency.Observe(time.Since(r.Timestamp), r)
+}
+
+func (s *SLIStats) OnOperation(err bool, partition int32, offset int64) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.operations += 1
+	if err {
+		s.errors += 1
+		s.errorExemplar = &Exemplar{Partition: partition, Offset: offset, Value: 1, Time: time.Now()}
+	}
+}
+
+// Each partition's part of a fetch is one operation
+func (s *SLIStats) OnFetches(fetches kgo.Fetches) {
+	if s == nil {
+		return
+	}
+	fetches.EachPartition(func(p kgo.FetchTopicPartition) {
+		offset := int64(-1)
+		if len(p.Records) > 0 {
+			offset = p.Records[0].Offset
+		}
+		s.OnOperation(p.Err != nil, p.Partition, offset)
+	})
+}
+
+// Workers that measure SLIs
+type SLIWorker interface {
+	GetSLIStats() *SLIStats
+}
+
+func writeExemplar(w io.Writer, e *Exemplar) {
+	if e == nil {
+		fmt.Fprintf(w, "\n")
+		return
+	}
+	fmt.Fprintf(w, " # {partition=\"%d\",offset=\"%d\"} %g %.3f\n", e.Partition, e.Offset, e.Value, float64(e.Time.UnixNano())/1e9)
+}
+
+func writeLatency(w io.Writer, l string, lt *LatencyTracker, name string) {
+	lt.lock.Lock()
+	defer lt.lock.Unlock()
+	for _, q := range []float64{0.5, 0.9, 0.99} {
+		fmt.Fprintf(w, "%s{%s,quantile=\"%g\"} %g\n", name, l, q, lt.hist.Percentile(q)/1e6)
+	}
+	fmt.Fprintf(w, "%s_sum{%s} %g\n", name, l, lt.sum.Seconds())
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, l, lt.count)
+}
+
+// OpenMetrics doesn't allow exemplars on summaries, so the slowest record
+// goes on a counter of the records measured
+func writeLatencyExemplar(w io.Writer, l string, lt *LatencyTracker, name string) {
+	lt.lock.Lock()
+	defer lt.lock.Unlock()
+	fmt.Fprintf(w, "%s_total{%s} %d", name, l, lt.count)
+	writeExemplar(w, lt.exemplar)
+}
+
+// OpenMetrics text format, with the slowest recent record as an exemplar
+// for each latency, and the last failed operation on the errors.
+func WriteOpenMetrics(w io.Writer, stats map[string]*SLIStats, enabled map[string]bool) {
+	names := make([]string, 0, len(stats))
+	for n := range stats {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	for _, sli := range []struct {
+		key     string
+		name    string
+		records string
+		help    string
+		get     func(*SLIStats) *LatencyTracker
+	}{
+		{SLIAckLatency, "kgo_verifier_sli_ack_latency_seconds", "kgo_verifier_sli_acks", "Time from produce to ack", func(s *SLIStats) *LatencyTracker { return s.AckLatency }},
+		{SLIE2ELatency, "kgo_verifier_sli_e2e_latency_seconds", "kgo_verifier_sli_e2e_reads", "Time from record timestamp to consume", func(s *SLIStats) *LatencyTracker { return s.E2ELatency }},
+	} {
+		if !enabled[sli.key] {
+			continue
+		}
+		fmt.Fprintf(w, "# TYPE %s summary\n# UNIT %s seconds\n# HELP %s %s.\n", sli.name, sli.name, sli.name, sli.help)
+		for _, n := range names {
+			writeLatency(w, fmt.Sprintf("worker=%q", n), sli.get(stats[n]), sli.name)
+		}
+		fmt.Fprintf(w, "# TYPE %s counter\n# HELP %s Records measured, with the slowest recent one as exemplar.\n", sli.records, sli.records)
+		for _, n := range names {
+			writeLatencyExemplar(w, fmt.Sprintf("worker=%q", n), sli.get(stats[n]), sli.records)
+		}
+	}
+
+	if enabled[SLIErrorRatio] {
+		type counts struct {
+			operations, errors int64
+			exemplar           *Exemplar
+		}
+		snapshot := make(map[string]counts)
+		for _, n := range names {
+			s := stats[n]
+			s.lock.Lock()
+			snapshot[n] = counts{s.operations, s.errors, s.errorExemplar}
+			s.lock.Unlock()
+		}
+
+		fmt.Fprintf(w, "# TYPE kgo_verifier_sli_operations counter\n# HELP kgo_verifier_sli_operations Produces or fetches attempted.\n")
+		for _, n := range names {
+			fmt.Fprintf(w, "kgo_verifier_sli_operations_total{worker=%q} %d\n", n, snapshot[n].operations)
+		}
+		fmt.Fprintf(w, "# TYPE kgo_verifier_sli_operation_errors counter\n# HELP kgo_verifier_sli_operation_errors Produces or fetches that failed.\n")
+		for _, n := range names {
+			fmt.Fprintf(w, "kgo_verifier_sli_operation_errors_total{worker=%q} %d", n, snapshot[n].errors)
+			writeExemplar(w, snapshot[n].exemplar)
+		}
+		fmt.Fprintf(w, "# TYPE kgo_verifier_sli_error_ratio gauge\n# HELP kgo_verifier_sli_error_ratio Failed operations over all operations.\n")
+		for _, n := range names {
+			ratio := 0.0
+			if c := snapshot[n]; c.operations > 0 {
+				ratio = float64(c.errors) / float64(c.operations)
+			}
+			fmt.Fprintf(w, "kgo_verifier_sli_error_ratio{worker=%q} %g\n", n, ratio)
+		}
+	}
+	fmt.Fprintf(w, "# EOF\n")
+}
@@ -0,0 +1,59 @@
+package worker
+
+import (
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// A panic in a worker, for reporting in status rather than only as a
+// stack trace on stderr.  Statuses carry the panic that stopped the
+// worker, if any.
+type PanicError struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	Stack string `json:"stack"`
+}
+
+func (p *PanicError) Error() string {
+	return fmt.Sprintf("%s: panic: %s", p.Name, p.Value)
+}
+
+// Run fn, running it again if it panics, so that a transient bug in one pass
+// of a worker's loop doesn't end load part way through a long soak test.
+// Workers keep their state outside fn, so that it survives the restart, and
+// onRestart is called before each restart (e.g. to count it in status).
+// Once fn has panicked more than maxRestarts times, the panic is returned
+// as a *PanicError.
+func Supervise(name string, maxRestarts int, onRestart func(), fn func() error) error {
+	restarts := 0
+	for {
+		err, perr := runRecovered(name, fn)
+		if perr == nil {
+			return err
+		}
+		if restarts >= maxRestarts {
+			return perr
+		}
+		restarts += 1
+		log.Warnf("%s: restarting after panic (%d/%d)", name, restarts, maxRestarts)
+		onRestart()
+		time.Sleep(time.Second)
+	}
+}
+
+func runRecovered(name string, fn func() error) (err error, perr *PanicError) {
+	defer func() {
+		if r := recover(); r != nil {
+			perr = &PanicError{
+				Name:  name,
+				Value: fmt.Sprint(r),
+				Stack: string(debug.Stack()),
+			}
+			log.Errorf("%v\n%s", perr, perr.Stack)
+		}
+	}()
+	return fn(), nil
+}
@@ -0,0 +1,177 @@
+package verifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/redpanda-data/kgo-verifier/pkg/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// Acks are grouped into segments of consecutive offsets, so that evidence
+// for every acked record stays small: a new segment starts when the leader
+// changes or offsets skip.  Only this many of each partition's most recent
+// segments are kept, so that a long run's evidence doesn't grow without
+// bound.
+const maxAckSegments = 1024
+
+// A run of consecutive offsets [Lower, Upper) acked by the same leader
+type AckSegment struct {
+	Lower       int64     `json:"lower"`
+	Upper       int64     `json:"upper"`
+	FirstAck    time.Time `json:"first_ack"`
+	LastAck     time.Time `json:"last_ack"`
+	Leader      int32     `json:"leader"`
+	LeaderEpoch int32     `json:"leader_epoch"`
+}
+
+// The producer's record of when, and by which leader, each offset was acked.
+// Leaders are from the producer's most recent metadata request, so are
+// what the producer believed at the time rather than read from the ack.
+type AckEvidence struct {
+	topic      string
+	RunId      string         `json:"run_id,omitempty"`
+	Partitions [][]AckSegment `json:"partitions"`
+
+	// Per partition, offsets below which older segments were dropped
+	Trimmed []int64 `json:"trimmed"`
+}
+
+func NewAckEvidence(topic string, runId string, nPartitions int32) AckEvidence {
+	return AckEvidence{
+		topic:      topic,
+		RunId:      runId,
+		Partitions: make([][]AckSegment, nPartitions),
+		Trimmed:    make([]int64, nPartitions),
+	}
+}
+
+func (ae *AckEvidence) Insert(p int32, o int64, ackedAt time.Time, leader PartitionLeader) {
+	segments := ae.Partitions[p]
+	if n := len(segments); n > 0 {
+		last := &segments[n-1]
+		if o == last.Upper && leader.Leader == last.Leader && leader.LeaderEpoch == last.LeaderEpoch {
+			last.Upper += 1
+			last.LastAck = ackedAt
+			return
+		}
+	}
+	ae.Partitions[p] = append(segments, AckSegment{
+		Lower:       o,
+		Upper:       o + 1,
+		FirstAck:    ackedAt,
+		LastAck:     ackedAt,
+		Leader:      leader.Leader,
+		LeaderEpoch: leader.LeaderEpoch,
+	})
+	if n := len(ae.Partitions[p]) - maxAckSegments; n > 0 {
+		ae.Trimmed[p] = ae.Partitions[p][n].Lower
+		ae.Partitions[p] = append([]AckSegment(nil), ae.Partitions[p][n:]...)
+	}
+}
+
+// A copy to store without holding up further acks
+func (ae *AckEvidence) Clone() AckEvidence {
+	c := AckEvidence{
+		topic:      ae.topic,
+		RunId:      ae.RunId,
+		Partitions: make([][]AckSegment, len(ae.Partitions)),
+		Trimmed:    append([]int64(nil), ae.Trimmed...),
+	}
+	for p, segments := range ae.Partitions {
+		c.Partitions[p] = append([]AckSegment(nil), segments...)
+	}
+	return c
+}
+
+// The segment containing an offset, if the producer acked it
+func (ae *AckEvidence) Lookup(p int32, o int64) *AckSegment {
+	if ae == nil || p < 0 || int(p) >= len(ae.Partitions) {
+		return nil
+	}
+	segments := ae.Partitions[p]
+	i := sort.Search(len(segments), func(i int) bool { return segments[i].Upper > o })
+	if i < len(segments) && segments[i].Lower <= o {
+		return &segments[i]
+	}
+	return nil
+}
+
+// Log what the producer knew about an offset, for reports of lost data
+func (ae *AckEvidence) Report(topic string, p int32, o int64) {
+	if ae == nil {
+		return
+	}
+	if s := ae.Lookup(p, o); s != nil {
+		log.Errorf("Producer evidence for %s/%d o=%d: acked between %s and %s (with offsets %d-%d), leader %d, leader epoch %d",
+			topic, p, o, s.FirstAck.Format(time.RFC3339Nano), s.LastAck.Format(time.RFC3339Nano), s.Lower, s.Upper-1, s.Leader, s.LeaderEpoch)
+	} else if int(p) < len(ae.Trimmed) && o < ae.Trimmed[p] {
+		log.Errorf("Producer evidence for %s/%d o=%d: no longer kept, only from offset %d", topic, p, o, ae.Trimmed[p])
+	} else {
+		log.Errorf("Producer evidence for %s/%d o=%d: no ack recorded", topic, p, o)
+	}
+}
+
+func ackEvidenceFile(topic string, runId string) string {
+	if runId != "" {
+		return fmt.Sprintf("ack_evidence_%s_%s.json", topic, runId)
+	}
+	return fmt.Sprintf("ack_evidence_%s.json", topic)
+}
+
+// For a producer carrying on where a previous one left off
+func loadOrNewAckEvidence(topic string, runId string, nPartitions int32) AckEvidence {
+	ae := LoadAckEvidence(topic, runId)
+	if ae == nil {
+		return NewAckEvidence(topic, runId, nPartitions)
+	}
+	for int32(len(ae.Partitions)) < nPartitions {
+		ae.Partitions = append(ae.Partitions, nil)
+	}
+	for len(ae.Trimmed) < len(ae.Partitions) {
+		ae.Trimmed = append(ae.Trimmed, 0)
+	}
+	return *ae
+}
+
+// Evidence from the producer's last checkpoint, or nil if there is none
+func LoadAckEvidence(topic string, runId string) *AckEvidence {
+	data, err := ioutil.ReadFile(ackEvidenceFile(topic, runId))
+	if err != nil {
+		return nil
+	}
+	var ae AckEvidence
+	if err := json.Unmarshal(data, &ae); err != nil {
+		log.Warnf("Ignoring bad ack evidence file: %v", err)
+		return nil
+	}
+	if ae.RunId != runId {
+		util.DieConfig("ack_evidence is for run '%s', not '%s'", ae.RunId, runId)
+	}
+	ae.topic = topic
+	return &ae
+}
+
+func (ae *AckEvidence) Store() error {
+	data, err := json.Marshal(ae)
+	if err != nil {
+		return err
+	}
+
+	tmp_file, err := ioutil.TempFile("./", "ack_evidence_*.tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp_file.Write(data)
+	tmp_file.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp_file.Name(), ackEvidenceFile(ae.topic, ae.RunId))
+}
@@ -0,0 +1,120 @@
+package verifier
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// How often to compare partitions' delivery delays
+const arrivalSkewInterval = 10 * time.Second
+
+// Weight of each new record in its partition's moving average delay
+const arrivalSkewAlpha = 0.01
+
+type ArrivalSkewStatus struct {
+	// Each partition's moving average delay from record timestamp to our
+	// reading it, and the median of those, in milliseconds
+	DelayMs       []float64 `json:"delay_ms"`
+	MedianDelayMs float64   `json:"median_delay_ms"`
+
+	// Partitions currently delivering much later than the rest, and how
+	// many times any partition has started to
+	Skewed     []int32 `json:"skewed"`
+	SkewEvents int64   `json:"skew_events"`
+}
+
+// Watch for partitions whose records reach us much later after they were
+// produced than the rest do: a sign of localized slowness, such as a slow
+// disk or an overloaded broker, that the topic-wide e2e latency hides.  Like
+// e2e latency, only meaningful for readers keeping up with a producer that
+// doesn't fake its timestamps.
+type ArrivalSkewTracker struct {
+	lock   sync.Mutex
+	topic  string
+	factor float64
+	min    time.Duration
+	status *ArrivalSkewStatus
+
+	// Per partition, in milliseconds, once we have seen a record
+	delay     []float64
+	seen      []bool
+	skewed    []bool
+	checkedAt time.Time
+}
+
+// Partitions are skewed once their delay is more than factor times the
+// median, and more than min above it.
+func NewArrivalSkewTracker(topic string, nPartitions int32, factor float64, min time.Duration, status *ArrivalSkewStatus) *ArrivalSkewTracker {
+	return &ArrivalSkewTracker{
+		topic:     topic,
+		factor:    factor,
+		min:       min,
+		status:    status,
+		delay:     make([]float64, nPartitions),
+		seen:      make([]bool, nPartitions),
+		skewed:    make([]bool, nPartitions),
+		checkedAt: time.Now(),
+	}
+}
+
+func (at *ArrivalSkewTracker) OnRecord(r *kgo.Record) {
+	if at == nil {
+		return
+	}
+	d := float64(time.Since(r.Timestamp).Microseconds()) / 1000
+
+	at.lock.Lock()
+	defer at.lock.Unlock()
+	if !at.seen[r.Partition] {
+		at.delay[r.Partition] = d
+		at.seen[r.Partition] = true
+	} else {
+		at.delay[r.Partition] += arrivalSkewAlpha * (d - at.delay[r.Partition])
+	}
+	if time.Since(at.checkedAt) > arrivalSkewInterval {
+		at.check()
+		at.checkedAt = time.Now()
+	}
+}
+
+func (at *ArrivalSkewTracker) check() {
+	var delays []float64
+	for p, d := range at.delay {
+		if at.seen[p] {
+			delays = append(delays, d)
+		}
+	}
+	if len(delays) < 2 {
+		return
+	}
+	sort.Float64s(delays)
+	median := delays[len(delays)/2]
+	if len(delays)%2 == 0 {
+		median = (delays[len(delays)/2-1] + median) / 2
+	}
+	minMs := float64(at.min.Milliseconds())
+
+	var skewed []int32
+	for i, d := range at.delay {
+		p := int32(i)
+		now := at.seen[p] && d > median*at.factor && d-median > minMs
+		if now && !at.skewed[p] {
+			at.status.SkewEvents += 1
+			log.Warnf("Partition %s/%d delivering late: %.0fms after records were produced, against a median of %.0fms", at.topic, p, d, median)
+		} else if !now && at.skewed[p] {
+			log.Infof("Partition %s/%d delivering in line with the rest again: %.0fms, median %.0fms", at.topic, p, d, median)
+		}
+		at.skewed[p] = now
+		if now {
+			skewed = append(skewed, p)
+		}
+	}
+
+	at.status.DelayMs = append([]float64(nil), at.delay...)
+	at.status.MedianDelayMs = median
+	at.status.Skewed = skewed
+}
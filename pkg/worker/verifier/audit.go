@@ -0,0 +1,301 @@
+package verifier
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	worker "github.com/redpanda-data/kgo-verifier/pkg/worker"
+	log "github.com/sirupsen/logrus"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// How often the audit looks at its throughput to decide whether another
+// reader would help
+const auditTuneInterval = 2 * time.Second
+
+// Another reader must improve throughput by this much to earn its place
+const auditTuneGain = 1.1
+
+type AuditConfig struct {
+	workerCfg   worker.WorkerConfig
+	name        string
+	nPartitions int32
+
+	// Stop short after this long, or after reading this many bytes (0 for
+	// no limit), and read with at most this many partitions at once
+	timeBudget     time.Duration
+	byteBudget     int64
+	maxConcurrency int
+}
+
+func NewAuditConfig(wc worker.WorkerConfig, name string, nPartitions int32, timeBudget time.Duration, byteBudget int64, maxConcurrency int) AuditConfig {
+	return AuditConfig{
+		workerCfg:      wc,
+		name:           name,
+		nPartitions:    nPartitions,
+		timeBudget:     timeBudget,
+		byteBudget:     byteBudget,
+		maxConcurrency: maxConcurrency,
+	}
+}
+
+type AuditStatus struct {
+	Name      string          `json:"name"`
+	Active    bool            `json:"active"`
+	Validator ValidatorStatus `json:"validator"`
+
+	// Partitions with data to audit, and those read to the end
+	Partitions         int `json:"partitions"`
+	PartitionsComplete int `json:"partitions_complete"`
+
+	Records int64 `json:"records"`
+	Bytes   int64 `json:"bytes"`
+	Errors  int64 `json:"errors"`
+
+	// Partitions being read at once, as tuned so far
+	Concurrency int `json:"concurrency"`
+
+	// Which budget stopped the audit short, "time" or "bytes", if any
+	Exhausted string `json:"exhausted,omitempty"`
+
+	StartedAt       time.Time `json:"started_at"`
+	DurationSeconds float64   `json:"duration_seconds"`
+
+	Client worker.ClientSummary `json:"client"`
+
+	lock sync.Mutex
+}
+
+// A one-shot read of every partition of a topic, start to end, checked
+// against the producer's valid offsets: a quick integrity check after an
+// upgrade or a restore, rather than a workload.  Partitions are read in
+// parallel, adding readers for as long as each one adds throughput, and the
+// whole audit is bounded by a time and a byte budget.
+type AuditWorker struct {
+	config AuditConfig
+	Status AuditStatus
+
+	// Bytes read against the budget, apart from any status resets
+	// (guarded by the status lock)
+	budgetUsed int64
+}
+
+func NewAuditWorker(cfg AuditConfig) AuditWorker {
+	return AuditWorker{
+		config: cfg,
+		Status: AuditStatus{Name: cfg.name, Validator: NewValidatorStatus()},
+	}
+}
+
+func (aw *AuditWorker) Wait() error {
+	aw.Status.Active = true
+	defer func() { aw.Status.Active = false }()
+	aw.Status.StartedAt = time.Now()
+	defer func() {
+		aw.Status.lock.Lock()
+		aw.Status.DurationSeconds = time.Since(aw.Status.StartedAt).Seconds()
+		aw.Status.lock.Unlock()
+	}()
+
+	client, err := kgo.NewClient(aw.config.workerCfg.MakeKgoOpts()...)
+	if err != nil {
+		log.Errorf("Error constructing client: %v", err)
+		return err
+	}
+	lwm := GetOffsets(client, aw.config.workerCfg.Topic, aw.config.nPartitions, -2)
+	hwm := GetOffsets(client, aw.config.workerCfg.Topic, aw.config.nPartitions, -1)
+	client.Close()
+
+	validRanges := LoadValidRanges(aw.config.workerCfg, aw.config.nPartitions)
+	aw.Status.Validator.EnableSequenceChecks()
+	aw.Status.Validator.EnableDeadLetters(aw.config.workerCfg)
+
+	queue := make(chan int32, aw.config.nPartitions)
+	for p := int32(0); p < aw.config.nPartitions; p++ {
+		if coordinator := aw.config.workerCfg.Coordinator; coordinator != nil && !coordinator.Owns(p) {
+			continue
+		}
+		if lwm[p] < hwm[p] {
+			queue <- p
+		}
+	}
+	close(queue)
+	remaining := len(queue)
+	aw.Status.Partitions = remaining
+	log.Infof("Auditing %d partitions of %s within %v (byte budget %d), up to %d at once",
+		remaining, aw.config.workerCfg.Topic, aw.config.timeBudget, aw.config.byteBudget, aw.config.maxConcurrency)
+
+	ctx, cancel := context.WithTimeout(context.Background(), aw.config.timeBudget)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	finished := make(chan struct{}, remaining)
+	readers := 0
+	addReader := func() {
+		readers += 1
+		aw.Status.lock.Lock()
+		aw.Status.Concurrency = readers
+		aw.Status.lock.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range queue {
+				if ctx.Err() != nil {
+					return
+				}
+				aw.readPartition(ctx, cancel, p, lwm[p], hwm[p], &validRanges)
+				finished <- struct{}{}
+			}
+		}()
+	}
+	if remaining > 0 {
+		addReader()
+	}
+
+	ticker := time.NewTicker(auditTuneInterval)
+	defer ticker.Stop()
+	tuning := true
+	lastBytes := int64(0)
+	bestRate := 0.0
+loop:
+	for remaining > 0 {
+		select {
+		case <-finished:
+			remaining -= 1
+		case <-ctx.Done():
+			break loop
+		case <-ticker.C:
+			if !tuning {
+				continue
+			}
+			aw.Status.lock.Lock()
+			bytes := aw.budgetUsed
+			aw.Status.lock.Unlock()
+			rate := float64(bytes-lastBytes) / auditTuneInterval.Seconds()
+			lastBytes = bytes
+			if bestRate > 0 && rate < bestRate*auditTuneGain {
+				log.Infof("Audit throughput levelled off at %d readers (%.0f bytes/s)", readers, rate)
+				tuning = false
+			} else if readers >= aw.config.maxConcurrency || readers >= aw.Status.Partitions {
+				tuning = false
+			} else {
+				bestRate = rate
+				addReader()
+				log.Debugf("Audit at %.0f bytes/s, adding reader %d", rate, readers)
+			}
+		}
+	}
+	timedOut := ctx.Err() == context.DeadlineExceeded
+	cancel()
+	wg.Wait()
+
+	aw.Status.lock.Lock()
+	if remaining > 0 && aw.Status.Exhausted == "" && timedOut {
+		aw.Status.Exhausted = "time"
+	}
+	exhausted := aw.Status.Exhausted
+	complete := aw.Status.PartitionsComplete
+	aw.Status.lock.Unlock()
+
+	log.Infof("Audit read %d/%d partitions (validator status %v)", complete, aw.Status.Partitions, aw.Status.Validator.String())
+	aw.Status.Validator.CheckDeadLetters()
+	if exhausted != "" {
+		return fmt.Errorf("audit stopped at its %s budget with %d of %d partitions complete",
+			exhausted, complete, aw.Status.Partitions)
+	} else if complete < aw.Status.Partitions {
+		return fmt.Errorf("audit could not read %d of %d partitions", aw.Status.Partitions-complete, aw.Status.Partitions)
+	}
+	return nil
+}
+
+// Read one partition from start up to end, or until we run out of budget
+func (aw *AuditWorker) readPartition(ctx context.Context, cancel func(), p int32, start int64, end int64, validRanges *TopicOffsetRanges) {
+	offsets := map[string]map[int32]kgo.Offset{
+		aw.config.workerCfg.Topic: {p: kgo.NewOffset().At(start)},
+	}
+	opts := aw.config.workerCfg.MakeKgoOpts()
+	opts = append(opts, kgo.ConsumePartitions(offsets))
+	client, err := kgo.NewClient(opts...)
+	if err != nil {
+		log.Warnf("Error constructing audit client for partition %d: %v", p, err)
+		aw.onError()
+		return
+	}
+	defer client.Close()
+
+	last := start - 1
+	for last < end-1 {
+		fetches := client.PollFetches(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		fetches.EachError(func(t string, p int32, err error) {
+			// Not fatal: the client retries, and we carry on polling
+			log.Warnf("Audit fetch %s/%d e=%v...", t, p, err)
+			aw.onError()
+		})
+
+		var records, bytes int64
+		fetches.EachRecord(func(r *kgo.Record) {
+			if r.Offset > last {
+				last = r.Offset
+			}
+			if r.Offset >= end {
+				return
+			}
+			aw.Status.Validator.ValidateRecord(r, validRanges)
+			records += 1
+			bytes += int64(len(r.Key) + len(r.Value))
+		})
+
+		aw.Status.lock.Lock()
+		aw.Status.Records += records
+		aw.Status.Bytes += bytes
+		aw.budgetUsed += bytes
+		overBudget := aw.config.byteBudget > 0 && aw.budgetUsed >= aw.config.byteBudget && last < end-1
+		if overBudget && aw.Status.Exhausted == "" {
+			aw.Status.Exhausted = "bytes"
+		}
+		aw.Status.lock.Unlock()
+		if overBudget {
+			cancel()
+			return
+		}
+	}
+
+	aw.Status.lock.Lock()
+	aw.Status.PartitionsComplete += 1
+	aw.Status.lock.Unlock()
+	log.Debugf("Audit complete for partition %d up to %d", p, end)
+}
+
+func (aw *AuditWorker) onError() {
+	aw.Status.lock.Lock()
+	defer aw.Status.lock.Unlock()
+	aw.Status.Errors += 1
+}
+
+func (aw *AuditWorker) ResetStats() {
+	aw.Status.lock.Lock()
+	defer aw.Status.lock.Unlock()
+	aw.Status.Records = 0
+	aw.Status.Bytes = 0
+	aw.Status.Errors = 0
+	aw.config.workerCfg.ClientStats.Reset()
+}
+
+func (aw *AuditWorker) GetStatus() interface{} {
+	aw.Status.lock.Lock()
+	defer aw.Status.lock.Unlock()
+	if aw.Status.Active {
+		aw.Status.DurationSeconds = time.Since(aw.Status.StartedAt).Seconds()
+	}
+	aw.Status.Client = aw.config.workerCfg.ClientStats.Summary()
+	return &aw.Status
+}
+
+func (aw *AuditWorker) GetClientStats() *worker.ClientStats {
+	return aw.config.workerCfg.ClientStats
+}
@@ -0,0 +1,35 @@
+package verifier
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Header carrying the CRC32C of the record's value, in hex, so that a
+// consumer can tell a corrupted payload from a record that is merely at an
+// unexpected offset.
+const ChecksumHeader = "kgo-verifier-crc"
+
+func payloadChecksum(value []byte) string {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], crc32.Checksum(value, crc32c))
+	return hex.EncodeToString(b[:])
+}
+
+func makeChecksumHeader(value []byte) kgo.RecordHeader {
+	return kgo.RecordHeader{Key: ChecksumHeader, Value: []byte(payloadChecksum(value))}
+}
+
+// Returns the checksum we expected and the one the record carries, and
+// whether it carried one to check
+func recordChecksum(r *kgo.Record) (expect string, found string, checked bool) {
+	for _, h := range r.Headers {
+		if h.Key == ChecksumHeader {
+			return string(h.Value), payloadChecksum(r.Value), true
+		}
+	}
+	return "", "", false
+}
@@ -3,8 +3,11 @@ package verifier
 import (
 	"context"
 	"errors"
+	"fmt"
 	"time"
 
+	"github.com/redpanda-data/kgo-verifier/pkg/util"
+	worker "github.com/redpanda-data/kgo-verifier/pkg/worker"
 	log "github.com/sirupsen/logrus"
 	"github.com/twmb/franz-go/pkg/kerr"
 	"github.com/twmb/franz-go/pkg/kgo"
@@ -79,3 +82,181 @@ func getOffsetsInner(client *kgo.Client, topic string, nPartitions int32, t int6
 
 	return pOffsets, r_err
 }
+
+type PartitionReplicas struct {
+	TopicId  [16]byte
+	Leader   int32
+	Replicas []int32
+}
+
+// Look up where a partition's replicas live, for operations that need to talk
+// to a specific broker rather than letting the client route to the leader.
+func GetPartitionReplicas(client *kgo.Client, topic string, partition int32) (PartitionReplicas, error) {
+	req := kmsg.NewPtrMetadataRequest()
+	reqTopic := kmsg.NewMetadataRequestTopic()
+	reqTopic.Topic = kmsg.StringPtr(topic)
+	req.Topics = append(req.Topics, reqTopic)
+
+	resp, err := req.RequestWith(context.Background(), client)
+	if err != nil {
+		return PartitionReplicas{}, err
+	}
+	if len(resp.Topics) != 1 {
+		return PartitionReplicas{}, fmt.Errorf("metadata response returned %d topics when we asked for 1", len(resp.Topics))
+	}
+	t := resp.Topics[0]
+	if t.ErrorCode != 0 {
+		return PartitionReplicas{}, kerr.ErrorForCode(t.ErrorCode)
+	}
+
+	for _, p := range t.Partitions {
+		if p.Partition == partition {
+			if p.ErrorCode != 0 {
+				return PartitionReplicas{}, kerr.ErrorForCode(p.ErrorCode)
+			}
+			return PartitionReplicas{
+				TopicId:  t.TopicID,
+				Leader:   p.Leader,
+				Replicas: p.Replicas,
+			}, nil
+		}
+	}
+
+	return PartitionReplicas{}, fmt.Errorf("partition %s/%d not found in metadata", topic, partition)
+}
+
+// How many partitions the topic has: kerr.UnknownTopicOrPartition if it
+// doesn't exist
+func GetPartitionCount(client *kgo.Client, topic string) (int32, error) {
+	req := kmsg.NewPtrMetadataRequest()
+	reqTopic := kmsg.NewMetadataRequestTopic()
+	reqTopic.Topic = kmsg.StringPtr(topic)
+	req.Topics = append(req.Topics, reqTopic)
+
+	resp, err := req.RequestWith(context.Background(), client)
+	if err != nil {
+		return 0, err
+	}
+	if len(resp.Topics) != 1 {
+		return 0, fmt.Errorf("metadata response returned %d topics when we asked for 1", len(resp.Topics))
+	}
+	if err := kerr.ErrorForCode(resp.Topics[0].ErrorCode); err != nil {
+		return 0, err
+	}
+	return int32(len(resp.Topics[0].Partitions)), nil
+}
+
+// For readers that must see fetch sessions used, once they have read
+// everything they were going to
+func checkFetchSessions(wc worker.WorkerConfig) {
+	if !wc.RequireFetchSessions {
+		return
+	}
+	if err := wc.FetchSessions.Check(); err != nil {
+		util.DieValidation("Fetch sessions not in use: %v", err)
+	}
+	log.Infof("Fetch sessions OK: %+v", wc.FetchSessions.Summary())
+}
+
+type PartitionLeader struct {
+	Leader      int32 `json:"leader"`
+	LeaderEpoch int32 `json:"leader_epoch"`
+}
+
+func unknownLeaders(nPartitions int32) []PartitionLeader {
+	leaders := make([]PartitionLeader, nPartitions)
+	for i := range leaders {
+		leaders[i] = PartitionLeader{Leader: -1, LeaderEpoch: -1}
+	}
+	return leaders
+}
+
+// The current leader and leader epoch of each partition, as of a metadata
+// request.  Partitions missing from the response are reported as -1.
+func GetLeaders(client *kgo.Client, topic string, nPartitions int32) ([]PartitionLeader, error) {
+	req := kmsg.NewPtrMetadataRequest()
+	reqTopic := kmsg.NewMetadataRequestTopic()
+	reqTopic.Topic = kmsg.StringPtr(topic)
+	req.Topics = append(req.Topics, reqTopic)
+
+	resp, err := req.RequestWith(context.Background(), client)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Topics) != 1 {
+		return nil, fmt.Errorf("metadata response returned %d topics when we asked for 1", len(resp.Topics))
+	}
+	t := resp.Topics[0]
+	if t.ErrorCode != 0 {
+		return nil, kerr.ErrorForCode(t.ErrorCode)
+	}
+
+	leaders := unknownLeaders(nPartitions)
+	for _, p := range t.Partitions {
+		if p.Partition >= 0 && p.Partition < nPartitions && p.ErrorCode == 0 {
+			leaders[p.Partition] = PartitionLeader{Leader: p.Leader, LeaderEpoch: p.LeaderEpoch}
+		}
+	}
+	return leaders, nil
+}
+
+// Create a topic and wait for it to show up in metadata.  A replication
+// factor of -1 takes the broker's default.
+func CreateTopic(client *kgo.Client, topic string, nPartitions int32, replicas int16) error {
+	req := kmsg.NewPtrCreateTopicsRequest()
+	reqTopic := kmsg.NewCreateTopicsRequestTopic()
+	reqTopic.Topic = topic
+	reqTopic.NumPartitions = nPartitions
+	reqTopic.ReplicationFactor = replicas
+	req.Topics = append(req.Topics, reqTopic)
+	req.TimeoutMillis = 30000
+
+	resp, err := req.RequestWith(context.Background(), client)
+	if err != nil {
+		return err
+	}
+	for _, t := range resp.Topics {
+		if err := kerr.ErrorForCode(t.ErrorCode); err != nil {
+			return fmt.Errorf("creating topic %s: %v", t.Topic, err)
+		}
+	}
+
+	for deadline := time.Now().Add(30 * time.Second); ; {
+		leaders, err := GetLeaders(client, topic, nPartitions)
+		if err == nil {
+			led := 0
+			for _, l := range leaders {
+				if l.Leader >= 0 {
+					led += 1
+				}
+			}
+			if led == int(nPartitions) {
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("topic %s not ready after creation", topic)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func DeleteTopic(client *kgo.Client, topic string) error {
+	req := kmsg.NewPtrDeleteTopicsRequest()
+	req.TopicNames = []string{topic}
+	reqTopic := kmsg.NewDeleteTopicsRequestTopic()
+	reqTopic.Topic = kmsg.StringPtr(topic)
+	req.Topics = append(req.Topics, reqTopic)
+	req.TimeoutMillis = 30000
+
+	resp, err := req.RequestWith(context.Background(), client)
+	if err != nil {
+		return err
+	}
+	for _, t := range resp.Topics {
+		if err := kerr.ErrorForCode(t.ErrorCode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package verifier
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"github.com/redpanda-data/kgo-verifier/pkg/util"
+	worker "github.com/redpanda-data/kgo-verifier/pkg/worker"
+	log "github.com/sirupsen/logrus"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Headers on dead-lettered records saying why, and where they came from
+const (
+	DeadLetterReasonHeader    = "kgo-verifier-dlq-reason"
+	DeadLetterTopicHeader     = "kgo-verifier-dlq-topic"
+	DeadLetterPartitionHeader = "kgo-verifier-dlq-partition"
+	DeadLetterOffsetHeader    = "kgo-verifier-dlq-offset"
+)
+
+// Like a consumer that parks records it can't process on a dead-letter
+// topic and carries on, rather than stopping at the first one.
+type DeadLetters struct {
+	client *kgo.Client
+	topic  string
+
+	// Validation failures sent, and of those written or not
+	lock   sync.Mutex
+	sent   int64
+	acked  int64
+	failed int64
+}
+
+func NewDeadLetters(wc worker.WorkerConfig) *DeadLetters {
+	wc.Topic = wc.DeadLetterTopic
+	client, err := kgo.NewClient(wc.MakeKgoOpts()...)
+	util.Chk(err, "Error creating dead-letter client: %v", err)
+	return &DeadLetters{client: client, topic: wc.DeadLetterTopic}
+}
+
+func (dl *DeadLetters) Send(r *kgo.Record, reason string) {
+	headers := append([]kgo.RecordHeader(nil), r.Headers...)
+	headers = append(headers,
+		kgo.RecordHeader{Key: DeadLetterReasonHeader, Value: []byte(reason)},
+		kgo.RecordHeader{Key: DeadLetterTopicHeader, Value: []byte(r.Topic)},
+		kgo.RecordHeader{Key: DeadLetterPartitionHeader, Value: []byte(strconv.Itoa(int(r.Partition)))},
+		kgo.RecordHeader{Key: DeadLetterOffsetHeader, Value: []byte(strconv.FormatInt(r.Offset, 10))},
+	)
+	dead := &kgo.Record{Key: r.Key, Value: r.Value, Headers: headers, Topic: dl.topic}
+
+	dl.lock.Lock()
+	dl.sent += 1
+	dl.lock.Unlock()
+	dl.client.Produce(context.Background(), dead, func(_ *kgo.Record, err error) {
+		dl.lock.Lock()
+		defer dl.lock.Unlock()
+		if err != nil {
+			log.Errorf("Error writing to dead-letter topic %s: %v", dl.topic, err)
+			dl.failed += 1
+		} else {
+			dl.acked += 1
+		}
+	})
+}
+
+// Once everything sent is acked, the dead-letter topic must hold one record
+// for each validation failure, and there must have been none of those.
+func (dl *DeadLetters) Check() {
+	err := dl.client.Flush(context.Background())
+	util.Chk(err, "Error flushing dead-letter topic: %v", err)
+
+	dl.lock.Lock()
+	defer dl.lock.Unlock()
+	log.Infof("Dead-letter topic %s: %d records for %d validation failures (%d failed to write)", dl.topic, dl.acked, dl.sent, dl.failed)
+	if dl.acked != dl.sent {
+		util.DieValidation("Dead-letter topic %s has %d records for %d validation failures", dl.topic, dl.acked, dl.sent)
+	}
+	if dl.sent > 0 {
+		util.DieValidation("%d records failed validation, see dead-letter topic %s", dl.sent, dl.topic)
+	}
+}
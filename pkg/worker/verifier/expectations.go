@@ -0,0 +1,133 @@
+package verifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/redpanda-data/kgo-verifier/pkg/util"
+	log "github.com/sirupsen/logrus"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Expectations written by some other tool, for validating data from
+// producers other than ours that follow a predictable key format:
+//
+//	{
+//	  "topic": "mytopic",
+//	  "key_pattern": "{partition}-{offset:012}",
+//	  "partitions": [
+//	    {"partition": 0, "ranges": [{"lower": 0, "upper": 1000}]}
+//	  ]
+//	}
+//
+// Ranges are [lower, upper).  The key pattern is literal text, with
+// {offset} and {partition} replaced by the record's offset and partition,
+// optionally zero padded to a width as in {offset:018}.
+type ExpectationsManifest struct {
+	Topic      string              `json:"topic"`
+	KeyPattern string              `json:"key_pattern"`
+	Partitions []ManifestPartition `json:"partitions"`
+}
+
+type ManifestPartition struct {
+	Partition int32           `json:"partition"`
+	Ranges    []ManifestRange `json:"ranges"`
+}
+
+type ManifestRange struct {
+	Lower int64 `json:"lower"`
+	Upper int64 `json:"upper"`
+}
+
+// The key format our own producer writes
+const defaultKeyPattern = "kgov1.000000.{offset:018}"
+
+// A key pattern compiled down to a format string
+type KeyPattern struct {
+	format string
+	fields []string
+}
+
+var keyPatternField = regexp.MustCompile(`\{(offset|partition)(?::(0\d+))?\}`)
+
+func ParseKeyPattern(pattern string) (*KeyPattern, error) {
+	kp := &KeyPattern{}
+	var format strings.Builder
+	last := 0
+	for _, m := range keyPatternField.FindAllStringSubmatchIndex(pattern, -1) {
+		literal := pattern[last:m[0]]
+		if strings.ContainsAny(literal, "{}") {
+			return nil, fmt.Errorf("bad key pattern '%s' near '%s'", pattern, literal)
+		}
+		format.WriteString(strings.ReplaceAll(literal, "%", "%%"))
+		format.WriteString("%")
+		if m[4] >= 0 {
+			format.WriteString(pattern[m[4]:m[5]])
+		}
+		format.WriteString("d")
+		kp.fields = append(kp.fields, pattern[m[2]:m[3]])
+		last = m[1]
+	}
+	literal := pattern[last:]
+	if strings.ContainsAny(literal, "{}") {
+		return nil, fmt.Errorf("bad key pattern '%s' near '%s'", pattern, literal)
+	}
+	format.WriteString(strings.ReplaceAll(literal, "%", "%%"))
+	kp.format = format.String()
+	return kp, nil
+}
+
+func (kp *KeyPattern) Key(r *kgo.Record) string {
+	args := make([]interface{}, len(kp.fields))
+	for i, f := range kp.fields {
+		if f == "offset" {
+			args[i] = r.Offset
+		} else {
+			args[i] = r.Partition
+		}
+	}
+	return fmt.Sprintf(kp.format, args...)
+}
+
+func LoadExpectationsManifest(path string, topic string, nPartitions int32) TopicOffsetRanges {
+	log.Infof("Loading expectations from %s...", path)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		util.DieConfig("Error reading expectations manifest: %v", err)
+	}
+
+	var m ExpectationsManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		util.DieConfig("Bad expectations manifest JSON: %v", err)
+	}
+	if m.Topic != "" && m.Topic != topic {
+		util.DieConfig("Expectations manifest is for topic '%s', not '%s'", m.Topic, topic)
+	}
+	if m.KeyPattern == "" {
+		m.KeyPattern = defaultKeyPattern
+	}
+	kp, err := ParseKeyPattern(m.KeyPattern)
+	if err != nil {
+		util.DieConfig("%v", err)
+	}
+
+	if nPartitions < 0 {
+		nPartitions = 0
+	}
+	tors := NewTopicOffsetRanges(topic, "", nPartitions)
+	tors.keyPattern = kp
+	for _, mp := range m.Partitions {
+		if mp.Partition < 0 || (nPartitions > 0 && mp.Partition >= nPartitions) {
+			util.DieConfig("Expectations manifest has partition %d, topic has %d partitions", mp.Partition, nPartitions)
+		}
+		tors.ensurePartition(mp.Partition)
+		for _, r := range mp.Ranges {
+			tors.PartitionRanges[mp.Partition].Ranges = append(tors.PartitionRanges[mp.Partition].Ranges,
+				OffsetRange{Lower: r.Lower, Upper: r.Upper})
+		}
+	}
+	return tors
+}
@@ -2,14 +2,19 @@ package verifier
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/rcrowley/go-metrics"
 	worker "github.com/redpanda-data/kgo-verifier/pkg/worker"
 	log "github.com/sirupsen/logrus"
+	"github.com/twmb/franz-go/pkg/kerr"
 	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
 )
 
 type GroupReadConfig struct {
@@ -17,32 +22,212 @@ type GroupReadConfig struct {
 	name        string
 	nPartitions int32
 	nReaders    int
+
+	// Deliberately have one reader overrun its group timeouts during a
+	// rebalance, to check that the broker evicts it and the rest of the
+	// group carries on.
+	evictionTest bool
 }
 
-func NewGroupReadConfig(wc worker.WorkerConfig, name string, nPartitions int32, nReaders int) GroupReadConfig {
+func NewGroupReadConfig(wc worker.WorkerConfig, name string, nPartitions int32, nReaders int, evictionTest bool) GroupReadConfig {
 	return GroupReadConfig{
-		workerCfg:   wc,
-		name:        name,
-		nPartitions: nPartitions,
-		nReaders:    nReaders,
+		workerCfg:    wc,
+		name:         name,
+		nPartitions:  nPartitions,
+		nReaders:     nReaders,
+		evictionTest: evictionTest,
 	}
 }
 
+// franz-go defaults, for when timeouts are not configured
+const (
+	defaultSessionTimeout   = 45 * time.Second
+	defaultRebalanceTimeout = 60 * time.Second
+)
+
 type GroupWorkerStatus struct {
-	Validator ValidatorStatus `json:"validator"`
-	Active    bool            `json:"active"`
-	Errors    int             `json:"errors"`
+	Validator ValidatorStatus      `json:"validator"`
+	Active    bool                 `json:"active"`
+	Errors    int                  `json:"errors"`
+	Stuck     StuckPartitionStatus `json:"stuck"`
+
+	// Partitions delivering late relative to the rest, if tracked
+	ArrivalSkew ArrivalSkewStatus `json:"arrival_skew"`
+
+	StartedAt time.Time `json:"started_at"`
+
+	// OffsetCommit requests issued by the group readers, and how many
+	// of them failed (either outright or for any partition)
+	Commits        int64 `json:"commits"`
+	CommitFailures int64 `json:"commit_failures"`
+
+	// OffsetCommit latency: a private histogram for the data,
+	// and a public summary for JSON output
+	commitLatency metrics.Histogram
+	CommitLatency worker.HistogramSummary `json:"commit_latency"`
+
+	Retries worker.RetrySummary `json:"retries"`
+
+	Client worker.ClientSummary `json:"client"`
+
+	// Fetch session usage, if tracked
+	FetchSessions worker.FetchSessionSummary `json:"fetch_sessions"`
+
+	// Most recent partition assignment changes across all readers
+	Assignments []AssignmentEvent `json:"assignments"`
+
+	// How many times was a reader kicked out of the group by the
+	// broker (e.g. for exceeding session or rebalance timeouts)?
+	Evictions int64 `json:"evictions"`
+
+	// How many times did we restart a reader fiber after a panic?
+	Restarts int64 `json:"restarts"`
+
+	Panic *worker.PanicError `json:"panic,omitempty"`
+
+	lock sync.Mutex
+}
+
+// Bound the audit trail so that very long runs with frequent
+// rebalances don't grow the status without limit
+const maxAssignmentEvents = 1024
+
+type AssignmentEvent struct {
+	Time  time.Time `json:"time"`
+	Fiber int       `json:"fiber"`
+
+	// "assigned", "offsets_fetched" (the offsets we will resume
+	// consuming from), "revoked" or "lost"
+	Kind string `json:"kind"`
+
+	// Partition -> committed offset at the time of the event, or
+	// -1 where not known (e.g. at assignment, before offsets are fetched)
+	Offsets map[int32]int64 `json:"offsets"`
+
+	// For evictions, the error that ended the group session
+	Error string `json:"error,omitempty"`
+}
+
+func (self *GroupWorkerStatus) OnAssignmentEvent(e AssignmentEvent) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	log.Infof("fiber %v: partitions %s: %v", e.Fiber, e.Kind, e.Offsets)
+	self.Assignments = append(self.Assignments, e)
+	if len(self.Assignments) > maxAssignmentEvents {
+		self.Assignments = self.Assignments[len(self.Assignments)-maxAssignmentEvents:]
+	}
+}
+
+// Watches group session errors for signs that the broker evicted us
+type evictionHook struct {
+	status  *GroupWorkerStatus
+	fiberId int
+}
+
+func (h *evictionHook) OnGroupManageError(err error) {
+	if errors.Is(err, kerr.UnknownMemberID) || errors.Is(err, kerr.IllegalGeneration) || errors.Is(err, kerr.FencedInstanceID) {
+		h.status.lock.Lock()
+		h.status.Evictions += 1
+		h.status.lock.Unlock()
+		h.status.OnAssignmentEvent(AssignmentEvent{
+			Time:  time.Now(),
+			Fiber: h.fiberId,
+			Kind:  "evicted",
+			Error: err.Error(),
+		})
+	}
+}
+
+func (grw *GroupReadWorker) recordAssignment(fiberId int, kind string, client *kgo.Client, partitions []int32) {
+	committed := client.CommittedOffsets()[grw.config.workerCfg.Topic]
+	offsets := make(map[int32]int64, len(partitions))
+	for _, p := range partitions {
+		if eo, ok := committed[p]; ok {
+			offsets[p] = eo.Offset
+		} else {
+			offsets[p] = -1
+		}
+	}
+	grw.Status.OnAssignmentEvent(AssignmentEvent{
+		Time:    time.Now(),
+		Fiber:   fiberId,
+		Kind:    kind,
+		Offsets: offsets,
+	})
+}
+
+func NewGroupWorkerStatus() GroupWorkerStatus {
+	return GroupWorkerStatus{
+		commitLatency: metrics.NewHistogram(metrics.NewExpDecaySample(1024, 0.015)),
+	}
+}
+
+func (self *GroupWorkerStatus) OnCommit(resp *kmsg.OffsetCommitResponse, err error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.Commits += 1
+
+	failed := err != nil
+	if resp != nil {
+		for _, t := range resp.Topics {
+			for _, p := range t.Partitions {
+				if p.ErrorCode != 0 {
+					log.Warnf("Offset commit error on %s/%d: %v", t.Topic, p.Partition, kerr.ErrorForCode(p.ErrorCode))
+					failed = true
+				}
+			}
+		}
+	}
+	if err != nil {
+		log.Warnf("Offset commit error: %v", err)
+	}
+	if failed {
+		self.CommitFailures += 1
+	}
+}
+
+// Times OffsetCommit requests on the wire
+type commitLatencyHook struct {
+	status *GroupWorkerStatus
+}
+
+func (h *commitLatencyHook) OnBrokerE2E(meta kgo.BrokerMetadata, key int16, e2e kgo.BrokerE2E) {
+	if key == kmsg.OffsetCommit.Int16() && e2e.Err() == nil {
+		h.status.commitLatency.Update(e2e.DurationE2E().Microseconds())
+	}
 }
 
 type GroupReadWorker struct {
 	config GroupReadConfig
 	Status GroupWorkerStatus
+	paused PausedPartitions
+
+	// Set once the eviction test has stalled a reader
+	evictionStalled int32
+
+	// Shared by the readers, if tracking arrival skew
+	skew *ArrivalSkewTracker
+}
+
+func (grw *GroupReadWorker) sessionTimeout() time.Duration {
+	if grw.config.workerCfg.SessionTimeout != 0 {
+		return grw.config.workerCfg.SessionTimeout
+	}
+	return defaultSessionTimeout
+}
+
+func (grw *GroupReadWorker) rebalanceTimeout() time.Duration {
+	if grw.config.workerCfg.RebalanceTimeout != 0 {
+		return grw.config.workerCfg.RebalanceTimeout
+	}
+	return defaultRebalanceTimeout
 }
 
 func NewGroupReadWorker(cfg GroupReadConfig) GroupReadWorker {
 	return GroupReadWorker{
 		config: cfg,
-		Status: GroupWorkerStatus{},
+		Status: NewGroupWorkerStatus(),
+		paused: NewPausedPartitions(cfg.workerCfg.Topic),
 	}
 }
 
@@ -94,6 +279,9 @@ func (cgs *ConsumerGroupOffsets) AddRecord(r *kgo.Record) {
 func (grw *GroupReadWorker) Wait() error {
 	grw.Status.Active = true
 	defer func() { grw.Status.Active = false }()
+	if grw.Status.StartedAt.IsZero() {
+		grw.Status.StartedAt = time.Now()
+	}
 
 	client, err := kgo.NewClient(grw.config.workerCfg.MakeKgoOpts()...)
 	if err != nil {
@@ -122,44 +310,160 @@ func (grw *GroupReadWorker) Wait() error {
 	log.Infof("Reading with consumer group %s", groupName)
 
 	status := NewValidatorStatus()
+	grw.skew = nil
+	if factor := grw.config.workerCfg.ArrivalSkewFactor; factor > 0 {
+		grw.skew = NewArrivalSkewTracker(grw.config.workerCfg.Topic, grw.config.nPartitions, factor, grw.config.workerCfg.ArrivalSkewMin, &grw.Status.ArrivalSkew)
+	}
 	ctx, cancelFunc := context.WithCancel(context.Background())
 	cgOffsets := NewConsumerGroupOffsets(hwms, cancelFunc)
 
+	var watchdog *StuckPartitionWatchdog
+	if window := grw.config.workerCfg.StuckPartitionWindow; window > 0 {
+		watchdog = NewStuckPartitionWatchdog(window, startOffsets, hwms, &grw.Status.Stuck)
+		go watchdog.Run(ctx, &grw.paused, func(p int32) {
+			clients := grw.paused.Clients()
+			if len(clients) == 0 {
+				return
+			}
+			watchdog.Diagnose(clients[0], grw.config.workerCfg.Topic, p)
+			if grw.config.workerCfg.StuckPartitionRecover {
+				// We don't know which group member owns the partition, and
+				// shouldn't seek under the group's feet: just refresh metadata.
+				for _, c := range clients {
+					watchdog.Recover(c, grw.config.workerCfg.Topic, p, false)
+				}
+			}
+		})
+	}
+
 	var wg sync.WaitGroup
+	// The caller must wg.Add(1) before running a reader, so that wg.Wait
+	// can't return before the reader has been counted.
+	runReader := func(fiberId int) {
+		defer wg.Done()
+		for {
+			err := worker.Supervise(fmt.Sprintf("fiber %v", fiberId), grw.config.workerCfg.PanicRestarts, func() {
+				atomic.AddInt64(&grw.Status.Restarts, 1)
+			}, func() error {
+				return grw.consumerGroupReadInner(
+					ctx, fiberId, groupName, &cgOffsets, watchdog)
+			})
+			if perr, ok := err.(*worker.PanicError); ok {
+				// Stop the other fibers too: the caller decides what to do next
+				grw.Status.lock.Lock()
+				grw.Status.Panic = perr
+				grw.Status.lock.Unlock()
+				cancelFunc()
+				break
+			} else if err != nil {
+				log.Warnf(
+					"fiber %v: restarting consumer group reader for error %v",
+					fiberId, err)
+				// Loop around and retry
+			} else {
+				log.Infof("fiber %v: consumer group reader finished", fiberId)
+				break
+			}
+		}
+	}
 	for i := 0; i < int(grw.config.nReaders); i++ {
 		wg.Add(1)
-		go func(fiberId int) {
-			for {
-				err := grw.consumerGroupReadInner(
-					ctx, fiberId, groupName, &cgOffsets)
-				if err != nil {
-					log.Warnf(
-						"fiber %v: restarting consumer group reader for error %v",
-						fiberId, err)
-					// Loop around and retry
-				} else {
-					log.Infof("fiber %v: consumer group reader finished", fiberId)
-					break
-				}
+		go runReader(i)
+	}
+
+	if grw.config.evictionTest {
+		// Once the group has settled, join an extra reader to provoke a
+		// rebalance, during which fiber 0 will stall.
+		wg.Add(1)
+		go func() {
+			select {
+			case <-ctx.Done():
+				wg.Done()
+			case <-time.After(grw.sessionTimeout()):
+				log.Infof("Eviction test: adding reader to trigger rebalance")
+				runReader(int(grw.config.nReaders))
 			}
-			wg.Done()
-		}(i)
+		}()
 	}
 
 	wg.Wait()
 	status.Checkpoint()
+	if grw.Status.Panic != nil {
+		return grw.Status.Panic
+	}
+	checkFetchSessions(grw.config.workerCfg)
+	grw.Status.Validator.CheckDeadLetters()
 	return nil
 }
 
 func (grw *GroupReadWorker) consumerGroupReadInner(
 	ctx context.Context,
 	fiberId int, groupName string,
-	cgOffsets *ConsumerGroupOffsets) error {
+	cgOffsets *ConsumerGroupOffsets,
+	watchdog *StuckPartitionWatchdog) error {
 
 	opts := grw.config.workerCfg.MakeKgoOpts()
+	opts = append(opts, grw.config.workerCfg.MakeGroupOpts()...)
+	if grw.config.evictionTest {
+		// Eager rebalancing, so that every member has its partitions
+		// revoked (and fiber 0 gets a chance to stall) on each rebalance
+		opts = append(opts, kgo.Balancers(kgo.RangeBalancer()))
+	}
 	opts = append(opts, []kgo.Opt{
 		kgo.ConsumeTopics(grw.config.workerCfg.Topic),
 		kgo.ConsumerGroup(groupName),
+		kgo.WithHooks(&commitLatencyHook{status: &grw.Status}),
+		kgo.WithHooks(&evictionHook{status: &grw.Status, fiberId: fiberId}),
+		kgo.AutoCommitCallback(func(_ *kgo.Client, _ *kmsg.OffsetCommitRequest, resp *kmsg.OffsetCommitResponse, err error) {
+			grw.Status.OnCommit(resp, err)
+		}),
+		kgo.OnPartitionsAssigned(func(_ context.Context, cl *kgo.Client, assigned map[string][]int32) {
+			grw.recordAssignment(fiberId, "assigned", cl, assigned[grw.config.workerCfg.Topic])
+		}),
+		kgo.OnOffsetsFetched(func(_ context.Context, _ *kgo.Client, resp *kmsg.OffsetFetchResponse) error {
+			offsets := make(map[int32]int64)
+			for _, t := range resp.Topics {
+				for _, p := range t.Partitions {
+					offsets[p.Partition] = p.Offset
+				}
+			}
+			// OffsetFetch v8+ nests results per group
+			for _, g := range resp.Groups {
+				for _, t := range g.Topics {
+					for _, p := range t.Partitions {
+						offsets[p.Partition] = p.Offset
+					}
+				}
+			}
+			grw.Status.OnAssignmentEvent(AssignmentEvent{
+				Time:    time.Now(),
+				Fiber:   fiberId,
+				Kind:    "offsets_fetched",
+				Offsets: offsets,
+			})
+			return nil
+		}),
+		kgo.OnPartitionsRevoked(func(ctx context.Context, cl *kgo.Client, revoked map[string][]int32) {
+			// Setting this callback replaces the client's default blocking
+			// commit on revoke, so we must do it ourselves.
+			err := cl.CommitUncommittedOffsets(ctx)
+			if err != nil {
+				log.Warnf("fiber %v: error committing offsets on revoke: %v", fiberId, err)
+			}
+			grw.recordAssignment(fiberId, "revoked", cl, revoked[grw.config.workerCfg.Topic])
+
+			if fiberId == 0 && grw.config.evictionTest && atomic.CompareAndSwapInt32(&grw.evictionStalled, 0, 1) {
+				stall := grw.sessionTimeout() + grw.rebalanceTimeout() + time.Second
+				log.Infof("Eviction test: fiber %v stalling in revoke for %v", fiberId, stall)
+				select {
+				case <-ctx.Done():
+				case <-time.After(stall):
+				}
+			}
+		}),
+		kgo.OnPartitionsLost(func(_ context.Context, cl *kgo.Client, lost map[string][]int32) {
+			grw.recordAssignment(fiberId, "lost", cl, lost[grw.config.workerCfg.Topic])
+		}),
 	}...)
 	client, err := kgo.NewClient(opts...)
 	if err != nil {
@@ -168,16 +472,25 @@ func (grw *GroupReadWorker) consumerGroupReadInner(
 		return err
 	}
 	defer client.Close()
+	grw.paused.Register(client)
+	defer grw.paused.Unregister(client)
 
-	validRanges := LoadTopicOffsetRanges(grw.config.workerCfg.Topic, grw.config.nPartitions)
+	validRanges := LoadValidRanges(grw.config.workerCfg, grw.config.nPartitions)
+	if grw.config.workerCfg.RefetchInvalid {
+		grw.Status.Validator.SetRefetcher(NewRefetcher(grw.config.workerCfg))
+	}
+	grw.Status.Validator.EnableSequenceChecks()
+	grw.Status.Validator.EnableDeadLetters(grw.config.workerCfg)
 
 	for {
+		grw.config.workerCfg.Freeze.Wait()
 		fetches := client.PollFetches(ctx)
 		if ctx.Err() == context.Canceled {
 			break
 		} else if ctx.Err() != nil {
 			return ctx.Err()
 		}
+		grw.config.workerCfg.SLIs.OnFetches(fetches)
 
 		var r_err error
 		fetches.EachError(func(t string, p int32, err error) {
@@ -196,6 +509,11 @@ func (grw *GroupReadWorker) consumerGroupReadInner(
 				"fiber %v: Consumer group read %s/%d o=%d...",
 				fiberId, grw.config.workerCfg.Topic, r.Partition, r.Offset)
 			grw.Status.Validator.ValidateRecord(r, &validRanges)
+			grw.config.workerCfg.SLIs.ObserveE2E(r)
+			grw.skew.OnRecord(r)
+			if watchdog != nil {
+				watchdog.OnRecord(r)
+			}
 			// Will cancel the context if we have read everything
 			cgOffsets.AddRecord(r)
 		})
@@ -207,9 +525,36 @@ func (grw *GroupReadWorker) consumerGroupReadInner(
 }
 
 func (grw *GroupReadWorker) ResetStats() {
-	grw.Status = GroupWorkerStatus{}
+	startedAt := grw.Status.StartedAt
+	grw.Status = NewGroupWorkerStatus()
+	grw.Status.StartedAt = startedAt
+	grw.config.workerCfg.Retries.Reset()
+	grw.config.workerCfg.ClientStats.Reset()
+	grw.config.workerCfg.FetchSessions.Reset()
+}
+
+func (grw *GroupReadWorker) PausePartitions(partitions []int32) []int32 {
+	return grw.paused.Pause(partitions)
+}
+
+func (grw *GroupReadWorker) ResumePartitions(partitions []int32) []int32 {
+	return grw.paused.Resume(partitions)
 }
 
 func (grw *GroupReadWorker) GetStatus() interface{} {
+	// Update public summary from private statistics
+	grw.Status.CommitLatency = worker.SummarizeHistogram(&grw.Status.commitLatency)
+	grw.Status.Retries = grw.config.workerCfg.Retries.Summary()
+	grw.Status.Client = grw.config.workerCfg.ClientStats.Summary()
+	grw.Status.FetchSessions = grw.config.workerCfg.FetchSessions.Summary()
+
 	return &grw.Status
 }
+
+func (grw *GroupReadWorker) GetClientStats() *worker.ClientStats {
+	return grw.config.workerCfg.ClientStats
+}
+
+func (grw *GroupReadWorker) GetSLIStats() *worker.SLIStats {
+	return grw.config.workerCfg.SLIs
+}
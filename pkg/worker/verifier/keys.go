@@ -0,0 +1,75 @@
+package verifier
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// Record keys carry a magic prefix and version, so that data written by
+// other versions of the verifier can be recognized on a long-lived topic.
+// Keys written before versioning have no prefix: "<producer>.<offset>".
+const (
+	keyMagic          = "kgov"
+	currentKeyVersion = 1
+
+	// Unprefixed keys, from versions before keys were versioned
+	LegacyKeyVersion = 0
+
+	// Keys that are not ours at all
+	ForeignKeyVersion = -1
+)
+
+// What to do with records keyed in the legacy format
+type LegacyKeyPolicy string
+
+const (
+	// Check the key against the offset as older versions did
+	LegacyKeysValidate LegacyKeyPolicy = "validate"
+
+	// Don't validate, just count them as foreign
+	LegacyKeysSkip LegacyKeyPolicy = "skip"
+)
+
+// What to do with records whose keys are not in any of our formats, e.g.
+// on a topic that other applications also write to.  Foreign records at
+// offsets our producer wrote are always invalid.
+type ForeignRecordPolicy string
+
+const (
+	// Treat them like any other record at an offset we didn't write
+	ForeignRecordsIgnore ForeignRecordPolicy = "ignore"
+
+	// Count them separately, rather than as out of scope invalid reads
+	ForeignRecordsCount ForeignRecordPolicy = "count"
+
+	// Fail validation: the topic should only contain our data
+	ForeignRecordsFail ForeignRecordPolicy = "fail"
+)
+
+var legacyKeyRegex = regexp.MustCompile(`^\d{6}\.\d{18}$`)
+var versionedKeyRegex = regexp.MustCompile(`^` + keyMagic + `(\d+)\.`)
+
+func makeKey(producerId int, sequence int64) []byte {
+	var key bytes.Buffer
+	fmt.Fprintf(&key, "%s%d.%06d.%018d", keyMagic, currentKeyVersion, producerId, sequence)
+	return key.Bytes()
+}
+
+func legacyKey(offset int64) string {
+	return fmt.Sprintf("%06d.%018d", 0, offset)
+}
+
+// Which version of the verifier's key format a key is in
+func ParseKeyVersion(key []byte) int {
+	if m := versionedKeyRegex.FindSubmatch(key); m != nil {
+		v, err := strconv.Atoi(string(m[1]))
+		if err == nil {
+			return v
+		}
+	} else if legacyKeyRegex.Match(key) {
+		return LegacyKeyVersion
+	}
+	return ForeignKeyVersion
+}
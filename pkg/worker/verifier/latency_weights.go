@@ -0,0 +1,161 @@
+package verifier
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// How often partition weights are adjusted
+const latencyWeightInterval = time.Second
+
+// Weight of each new ack in its partition's moving average latency
+const latencyWeightAlpha = 0.05
+
+// Slow partitions keep a little traffic, so that we notice when they recover
+const latencyWeightMin = 1.0 / 16
+
+// How many recent shifts the status keeps
+const latencyWeightMaxShifts = 100
+
+// A change in a partition's share of traffic
+type LatencyShift struct {
+	Time      time.Time `json:"time"`
+	Partition int32     `json:"partition"`
+	LatencyMs float64   `json:"latency_ms"`
+	Weight    float64   `json:"weight"`
+}
+
+type LatencyWeightStatus struct {
+	// Each partition's current weight, 1 for full share
+	Weights []float64 `json:"weights"`
+
+	// Times a partition's weight was cut for slow acks, and raised again
+	// once they were fast, and the most recent of those shifts
+	ShiftsAway int64          `json:"shifts_away"`
+	ShiftsBack int64          `json:"shifts_back"`
+	Shifts     []LatencyShift `json:"shifts"`
+}
+
+// Shifts produce traffic away from partitions whose acks are slow, the way
+// adaptive clients do: a partition's weight halves each interval that its
+// moving average ack latency is over the threshold, and doubles back towards
+// full each interval that it isn't.
+type LatencyWeights struct {
+	lock      sync.Mutex
+	threshold time.Duration
+
+	// Per partition moving average ack latency in microseconds, once we
+	// have an ack
+	latency []float64
+	seen    []bool
+	weights []float64
+	all     []int32
+
+	status     LatencyWeightStatus
+	adjustedAt time.Time
+}
+
+func NewLatencyWeights(nPartitions int32, threshold time.Duration) *LatencyWeights {
+	lw := LatencyWeights{
+		threshold:  threshold,
+		latency:    make([]float64, nPartitions),
+		seen:       make([]bool, nPartitions),
+		weights:    make([]float64, nPartitions),
+		adjustedAt: time.Now(),
+	}
+	for i := range lw.weights {
+		lw.weights[i] = 1
+		lw.all = append(lw.all, int32(i))
+	}
+	return &lw
+}
+
+func (lw *LatencyWeights) OnAck(p int32, latency time.Duration) {
+	lw.lock.Lock()
+	defer lw.lock.Unlock()
+	us := float64(latency.Microseconds())
+	if !lw.seen[p] {
+		lw.latency[p] = us
+		lw.seen[p] = true
+	} else {
+		lw.latency[p] += latencyWeightAlpha * (us - lw.latency[p])
+	}
+	if time.Since(lw.adjustedAt) > latencyWeightInterval {
+		lw.adjust()
+		lw.adjustedAt = time.Now()
+	}
+}
+
+func (lw *LatencyWeights) adjust() {
+	thresholdUs := float64(lw.threshold.Microseconds())
+	for i, l := range lw.latency {
+		p := int32(i)
+		if !lw.seen[p] {
+			continue
+		}
+		w := lw.weights[p]
+		if l > thresholdUs && w > latencyWeightMin {
+			w /= 2
+			if w < latencyWeightMin {
+				w = latencyWeightMin
+			}
+			lw.status.ShiftsAway += 1
+			log.Infof("Shifting traffic away from partition %d: ack latency %.0fms, weight %g", p, l/1000, w)
+		} else if l <= thresholdUs && w < 1 {
+			w *= 2
+			if w > 1 {
+				w = 1
+			}
+			lw.status.ShiftsBack += 1
+			log.Infof("Shifting traffic back to partition %d: ack latency %.0fms, weight %g", p, l/1000, w)
+		} else {
+			continue
+		}
+		lw.weights[p] = w
+		lw.status.Shifts = append(lw.status.Shifts, LatencyShift{Time: time.Now(), Partition: p, LatencyMs: l / 1000, Weight: w})
+		if len(lw.status.Shifts) > latencyWeightMaxShifts {
+			lw.status.Shifts = lw.status.Shifts[1:]
+		}
+	}
+}
+
+// A partition from the candidates (all partitions if nil), in proportion
+// to their weights
+func (lw *LatencyWeights) Pick(rng *rand.Rand, candidates []int32) int32 {
+	lw.lock.Lock()
+	defer lw.lock.Unlock()
+	if candidates == nil {
+		candidates = lw.all
+	}
+
+	total := 0.0
+	for _, p := range candidates {
+		total += lw.weights[p]
+	}
+	x := rng.Float64() * total
+	for _, p := range candidates {
+		if x < lw.weights[p] {
+			return p
+		}
+		x -= lw.weights[p]
+	}
+	return candidates[len(candidates)-1]
+}
+
+func (lw *LatencyWeights) ResetStats() {
+	lw.lock.Lock()
+	defer lw.lock.Unlock()
+	lw.status = LatencyWeightStatus{}
+}
+
+func (lw *LatencyWeights) Status() LatencyWeightStatus {
+	lw.lock.Lock()
+	defer lw.lock.Unlock()
+	status := lw.status
+	status.Weights = append([]float64(nil), lw.weights...)
+	status.Shifts = append([]LatencyShift(nil), lw.status.Shifts...)
+	return status
+}
@@ -0,0 +1,178 @@
+package verifier
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// How produced message sizes vary, for traffic that resembles real
+// workloads rather than records all of one size.  One of:
+//
+//   - uniform:MIN:MAX, any size from MIN to MAX inclusive
+//   - lognormal:MEAN:STDDEV[:MAX], sizes with this mean and standard
+//     deviation, up to MAX if given
+//   - weighted:SIZE=WEIGHT,..., each size in proportion to its weight
+type SizeDistribution struct {
+	kind string
+	spec string
+
+	// uniform, and lognormal's cap (0 for none)
+	min int
+	max int
+
+	// lognormal, of the sizes' logarithm
+	mu    float64
+	sigma float64
+	mean  float64
+
+	// weighted
+	sizes   []int
+	weights []float64
+	total   float64
+}
+
+func ParseSizeDistribution(s string) (*SizeDistribution, error) {
+	parts := strings.Split(s, ":")
+	bad := fmt.Errorf("bad size distribution '%s': use uniform:MIN:MAX, lognormal:MEAN:STDDEV[:MAX] or weighted:SIZE=WEIGHT,...", s)
+	switch parts[0] {
+	case "uniform":
+		if len(parts) != 3 {
+			return nil, bad
+		}
+		min, err1 := strconv.Atoi(parts[1])
+		max, err2 := strconv.Atoi(parts[2])
+		if err1 != nil || err2 != nil || min < 0 || max < min {
+			return nil, bad
+		}
+		return &SizeDistribution{kind: "uniform", spec: s, min: min, max: max}, nil
+	case "lognormal":
+		if len(parts) != 3 && len(parts) != 4 {
+			return nil, bad
+		}
+		mean, err1 := strconv.ParseFloat(parts[1], 64)
+		stddev, err2 := strconv.ParseFloat(parts[2], 64)
+		if err1 != nil || err2 != nil || mean <= 0 || stddev < 0 {
+			return nil, bad
+		}
+		max := 0
+		if len(parts) == 4 {
+			var err error
+			if max, err = strconv.Atoi(parts[3]); err != nil || max <= 0 {
+				return nil, bad
+			}
+		}
+		// Parameters of the underlying normal distribution that give
+		// sizes this mean and variance
+		v := stddev * stddev
+		return &SizeDistribution{
+			kind:  "lognormal",
+			spec:  s,
+			max:   max,
+			mu:    math.Log(mean * mean / math.Sqrt(v+mean*mean)),
+			sigma: math.Sqrt(math.Log(1 + v/(mean*mean))),
+			mean:  mean,
+		}, nil
+	case "weighted":
+		if len(parts) != 2 {
+			return nil, bad
+		}
+		sd := &SizeDistribution{kind: "weighted", spec: s}
+		for _, entry := range strings.Split(parts[1], ",") {
+			kv := strings.Split(entry, "=")
+			if len(kv) != 2 {
+				return nil, bad
+			}
+			size, err1 := strconv.Atoi(kv[0])
+			weight, err2 := strconv.ParseFloat(kv[1], 64)
+			if err1 != nil || err2 != nil || size < 0 || weight < 0 {
+				return nil, bad
+			}
+			sd.sizes = append(sd.sizes, size)
+			sd.weights = append(sd.weights, weight)
+			sd.total += weight
+		}
+		if sd.total <= 0 {
+			return nil, bad
+		}
+		return sd, nil
+	default:
+		return nil, bad
+	}
+}
+
+func (sd *SizeDistribution) Sample(rng *rand.Rand) int {
+	switch sd.kind {
+	case "uniform":
+		return sd.min + rng.Intn(sd.max-sd.min+1)
+	case "lognormal":
+		size := int(math.Round(math.Exp(sd.mu + sd.sigma*rng.NormFloat64())))
+		if sd.max > 0 && size > sd.max {
+			size = sd.max
+		}
+		return size
+	default:
+		x := rng.Float64() * sd.total
+		for i, w := range sd.weights {
+			if x < w {
+				return sd.sizes[i]
+			}
+			x -= w
+		}
+		return sd.sizes[len(sd.sizes)-1]
+	}
+}
+
+func (sd *SizeDistribution) String() string {
+	return sd.spec
+}
+
+// The mean size, for sizing a produce run in bytes.  Ignores lognormal's
+// cap, which only trims the rare outlier.
+func (sd *SizeDistribution) Mean() int {
+	switch sd.kind {
+	case "uniform":
+		return (sd.min + sd.max) / 2
+	case "lognormal":
+		return int(sd.mean)
+	default:
+		sum := 0.0
+		for i, w := range sd.weights {
+			sum += float64(sd.sizes[i]) * w
+		}
+		return int(sum / sd.total)
+	}
+}
+
+// Records of up to this many bytes, and how many there were
+type SizeBucket struct {
+	UpTo  int   `json:"up_to"`
+	Count int64 `json:"count"`
+}
+
+// Counts of produced message sizes in power of two buckets
+type SizeHistogram struct {
+	counts [64]int64
+}
+
+func (sh *SizeHistogram) Observe(size int) {
+	b := 0
+	if size > 1 {
+		b = bits.Len(uint(size - 1))
+	}
+	sh.counts[b] += 1
+}
+
+// The buckets with anything in them, smallest first
+func (sh *SizeHistogram) Buckets() []SizeBucket {
+	buckets := []SizeBucket{}
+	for b, n := range sh.counts {
+		if n > 0 {
+			buckets = append(buckets, SizeBucket{UpTo: 1 << b, Count: n})
+		}
+	}
+	return buckets
+}
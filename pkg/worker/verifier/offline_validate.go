@@ -0,0 +1,165 @@
+package verifier
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	worker "github.com/redpanda-data/kgo-verifier/pkg/worker"
+	log "github.com/sirupsen/logrus"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Offline validation: check records salvaged from a cluster against the
+// producer's valid offsets, without talking to the cluster.
+
+type DumpFormat string
+
+const (
+	// A Kafka log segment: v2 record batches back to back, as found
+	// on disk.  Segments don't say which partition they belong to.
+	DumpSegment DumpFormat = "segment"
+
+	// One JSON object per line, as written by kcat -C -J
+	DumpKcatJson DumpFormat = "kcat-json"
+)
+
+type OfflineValidateConfig struct {
+	workerCfg worker.WorkerConfig
+	path      string
+	format    DumpFormat
+	partition int32
+}
+
+func NewOfflineValidateConfig(wc worker.WorkerConfig, path string, format DumpFormat, partition int32) OfflineValidateConfig {
+	return OfflineValidateConfig{
+		workerCfg: wc,
+		path:      path,
+		format:    format,
+		partition: partition,
+	}
+}
+
+type OfflineValidateStatus struct {
+	Validator ValidatorStatus `json:"validator"`
+
+	// Segment dumps only: batches read, and how many failed their CRC
+	Batches       int64 `json:"batches"`
+	BadCrcBatches int64 `json:"bad_crc_batches"`
+}
+
+// A line of kcat -J output.  kcat writes headers as a flat array of
+// alternating names and values.
+type kcatRecord struct {
+	Topic     string    `json:"topic"`
+	Partition int32     `json:"partition"`
+	Offset    int64     `json:"offset"`
+	Key       *string   `json:"key"`
+	Headers   []*string `json:"headers"`
+}
+
+func ValidateDump(cfg OfflineValidateConfig) (*OfflineValidateStatus, error) {
+	status := &OfflineValidateStatus{Validator: NewValidatorStatus()}
+	status.Validator.Name = cfg.path
+	status.Validator.EnableSequenceChecks()
+	validRanges := LoadValidRanges(cfg.workerCfg, -1)
+
+	validate := func(r *kgo.Record) {
+		validRanges.ensurePartition(r.Partition)
+		status.Validator.ValidateRecord(r, &validRanges)
+	}
+
+	var err error
+	switch cfg.format {
+	case DumpSegment:
+		err = validateSegment(cfg, status, validate)
+	case DumpKcatJson:
+		err = validateKcatJson(cfg, validate)
+	default:
+		err = fmt.Errorf("unknown dump format '%s'", cfg.format)
+	}
+	return status, err
+}
+
+func validateSegment(cfg OfflineValidateConfig, status *OfflineValidateStatus, validate func(*kgo.Record)) error {
+	data, err := ioutil.ReadFile(cfg.path)
+	if err != nil {
+		return err
+	}
+
+	batches, err := DecodeRecordBatches(data)
+	if err != nil {
+		return err
+	}
+
+	for _, b := range batches {
+		status.Batches += 1
+		if !b.CrcOk {
+			status.BadCrcBatches += 1
+			log.Errorf("Bad CRC on batch at offset %d in %s", b.Header.FirstOffset, cfg.path)
+		}
+		if b.IsControl() {
+			continue
+		}
+		for _, rr := range b.Records {
+			r := &kgo.Record{
+				Topic:     cfg.workerCfg.Topic,
+				Partition: cfg.partition,
+				Offset:    b.Header.FirstOffset + int64(rr.OffsetDelta),
+				Key:       rr.Key,
+			}
+			for _, h := range rr.Headers {
+				r.Headers = append(r.Headers, kgo.RecordHeader{Key: h.Key, Value: h.Value})
+			}
+			validate(r)
+		}
+	}
+	return nil
+}
+
+func validateKcatJson(cfg OfflineValidateConfig, validate func(*kgo.Record)) error {
+	f, err := os.Open(cfg.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var kr kcatRecord
+		err := json.Unmarshal(scanner.Bytes(), &kr)
+		if err != nil {
+			return fmt.Errorf("bad kcat JSON: %v", err)
+		}
+		if kr.Topic != "" && kr.Topic != cfg.workerCfg.Topic {
+			continue
+		}
+
+		r := &kgo.Record{
+			Topic:     cfg.workerCfg.Topic,
+			Partition: kr.Partition,
+			Offset:    kr.Offset,
+		}
+		if kr.Key != nil {
+			r.Key = []byte(*kr.Key)
+		}
+		for i := 0; i+1 < len(kr.Headers); i += 2 {
+			h := kgo.RecordHeader{}
+			if kr.Headers[i] != nil {
+				h.Key = *kr.Headers[i]
+			}
+			if kr.Headers[i+1] != nil {
+				h.Value = []byte(*kr.Headers[i+1])
+			}
+			r.Headers = append(r.Headers, h)
+		}
+		validate(r)
+	}
+	return scanner.Err()
+}
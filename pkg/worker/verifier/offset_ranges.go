@@ -4,34 +4,102 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"os"
 
 	"github.com/redpanda-data/kgo-verifier/pkg/util"
+	worker "github.com/redpanda-data/kgo-verifier/pkg/worker"
 	log "github.com/sirupsen/logrus"
+	"github.com/twmb/franz-go/pkg/kgo"
 )
 
-func LoadTopicOffsetRanges(topic string, nPartitions int32) TopicOffsetRanges {
-	data, err := ioutil.ReadFile(topicOffsetRangeFile(topic))
+// If nPartitions is negative, the partition count is taken from what the
+// producer stored, for when we can't ask the cluster (e.g. validating offline)
+func LoadTopicOffsetRanges(topic string, runId string, nPartitions int32) TopicOffsetRanges {
+	data, err := ioutil.ReadFile(topicOffsetRangeFile(topic, runId))
 	if err != nil {
 		// Pass, assume it's not existing yet
-		return NewTopicOffsetRanges(topic, nPartitions)
-	} else {
-		var tors TopicOffsetRanges
-		if len(data) > 0 {
-			err = json.Unmarshal(data, &tors)
-			util.Chk(err, "Bad JSON %v", err)
+		if nPartitions < 0 {
+			nPartitions = 0
 		}
+		return NewTopicOffsetRanges(topic, runId, nPartitions)
+	} else {
+		return parseTopicOffsetRanges(data, topic, runId, nPartitions)
+	}
+}
+
+// Fetch valid offsets from a producer's remote control port, for readers
+// that don't share a filesystem with the producer.
+func FetchTopicOffsetRanges(url string, topic string, runId string, nPartitions int32) TopicOffsetRanges {
+	log.Infof("Fetching valid offsets from %s...", url)
+	resp, err := http.Get(url)
+	util.Chk(err, "Error fetching valid offsets: %v", err)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		util.Die("Error fetching valid offsets from %s: %s", url, resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	util.Chk(err, "Error fetching valid offsets: %v", err)
+	return parseTopicOffsetRanges(data, topic, runId, nPartitions)
+}
 
-		if int32(len(tors.PartitionRanges)) > nPartitions {
-			util.Die("More partitions in valid_offsets file than in topic!")
-		} else if len(tors.PartitionRanges) < int(nPartitions) {
-			// Creating new partitions is allowed
-			blanks := make([]OffsetRanges, nPartitions-int32(len(tors.PartitionRanges)))
-			tors.PartitionRanges = append(tors.PartitionRanges, blanks...)
+// Readers' expectations, from wherever the config says to find them
+func LoadValidRanges(wc worker.WorkerConfig, nPartitions int32) TopicOffsetRanges {
+	var tors TopicOffsetRanges
+	if wc.ExpectationsFile != "" {
+		if wc.RunId != "" {
+			util.DieConfig("Run IDs can't be used with an expectations manifest")
 		}
+		tors = LoadExpectationsManifest(wc.ExpectationsFile, wc.Topic, nPartitions)
+	} else if wc.OffsetsUrl != "" {
+		tors = FetchTopicOffsetRanges(wc.OffsetsUrl, wc.Topic, wc.RunId, nPartitions)
+	} else {
+		tors = LoadTopicOffsetRanges(wc.Topic, wc.RunId, nPartitions)
+		tors.ackEvidence = LoadAckEvidence(wc.Topic, wc.RunId)
+	}
+
+	switch policy := LegacyKeyPolicy(wc.LegacyKeys); policy {
+	case "", LegacyKeysValidate:
+		tors.legacyKeys = LegacyKeysValidate
+	case LegacyKeysSkip:
+		tors.legacyKeys = policy
+	default:
+		util.DieConfig("Unknown legacy key policy '%s'", wc.LegacyKeys)
+	}
+
+	switch policy := ForeignRecordPolicy(wc.ForeignRecords); policy {
+	case "", ForeignRecordsIgnore:
+		tors.foreignRecords = ForeignRecordsIgnore
+	case ForeignRecordsCount, ForeignRecordsFail:
+		tors.foreignRecords = policy
+	default:
+		util.DieConfig("Unknown foreign record policy '%s'", wc.ForeignRecords)
+	}
+	return tors
+}
 
+func parseTopicOffsetRanges(data []byte, topic string, runId string, nPartitions int32) TopicOffsetRanges {
+	var tors TopicOffsetRanges
+	if len(data) > 0 {
+		err := json.Unmarshal(data, &tors)
+		util.Chk(err, "Bad JSON %v", err)
+	}
+	if tors.RunId != runId {
+		util.DieConfig("valid_offsets are for run '%s', not '%s'", tors.RunId, runId)
+	}
+	tors.topic = topic
+
+	if nPartitions < 0 {
 		return tors
+	} else if int32(len(tors.PartitionRanges)) > nPartitions {
+		util.DieConfig("More partitions in valid_offsets file than in topic!")
+	} else if len(tors.PartitionRanges) < int(nPartitions) {
+		// Creating new partitions is allowed
+		blanks := make([]OffsetRanges, nPartitions-int32(len(tors.PartitionRanges)))
+		tors.PartitionRanges = append(tors.PartitionRanges, blanks...)
 	}
+
+	return tors
 }
 
 type OffsetRange struct {
@@ -78,23 +146,55 @@ func (ors *OffsetRanges) Contains(o int64) bool {
 
 type TopicOffsetRanges struct {
 	topic           string
+	RunId           string `json:",omitempty"`
 	PartitionRanges []OffsetRanges
+
+	// Set if records are keyed other than as our producer does it
+	keyPattern *KeyPattern
+
+	// How to treat records keyed by older versions of our producer,
+	// and by things other than our producer
+	legacyKeys     LegacyKeyPolicy
+	foreignRecords ForeignRecordPolicy
+
+	// The producer's ack evidence, if we have it, for reporting lost data
+	ackEvidence *AckEvidence
+}
+
+// The key we expect a valid record at this offset to have
+func (tors *TopicOffsetRanges) ExpectedKey(r *kgo.Record) string {
+	if tors.keyPattern != nil {
+		return tors.keyPattern.Key(r)
+	}
+	return string(makeKey(0, r.Offset))
 }
 
 func (tors *TopicOffsetRanges) Insert(p int32, o int64) {
 	tors.PartitionRanges[p].Insert(o)
 }
 
+// Make room for a partition that the producer stored nothing for
+func (tors *TopicOffsetRanges) ensurePartition(p int32) {
+	for int32(len(tors.PartitionRanges)) <= p {
+		tors.PartitionRanges = append(tors.PartitionRanges, OffsetRanges{})
+	}
+}
+
 func (tors *TopicOffsetRanges) Contains(p int32, o int64) bool {
 	return tors.PartitionRanges[p].Contains(o)
 }
 
-func topicOffsetRangeFile(topic string) string {
+// Each run on a shared topic keeps its own file, so that runs can be
+// validated independently of one another
+func topicOffsetRangeFile(topic string, runId string) string {
+	if runId != "" {
+		return fmt.Sprintf("valid_offsets_%s_%s.json", topic, runId)
+	}
 	return fmt.Sprintf("valid_offsets_%s.json", topic)
 }
 
 func (tors *TopicOffsetRanges) Store() error {
-	log.Infof("TopicOffsetRanges::Storing %s...", topicOffsetRangeFile(tors.topic))
+	log.Infof("TopicOffsetRanges::Storing %s...", topicOffsetRangeFile(tors.topic, tors.RunId))
 	data, err := json.Marshal(tors)
 	if err != nil {
 		return err
@@ -110,7 +210,7 @@ func (tors *TopicOffsetRanges) Store() error {
 		return err
 	}
 
-	err = os.Rename(tmp_file.Name(), topicOffsetRangeFile(tors.topic))
+	err = os.Rename(tmp_file.Name(), topicOffsetRangeFile(tors.topic, tors.RunId))
 	if err != nil {
 		return err
 	}
@@ -122,13 +222,14 @@ func (tors *TopicOffsetRanges) Store() error {
 	return nil
 }
 
-func NewTopicOffsetRanges(topic string, nPartitions int32) TopicOffsetRanges {
+func NewTopicOffsetRanges(topic string, runId string, nPartitions int32) TopicOffsetRanges {
 	prs := make([]OffsetRanges, nPartitions)
 	for _, or := range prs {
 		or.Ranges = make([]OffsetRange, 0)
 	}
 	return TopicOffsetRanges{
 		topic:           topic,
+		RunId:           runId,
 		PartitionRanges: prs,
 	}
 }
@@ -0,0 +1,95 @@
+package verifier
+
+import (
+	"sort"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Partitions that have been paused via the HTTP API.  Readers register their
+// clients here while they are live, so that a pause takes effect immediately
+// and also survives the reader rebuilding its client after errors.
+type PausedPartitions struct {
+	lock    sync.Mutex
+	topic   string
+	paused  map[int32]bool
+	clients map[*kgo.Client]bool
+}
+
+func NewPausedPartitions(topic string) PausedPartitions {
+	return PausedPartitions{
+		topic:   topic,
+		paused:  make(map[int32]bool),
+		clients: make(map[*kgo.Client]bool),
+	}
+}
+
+func (pp *PausedPartitions) Register(client *kgo.Client) {
+	pp.lock.Lock()
+	defer pp.lock.Unlock()
+	pp.clients[client] = true
+	if len(pp.paused) > 0 {
+		client.PauseFetchPartitions(map[string][]int32{pp.topic: pp.list()})
+	}
+}
+
+func (pp *PausedPartitions) Unregister(client *kgo.Client) {
+	pp.lock.Lock()
+	defer pp.lock.Unlock()
+	delete(pp.clients, client)
+}
+
+// Clients currently registered, for operations that need to act on
+// all of a reader's clients at once.
+func (pp *PausedPartitions) Clients() []*kgo.Client {
+	pp.lock.Lock()
+	defer pp.lock.Unlock()
+	r := make([]*kgo.Client, 0, len(pp.clients))
+	for c := range pp.clients {
+		r = append(r, c)
+	}
+	return r
+}
+
+func (pp *PausedPartitions) Pause(partitions []int32) []int32 {
+	pp.lock.Lock()
+	defer pp.lock.Unlock()
+	for _, p := range partitions {
+		pp.paused[p] = true
+	}
+	for c := range pp.clients {
+		c.PauseFetchPartitions(map[string][]int32{pp.topic: partitions})
+	}
+	log.Infof("Paused partitions %v, now paused: %v", partitions, pp.list())
+	return pp.list()
+}
+
+func (pp *PausedPartitions) Resume(partitions []int32) []int32 {
+	pp.lock.Lock()
+	defer pp.lock.Unlock()
+	for _, p := range partitions {
+		delete(pp.paused, p)
+	}
+	for c := range pp.clients {
+		c.ResumeFetchPartitions(map[string][]int32{pp.topic: partitions})
+	}
+	log.Infof("Resumed partitions %v, now paused: %v", partitions, pp.list())
+	return pp.list()
+}
+
+func (pp *PausedPartitions) List() []int32 {
+	pp.lock.Lock()
+	defer pp.lock.Unlock()
+	return pp.list()
+}
+
+func (pp *PausedPartitions) list() []int32 {
+	r := make([]int32, 0, len(pp.paused))
+	for p := range pp.paused {
+		r = append(r, p)
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i] < r[j] })
+	return r
+}
@@ -0,0 +1,70 @@
+package verifier
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// What to put in record values, so that topics we write can also be read
+// by other tools' consumers in mixed-tool environments.  Keys are always
+// our own format, so that our readers can still validate.
+type PayloadFormat string
+
+const (
+	// All zeros, -msg_size bytes
+	PayloadZeros PayloadFormat = "zeros"
+
+	// As the franz-go bench tool writes: the record's number followed
+	// by a space, repeated to fill -msg_size bytes
+	PayloadFranzBench PayloadFormat = "franz-bench"
+
+	// As Kafka's VerifiableProducer writes: the record's number in
+	// decimal, regardless of -msg_size
+	PayloadVerifiableProducer PayloadFormat = "verifiable-producer"
+)
+
+func ParsePayloadFormat(s string) (PayloadFormat, error) {
+	switch f := PayloadFormat(s); f {
+	case PayloadZeros, PayloadFranzBench, PayloadVerifiableProducer:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown payload format '%s'", s)
+	}
+}
+
+// The record's number is its offset, so that a reader of the payload
+// can check it against where the record landed.
+func makePayload(format PayloadFormat, size int, num int64) []byte {
+	switch format {
+	case PayloadFranzBench:
+		v := make([]byte, size)
+		var buf [20]byte
+		b := strconv.AppendInt(buf[:0], num, 10)
+		b = append(b, ' ')
+		for n := 0; n < len(v); {
+			n += copy(v[n:], b)
+		}
+		return v
+	case PayloadVerifiableProducer:
+		return []byte(strconv.FormatInt(num, 10))
+	default:
+		return make([]byte, size)
+	}
+}
+
+// Roughly how many bytes (key and value) each record carries, for sizing a
+// produce run in bytes.  Verifiable producer values are just the record's
+// number, so we assume a typical width.
+func recordBytes(format PayloadFormat, size int) int64 {
+	keyBytes := int64(len(makeKey(0, 0)))
+	if format == PayloadVerifiableProducer {
+		return keyBytes + 10
+	}
+	return keyBytes + int64(size)
+}
+
+// How many records to produce for about totalBytes of data
+func MessagesForBytes(totalBytes int64, format PayloadFormat, size int) int {
+	per := recordBytes(format, size)
+	return int((totalBytes + per - 1) / per)
+}
@@ -0,0 +1,98 @@
+package verifier
+
+import (
+	"context"
+	"sort"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// Flag placement where the busiest broker led this many times its share
+const placementSkewThreshold = 1.5
+
+// What we produced to the partitions a broker led at the end of the run
+type BrokerPlacement struct {
+	Partitions []int32 `json:"partitions"`
+	Records    int64   `json:"records"`
+	Bytes      int64   `json:"bytes"`
+}
+
+// How the data we produced is spread across brokers by leadership, for
+// checking partition balancing.  Records on partitions without a known
+// leader are under broker -1 and don't count towards the skew.
+type PlacementReport struct {
+	Brokers map[int32]*BrokerPlacement `json:"brokers"`
+
+	// The busiest broker's records over the mean per broker
+	Skew       float64 `json:"skew"`
+	Imbalanced bool    `json:"imbalanced"`
+}
+
+// Every broker in the cluster, so that those leading nothing count too
+func GetBrokers(client *kgo.Client) ([]int32, error) {
+	req := kmsg.NewPtrMetadataRequest()
+	resp, err := req.RequestWith(context.Background(), client)
+	if err != nil {
+		return nil, err
+	}
+	var brokers []int32
+	for _, b := range resp.Brokers {
+		brokers = append(brokers, b.NodeID)
+	}
+	sort.Slice(brokers, func(i, j int) bool { return brokers[i] < brokers[j] })
+	return brokers, nil
+}
+
+// Each broker's rack, empty for brokers that don't say
+func GetBrokerRacks(client *kgo.Client) (map[int32]string, error) {
+	req := kmsg.NewPtrMetadataRequest()
+	resp, err := req.RequestWith(context.Background(), client)
+	if err != nil {
+		return nil, err
+	}
+	racks := make(map[int32]string)
+	for _, b := range resp.Brokers {
+		rack := ""
+		if b.Rack != nil {
+			rack = *b.Rack
+		}
+		racks[b.NodeID] = rack
+	}
+	return racks, nil
+}
+
+func NewPlacementReport(brokers []int32, leaders []PartitionLeader, records []int64, bytes []int64) PlacementReport {
+	pr := PlacementReport{Brokers: make(map[int32]*BrokerPlacement)}
+	for _, b := range brokers {
+		pr.Brokers[b] = &BrokerPlacement{Partitions: []int32{}}
+	}
+	for p, l := range leaders {
+		b, ok := pr.Brokers[l.Leader]
+		if !ok {
+			b = &BrokerPlacement{Partitions: []int32{}}
+			pr.Brokers[l.Leader] = b
+		}
+		b.Partitions = append(b.Partitions, int32(p))
+		b.Records += records[p]
+		b.Bytes += bytes[p]
+	}
+
+	var total, max int64
+	var n int
+	for id, b := range pr.Brokers {
+		if id < 0 {
+			continue
+		}
+		n += 1
+		total += b.Records
+		if b.Records > max {
+			max = b.Records
+		}
+	}
+	if n > 0 && total > 0 {
+		pr.Skew = float64(max) / (float64(total) / float64(n))
+		pr.Imbalanced = pr.Skew > placementSkewThreshold
+	}
+	return pr
+}
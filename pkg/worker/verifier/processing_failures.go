@@ -0,0 +1,138 @@
+package verifier
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/redpanda-data/kgo-verifier/pkg/util"
+	log "github.com/sirupsen/logrus"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+type ProcessingFailureStatus struct {
+	// Records we pretended to fail, and deliveries of failed records
+	// after seeking back to them: by the end of a pass these match
+	Failures int64 `json:"failures"`
+	Retries  int64 `json:"retries"`
+
+	// Records delivered again that we had already processed, and
+	// records after a failure that came before the retry: both skipped
+	Redelivered int64 `json:"redelivered"`
+	Deferred    int64 `json:"deferred"`
+}
+
+// Pretends to fail processing a fraction of records, the way an application
+// might on a transient error, and retries them by pausing the partition and
+// seeking back to the failed record.  Tracks the next offset to process on
+// each partition, so that each record is processed exactly once however
+// often it is delivered.  Sharded readers each have their own, sharing
+// the status.
+type ProcessingFailures struct {
+	topic    string
+	fraction float64
+	backoff  time.Duration
+	status   *ProcessingFailureStatus
+
+	// Partition -> next offset to process, and failed offset awaiting
+	// retry along with when to resume fetching it
+	next    map[int32]int64
+	pending map[int32]int64
+	retryAt map[int32]time.Time
+
+	// This pass's failures and retries, apart from any status resets
+	failures int64
+	retries  int64
+}
+
+func NewProcessingFailures(topic string, fraction float64, backoff time.Duration, status *ProcessingFailureStatus) *ProcessingFailures {
+	return &ProcessingFailures{
+		topic:    topic,
+		fraction: fraction,
+		backoff:  backoff,
+		status:   status,
+		next:     make(map[int32]int64),
+		pending:  make(map[int32]int64),
+		retryAt:  make(map[int32]time.Time),
+	}
+}
+
+// Whether to process a record: false if it's a re-delivery, if it is
+// waiting behind a failure, or if we fail it now.
+func (pf *ProcessingFailures) Process(client *kgo.Client, r *kgo.Record) bool {
+	if next, ok := pf.next[r.Partition]; ok && r.Offset < next {
+		atomic.AddInt64(&pf.status.Redelivered, 1)
+		return false
+	}
+	if failed, ok := pf.pending[r.Partition]; ok {
+		if r.Offset != failed {
+			atomic.AddInt64(&pf.status.Deferred, 1)
+			return false
+		}
+		atomic.AddInt64(&pf.status.Retries, 1)
+		pf.retries += 1
+		delete(pf.pending, r.Partition)
+	}
+
+	if rand.Float64() < pf.fraction {
+		log.Debugf("Failing processing of %s/%d o=%d, retrying in %v", pf.topic, r.Partition, r.Offset, pf.backoff)
+		atomic.AddInt64(&pf.status.Failures, 1)
+		pf.failures += 1
+		pf.pending[r.Partition] = r.Offset
+		pf.retryAt[r.Partition] = time.Now().Add(pf.backoff)
+		tps := map[string][]int32{pf.topic: {r.Partition}}
+		client.PauseFetchPartitions(tps)
+		client.SetOffsets(map[string]map[int32]kgo.EpochOffset{
+			pf.topic: {r.Partition: {Epoch: -1, Offset: r.Offset}},
+		})
+		return false
+	}
+
+	pf.next[r.Partition] = r.Offset + 1
+	return true
+}
+
+// How long until the next partition is due to be resumed, if any is waiting
+func (pf *ProcessingFailures) NextRetry() (time.Duration, bool) {
+	var soonest time.Duration
+	found := false
+	for _, at := range pf.retryAt {
+		if d := time.Until(at); !found || d < soonest {
+			soonest = d
+			found = true
+		}
+	}
+	if soonest < 0 {
+		soonest = 0
+	}
+	return soonest, found
+}
+
+// Resume fetching partitions whose backoff has passed, leaving alone those
+// paused from outside
+func (pf *ProcessingFailures) ResumeDue(client *kgo.Client, paused *PausedPartitions) {
+	external := make(map[int32]bool)
+	for _, p := range paused.List() {
+		external[p] = true
+	}
+	var due []int32
+	for p, at := range pf.retryAt {
+		if time.Now().After(at) {
+			delete(pf.retryAt, p)
+			if !external[p] {
+				due = append(due, p)
+			}
+		}
+	}
+	if len(due) > 0 {
+		client.ResumeFetchPartitions(map[string][]int32{pf.topic: due})
+	}
+}
+
+// Every failed record must have been retried by the end of a pass
+func (pf *ProcessingFailures) Check() {
+	if len(pf.pending) > 0 || pf.retries != pf.failures {
+		util.DieValidation("Records failed in processing were not all retried: %d failures, %d retries, still pending %v",
+			pf.failures, pf.retries, pf.pending)
+	}
+}
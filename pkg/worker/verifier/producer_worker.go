@@ -1,12 +1,12 @@
 package verifier
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"math/rand"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rcrowley/go-metrics"
@@ -17,6 +17,9 @@ import (
 	"golang.org/x/sync/semaphore"
 )
 
+// Header carrying the run ID, when records are stamped with one
+const RunIdHeader = "kgo-verifier-run-id"
+
 type ProducerConfig struct {
 	workerCfg       worker.WorkerConfig
 	name            string
@@ -24,17 +27,32 @@ type ProducerConfig struct {
 	messageSize     int
 	messageCount    int
 	fakeTimestampMs int64
+	payloadFormat   PayloadFormat
+
+	// If hotPartition is set (>= 0), this fraction of messages go to
+	// it, and the rest are spread over all partitions
+	hotPartition int32
+	hotFraction  float64
+
+	// If set, message sizes are drawn from this instead of all being
+	// messageSize
+	sizes *SizeDistribution
 }
 
 func NewProducerConfig(wc worker.WorkerConfig, name string, nPartitions int32,
-	messageSize int, messageCount int, fakeTimestampMs int64) ProducerConfig {
+	messageSize int, sizes *SizeDistribution, messageCount int, fakeTimestampMs int64, payloadFormat PayloadFormat,
+	hotPartition int32, hotFraction float64) ProducerConfig {
 	return ProducerConfig{
 		workerCfg:       wc,
 		name:            name,
 		nPartitions:     nPartitions,
 		messageCount:    messageCount,
 		messageSize:     messageSize,
+		sizes:           sizes,
 		fakeTimestampMs: fakeTimestampMs,
+		payloadFormat:   payloadFormat,
+		hotPartition:    hotPartition,
+		hotFraction:     hotFraction,
 	}
 }
 
@@ -43,24 +61,176 @@ type ProducerWorker struct {
 	Status          ProducerWorkerStatus
 	validOffsets    TopicOffsetRanges
 	fakeTimestampMs int64
+
+	// Our identity and next sequence number on each partition,
+	// for the sequence header
+	producerId string
+	sequences  []int64
+
+	// When and by which leader each offset was acked, and the
+	// leaders as of our last metadata refresh
+	ackEvidence AckEvidence
+	leaders     []PartitionLeader
+
+	// If producing to one rack, the partitions led from it as of our
+	// last refresh
+	rackPartitions []int32
+
+	// Records and bytes acked on each partition, for the placement report
+	ackedRecords []int64
+	ackedBytes   []int64
+
+	// If set, partitions are chosen by their ack latency
+	weights *LatencyWeights
+
+	// For choosing partitions, from the configured seed
+	rng *rand.Rand
+
+	// If set, pace our produce calls
+	msgLimiter  *worker.RateLimiter
+	byteLimiter *worker.RateLimiter
+
+	// Set by Stop, to finish early, and cancelled with it to wake waits
+	stopping int32
+	stopCtx  context.Context
+	stop     context.CancelFunc
+
+	// If set, a failed produce stops us with an error from Wait rather
+	// than stopping the process.  The first failure is kept under
+	// Status.lock.
+	tolerant bool
+	failure  error
+
+	// validOffsets may be read by the HTTP server while we produce,
+	// and ackEvidence and leaders are updated from ack callbacks
+	offsetsLock sync.Mutex
 }
 
 func NewProducerWorker(cfg ProducerConfig) ProducerWorker {
+	var weights *LatencyWeights
+	if threshold := cfg.workerCfg.LatencyWeightThreshold; threshold > 0 {
+		weights = NewLatencyWeights(cfg.nPartitions, threshold)
+	}
+	var msgLimiter, byteLimiter *worker.RateLimiter
+	if rate := cfg.workerCfg.ProduceMsgRate; rate > 0 {
+		msgLimiter = worker.NewRateLimiter(rate, 0)
+	}
+	if rate := cfg.workerCfg.ProduceByteRate; rate > 0 {
+		byteLimiter = worker.NewRateLimiter(rate, 0)
+	}
+	stopCtx, stop := context.WithCancel(context.Background())
 	return ProducerWorker{
 		config:          cfg,
-		Status:          NewProducerWorkerStatus(),
-		validOffsets:    LoadTopicOffsetRanges(cfg.workerCfg.Topic, cfg.nPartitions),
+		Status:          NewProducerWorkerStatus(cfg.nPartitions, cfg.workerCfg.Seed),
+		validOffsets:    LoadTopicOffsetRanges(cfg.workerCfg.Topic, cfg.workerCfg.RunId, cfg.nPartitions),
 		fakeTimestampMs: cfg.fakeTimestampMs,
+		producerId:      newProducerId(),
+		sequences:       make([]int64, cfg.nPartitions),
+		ackEvidence:     loadOrNewAckEvidence(cfg.workerCfg.Topic, cfg.workerCfg.RunId, cfg.nPartitions),
+		leaders:         unknownLeaders(cfg.nPartitions),
+		ackedRecords:    make([]int64, cfg.nPartitions),
+		ackedBytes:      make([]int64, cfg.nPartitions),
+		weights:         weights,
+		rng:             rand.New(rand.NewSource(cfg.workerCfg.Seed)),
+		stopCtx:         stopCtx,
+		stop:            stop,
+		msgLimiter:      msgLimiter,
+		byteLimiter:     byteLimiter,
+	}
+}
+
+// Best effort: if metadata is unavailable we carry on with what we had
+func (pw *ProducerWorker) refreshLeaders(client *kgo.Client) {
+	leaders, err := GetLeaders(client, pw.config.workerCfg.Topic, pw.config.nPartitions)
+	if err != nil {
+		log.Warnf("Error refreshing partition leaders: %v", err)
+		return
+	}
+	pw.offsetsLock.Lock()
+	changes := make([]int64, len(leaders))
+	for p, l := range leaders {
+		changes[p] = leadershipChanges(pw.leaders[p], l)
+	}
+	pw.leaders = leaders
+	pw.offsetsLock.Unlock()
+
+	if pw.config.workerCfg.ProduceRack != "" {
+		pw.refreshRackPartitions(client, leaders)
+	}
+
+	pw.Status.lock.Lock()
+	defer pw.Status.lock.Unlock()
+	for p, n := range changes {
+		if n > 0 {
+			log.Infof("Leadership of partition %d changed %d times since last refresh", p, n)
+		}
+		pw.Status.LeadershipChanges[p] += n
+		pw.Status.LeadershipChangesTotal += n
 	}
 }
 
-func (pw *ProducerWorker) newRecord(producerId int, sequence int64) *kgo.Record {
-	var key bytes.Buffer
-	fmt.Fprintf(&key, "%06d.%018d", producerId, sequence)
+func (pw *ProducerWorker) refreshRackPartitions(client *kgo.Client, leaders []PartitionLeader) {
+	rack := pw.config.workerCfg.ProduceRack
+	racks, err := GetBrokerRacks(client)
+	if err != nil {
+		log.Warnf("Error refreshing broker racks: %v", err)
+		return
+	}
+	partitions := []int32{}
+	for p, l := range leaders {
+		if l.Leader >= 0 && racks[l.Leader] == rack {
+			partitions = append(partitions, int32(p))
+		}
+	}
 
-	payload := make([]byte, pw.config.messageSize)
+	pw.offsetsLock.Lock()
+	changed := len(partitions) != len(pw.rackPartitions)
+	for i := 0; !changed && i < len(partitions); i++ {
+		changed = partitions[i] != pw.rackPartitions[i]
+	}
+	pw.rackPartitions = partitions
+	pw.offsetsLock.Unlock()
 
-	var r *kgo.Record = kgo.KeySliceRecord(key.Bytes(), payload)
+	if changed {
+		log.Infof("Partitions led from rack %s: %v", rack, partitions)
+	}
+	pw.Status.lock.Lock()
+	pw.Status.RackPartitions = partitions
+	pw.Status.lock.Unlock()
+}
+
+// Leader epochs go up by one per election, so this counts elections
+// between our refreshes too.  Without epochs, we only see that the
+// leader moved.
+func leadershipChanges(before PartitionLeader, after PartitionLeader) int64 {
+	if before.Leader < 0 || after.Leader < 0 {
+		return 0
+	}
+	if before.LeaderEpoch >= 0 && after.LeaderEpoch >= 0 {
+		if after.LeaderEpoch > before.LeaderEpoch {
+			return int64(after.LeaderEpoch - before.LeaderEpoch)
+		}
+		return 0
+	}
+	if after.Leader != before.Leader {
+		return 1
+	}
+	return 0
+}
+
+func (pw *ProducerWorker) newRecord(producerId int, sequence int64, partition int32, size int) *kgo.Record {
+	payload := makePayload(pw.config.payloadFormat, size, sequence)
+
+	var r *kgo.Record = kgo.KeySliceRecord(makeKey(producerId, sequence), payload)
+	r.Partition = partition
+
+	r.Headers = append(r.Headers, makeSequenceHeader(pw.producerId, pw.sequences[partition]))
+	pw.sequences[partition] += 1
+	r.Headers = append(r.Headers, makeChecksumHeader(payload))
+
+	if pw.config.workerCfg.RunId != "" {
+		r.Headers = append(r.Headers, kgo.RecordHeader{Key: RunIdHeader, Value: []byte(pw.config.workerCfg.RunId)})
+	}
 
 	if pw.fakeTimestampMs != -1 {
 		r.Timestamp = time.Unix(0, pw.fakeTimestampMs*1000000)
@@ -81,26 +251,83 @@ type ProducerWorkerStatus struct {
 	// (indicates retries/resends)
 	BadOffsets int64 `json:"bad_offsets"`
 
+	// Of the bad offsets, those that landed later than expected after
+	// the partition's leadership changed (benign re-sequencing, e.g.
+	// retries across an election), and the rest (landed early, or
+	// without a leadership change: anomalies worth a look)
+	BadOffsetsLeadershipChange int64 `json:"bad_offsets_leadership_change"`
+	BadOffsetsAnomalous        int64 `json:"bad_offsets_anomalous"`
+
+	// Leader elections seen on each partition while we produced, from
+	// the leader epochs in our metadata refreshes, and their total: for
+	// normalizing the anomaly counters by how turbulent the cluster was
+	LeadershipChanges      []int64 `json:"leadership_changes"`
+	LeadershipChangesTotal int64   `json:"leadership_changes_total"`
+
 	// How many times did we restart the producer loop?
 	Restarts int64 `json:"restarts"`
 
+	// The seed partitions were chosen with, for --seed to choose them
+	// the same way again
+	Seed int64 `json:"seed"`
+
+	Panic *worker.PanicError `json:"panic,omitempty"`
+
 	// Ack latency: a private histogram for the data,
 	// and a public summary for JSON output
 	latency metrics.Histogram
 	Latency worker.HistogramSummary `json:"latency"`
 
+	// Ack latency split into time spent in the client (buffering,
+	// linger, waiting to be written) and the round trip of the produce
+	// request that carried the record (network and broker)
+	queueLatency  metrics.Histogram
+	QueueLatency  worker.HistogramSummary `json:"queue_latency"`
+	brokerLatency metrics.Histogram
+	BrokerLatency worker.HistogramSummary `json:"broker_latency"`
+
+	Retries worker.RetrySummary `json:"retries"`
+
+	Client worker.ClientSummary `json:"client"`
+
 	Active bool `json:"latency"`
 
+	StartedAt time.Time `json:"started_at"`
+
+	// Where the data we produced ended up, by partition leader, once
+	// the run is done
+	Placement *PlacementReport `json:"placement,omitempty"`
+
+	// Sizes of the messages we produced: a private histogram, and the
+	// buckets with anything in them for JSON output
+	sizes        SizeHistogram
+	MessageSizes []SizeBucket `json:"message_sizes"`
+
+	// Pauses for a linked consumer group's lag, if there is one
+	Backpressure *worker.LagGateStatus `json:"backpressure,omitempty"`
+
+	// If producing to one rack, the partitions led from it as of our
+	// last look
+	RackPartitions []int32 `json:"rack_partitions,omitempty"`
+
+	// Partition weights and shifts between them, if choosing partitions
+	// by ack latency
+	LatencyWeights *LatencyWeightStatus `json:"latency_weights,omitempty"`
+
 	lock sync.Mutex
 
 	// For emitting checkpoints on time intervals
 	lastCheckpoint time.Time
 }
 
-func NewProducerWorkerStatus() ProducerWorkerStatus {
+func NewProducerWorkerStatus(nPartitions int32, seed int64) ProducerWorkerStatus {
 	return ProducerWorkerStatus{
-		lastCheckpoint: time.Now(),
-		latency:        metrics.NewHistogram(metrics.NewExpDecaySample(1024, 0.015)),
+		Seed:              seed,
+		LeadershipChanges: make([]int64, nPartitions),
+		lastCheckpoint:    time.Now(),
+		latency:           metrics.NewHistogram(metrics.NewExpDecaySample(1024, 0.015)),
+		queueLatency:      metrics.NewHistogram(metrics.NewExpDecaySample(1024, 0.015)),
+		brokerLatency:     metrics.NewHistogram(metrics.NewExpDecaySample(1024, 0.015)),
 	}
 }
 
@@ -117,7 +344,13 @@ func (self *ProducerWorkerStatus) OnBadOffset() {
 }
 
 func (pw *ProducerWorker) produceCheckpoint() {
+	pw.offsetsLock.Lock()
 	err := pw.validOffsets.Store()
+	evidence := pw.ackEvidence.Clone()
+	pw.offsetsLock.Unlock()
+	if err == nil {
+		err = evidence.Store()
+	}
 	util.Chk(err, "Error writing offset map: %v", err)
 
 	data, err := json.Marshal(pw.Status)
@@ -128,20 +361,45 @@ func (pw *ProducerWorker) produceCheckpoint() {
 func (pw *ProducerWorker) Wait() error {
 	pw.Status.Active = true
 	defer func() { pw.Status.Active = false }()
+	if pw.Status.StartedAt.IsZero() {
+		pw.Status.StartedAt = time.Now()
+	}
 
 	n := int64(pw.config.messageCount)
 
 	for {
-		n_produced, bad_offsets, err := pw.produceInner(n)
+		var n_produced int64
+		var bad_offsets []BadOffset
+		err := worker.Supervise("producer", pw.config.workerCfg.PanicRestarts, func() {
+			pw.Status.Restarts += 1
+		}, func() error {
+			var err error
+			n_produced, bad_offsets, err = pw.produceInner(n)
+			return err
+		})
+		if perr, ok := err.(*worker.PanicError); ok {
+			pw.Status.Panic = perr
+		}
 		if err != nil {
 			return err
 		}
 		n = n - n_produced
 
+		if pw.Stopped() {
+			log.Infof("Producer stopped, %d still to do", n)
+			return pw.Failure()
+		}
+
 		if len(bad_offsets) > 0 {
 			log.Infof("Produce stopped early, %d still to do", n)
 		}
 
+		if replay := pw.config.workerCfg.TraceReplay; replay != nil && replay.Remaining() == 0 {
+			// The trace is the whole workload: records that landed
+			// at bad offsets aren't made up for
+			return nil
+		}
+
 		if n <= 0 {
 			return nil
 		} else {
@@ -151,16 +409,161 @@ func (pw *ProducerWorker) Wait() error {
 	}
 }
 
+// Stop sending new records.  Wait returns once those already sent are acked
+// and checkpointed.
+func (pw *ProducerWorker) Stop() {
+	if atomic.CompareAndSwapInt32(&pw.stopping, 0, 1) {
+		pw.stop()
+	}
+}
+
+func (pw *ProducerWorker) Stopped() bool {
+	return atomic.LoadInt32(&pw.stopping) != 0
+}
+
+// Stop with an error rather than stopping the process when a produce
+// fails, for a caller that has other work to finish regardless
+func (pw *ProducerWorker) TolerateFailures() {
+	pw.tolerant = true
+}
+
+func (pw *ProducerWorker) fail(err error) {
+	pw.Status.lock.Lock()
+	if pw.failure == nil {
+		log.Errorf("Stopping producer: %v", err)
+		pw.failure = err
+	}
+	pw.Status.lock.Unlock()
+	pw.Stop()
+}
+
+// The failure that stopped us, if any
+func (pw *ProducerWorker) Failure() error {
+	pw.Status.lock.Lock()
+	defer pw.Status.lock.Unlock()
+	return pw.failure
+}
+
+// The partitions we may produce to, nil for all of them.  Waits while there
+// are none.
+func (pw *ProducerWorker) candidatePartitions(client *kgo.Client) []int32 {
+	coordinator := pw.config.workerCfg.Coordinator
+	rack := pw.config.workerCfg.ProduceRack
+	if coordinator == nil && rack == "" {
+		return nil
+	}
+	for {
+		var candidates []int32
+		if coordinator != nil {
+			candidates = coordinator.Partitions()
+		}
+		if rack != "" {
+			pw.offsetsLock.Lock()
+			inRack := pw.rackPartitions
+			pw.offsetsLock.Unlock()
+			if coordinator == nil {
+				candidates = inRack
+			} else {
+				var both []int32
+				for _, p := range inRack {
+					if coordinator.Owns(p) {
+						both = append(both, p)
+					}
+				}
+				candidates = both
+			}
+		}
+		if len(candidates) > 0 {
+			return candidates
+		}
+
+		if rack != "" {
+			log.Warnf("No partitions for this producer led from rack %s, waiting...", rack)
+		} else {
+			log.Warnf("No partitions assigned to this producer, waiting...")
+		}
+		time.Sleep(5 * time.Second)
+		if rack != "" {
+			pw.refreshLeaders(client)
+		}
+	}
+}
+
+func (pw *ProducerWorker) choosePartition(client *kgo.Client) int32 {
+	candidates := pw.candidatePartitions(client)
+	if pw.config.hotPartition >= 0 && isCandidate(candidates, pw.config.hotPartition) && pw.rng.Float64() < pw.config.hotFraction {
+		return pw.config.hotPartition
+	}
+	if pw.weights != nil {
+		return pw.weights.Pick(pw.rng, candidates)
+	}
+	if candidates == nil {
+		return pw.rng.Int31n(pw.config.nPartitions)
+	}
+	return candidates[pw.rng.Intn(len(candidates))]
+}
+
+func isCandidate(candidates []int32, p int32) bool {
+	if candidates == nil {
+		return true
+	}
+	for _, c := range candidates {
+		if c == p {
+			return true
+		}
+	}
+	return false
+}
+
 type BadOffset struct {
 	P int32
 	O int64
+
+	// Where we expected it, and the leader epoch when we sent it
+	Expected  int64
+	SentEpoch int32
+}
+
+// Attributes the round trip of the leader's latest produce request to the
+// broker, and the rest of the ack latency to the client
+func (pw *ProducerWorker) observeLatencyBreakdown(ackLatency time.Duration, leader int32) {
+	brokerTime, ok := pw.config.workerCfg.ClientStats.LastProduceTime(leader)
+	if !ok {
+		return
+	}
+	if brokerTime > ackLatency {
+		brokerTime = ackLatency
+	}
+	pw.Status.brokerLatency.Update(brokerTime.Microseconds())
+	pw.Status.queueLatency.Update((ackLatency - brokerTime).Microseconds())
+}
+
+// Once leaders have been refreshed after the bad offsets were acked
+func (pw *ProducerWorker) classifyBadOffsets(bad []BadOffset) {
+	pw.offsetsLock.Lock()
+	leaders := pw.leaders
+	pw.offsetsLock.Unlock()
+
+	pw.Status.lock.Lock()
+	defer pw.Status.lock.Unlock()
+	for _, b := range bad {
+		epoch := leaders[b.P].LeaderEpoch
+		if b.O > b.Expected && b.SentEpoch >= 0 && epoch >= 0 && epoch != b.SentEpoch {
+			pw.Status.BadOffsetsLeadershipChange += 1
+			log.Infof("Bad offset on %d at %d (expected %d) after leader epoch %d -> %d", b.P, b.O, b.Expected, b.SentEpoch, epoch)
+		} else {
+			pw.Status.BadOffsetsAnomalous += 1
+			log.Warnf("Anomalous bad offset on %d at %d (expected %d), leader epoch %d -> %d", b.P, b.O, b.Expected, b.SentEpoch, epoch)
+			pw.config.workerCfg.Anomalies.Observe("bad_offset", fmt.Sprintf("bad offset on %s/%d at %d, expected %d", pw.config.workerCfg.Topic, b.P, b.O, b.Expected))
+		}
+	}
 }
 
 func (pw *ProducerWorker) produceInner(n int64) (int64, []BadOffset, error) {
 	opts := pw.config.workerCfg.MakeKgoOpts()
 
 	opts = append(opts, []kgo.Opt{
-		kgo.ProducerBatchCompression(kgo.NoCompression()),
+		kgo.ProducerBatchCompression(pw.config.workerCfg.Compression),
 		kgo.RequiredAcks(kgo.AllISRAcks()),
 		kgo.RecordPartitioner(kgo.ManualPartitioner()),
 	}...)
@@ -169,8 +572,20 @@ func (pw *ProducerWorker) produceInner(n int64) (int64, []BadOffset, error) {
 		log.Errorf("Error creating Kafka client: %v", err)
 		return 0, nil, err
 	}
+	// If we panicked, let what is in flight be acked before a restarted
+	// pass starts, then drop anything left so that none of this pass's
+	// callbacks run alongside the next one's
+	var abandoned int32
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		client.Flush(ctx)
+		cancel()
+		atomic.StoreInt32(&abandoned, 1)
+		client.Close()
+	}()
 
 	nextOffset := GetOffsets(client, pw.config.workerCfg.Topic, pw.config.nPartitions, -1)
+	pw.refreshLeaders(client)
 
 	for i, o := range nextOffset {
 		log.Infof("Produce start offset %s/%d %d...", pw.config.workerCfg.Topic, i, o)
@@ -185,19 +600,55 @@ func (pw *ProducerWorker) produceInner(n int64) (int64, []BadOffset, error) {
 	bad_offsets := make(chan BadOffset, 16384)
 	concurrent := semaphore.NewWeighted(4096)
 
-	log.Infof("Producing %d messages (%d bytes)", n, pw.config.messageSize)
+	if pw.config.sizes != nil {
+		log.Infof("Producing %d messages (sizes %s)", n, pw.config.sizes)
+	} else {
+		log.Infof("Producing %d messages (%d bytes)", n, pw.config.messageSize)
+	}
 
-	for i := int64(0); i < n && len(bad_offsets) == 0; i = i + 1 {
+	for i := int64(0); i < n && len(bad_offsets) == 0 && !pw.Stopped(); i = i + 1 {
+		pw.config.workerCfg.LagGate.Wait(pw.stopCtx.Done())
+		pw.config.workerCfg.Freeze.Wait()
 		concurrent.Acquire(context.Background(), 1)
+		var p int32
+		size := pw.config.messageSize
+		if replay := pw.config.workerCfg.TraceReplay; replay != nil {
+			op, ok := replay.Next()
+			if !ok {
+				concurrent.Release(1)
+				break
+			}
+			p = op.Partition
+			size = op.Size
+		} else {
+			p = pw.choosePartition(client)
+			if pw.config.sizes != nil {
+				size = pw.config.sizes.Sample(pw.rng)
+			}
+		}
+		pw.Status.lock.Lock()
+		pw.Status.sizes.Observe(size)
+		pw.Status.lock.Unlock()
+		if recorder := pw.config.workerCfg.TraceRecorder; recorder != nil {
+			err := recorder.Record(p, size)
+			util.Chk(err, "Error writing workload trace: %v", err)
+		}
 		produced += 1
 		pw.Status.Sent += 1
-		var p = rand.Int31n(pw.config.nPartitions)
 
 		expectOffset := nextOffset[p]
 		nextOffset[p] += 1
 
-		r := pw.newRecord(0, expectOffset)
-		r.Partition = p
+		r := pw.newRecord(0, expectOffset, p, size)
+		if pw.msgLimiter != nil {
+			pw.msgLimiter.Wait(pw.stopCtx, 1)
+		}
+		if pw.byteLimiter != nil {
+			pw.byteLimiter.Wait(pw.stopCtx, float64(len(r.Key)+len(r.Value)))
+		}
+		pw.offsetsLock.Lock()
+		sentEpoch := pw.leaders[p].LeaderEpoch
+		pw.offsetsLock.Unlock()
 		wg.Add(1)
 
 		log.Debugf("Writing partition %d at %d", r.Partition, expectOffset)
@@ -205,19 +656,44 @@ func (pw *ProducerWorker) produceInner(n int64) (int64, []BadOffset, error) {
 		sentAt := time.Now()
 		handler := func(r *kgo.Record, err error) {
 			concurrent.Release(1)
+			if atomic.LoadInt32(&abandoned) != 0 {
+				wg.Done()
+				return
+			}
+			if err != nil && pw.tolerant {
+				pw.fail(fmt.Errorf("produce failed: %v", err))
+				wg.Done()
+				return
+			}
 			util.Chk(err, "Produce failed: %v", err)
 			if expectOffset != r.Offset {
 				log.Warnf("Produced at unexpected offset %d (expected %d) on partition %d", r.Offset, expectOffset, r.Partition)
 				pw.Status.OnBadOffset()
-				bad_offsets <- BadOffset{r.Partition, r.Offset}
+				pw.config.workerCfg.Maintenance.OnBadOffset()
+				pw.config.workerCfg.SLIs.OnOperation(true, r.Partition, r.Offset)
+				bad_offsets <- BadOffset{r.Partition, r.Offset, expectOffset, sentEpoch}
 				errored = true
 				log.Debugf("errored = %b", errored)
 			} else {
-				ackLatency := time.Now().Sub(sentAt)
+				ackedAt := time.Now()
+				ackLatency := ackedAt.Sub(sentAt)
 				pw.Status.OnAcked()
 				pw.Status.latency.Update(ackLatency.Microseconds())
+				pw.config.workerCfg.Maintenance.OnAck(ackLatency)
+				pw.config.workerCfg.SLIs.OnOperation(false, r.Partition, r.Offset)
+				pw.config.workerCfg.SLIs.ObserveAck(ackLatency, r)
 				log.Debugf("Wrote partition %d at %d", r.Partition, r.Offset)
+				pw.offsetsLock.Lock()
+				leader := pw.leaders[r.Partition]
 				pw.validOffsets.Insert(r.Partition, r.Offset)
+				pw.ackEvidence.Insert(r.Partition, r.Offset, ackedAt, leader)
+				pw.ackedRecords[r.Partition] += 1
+				pw.ackedBytes[r.Partition] += int64(len(r.Key) + len(r.Value))
+				pw.offsetsLock.Unlock()
+				pw.observeLatencyBreakdown(ackLatency, leader.Leader)
+				if pw.weights != nil {
+					pw.weights.OnAck(r.Partition, ackLatency)
+				}
 			}
 			wg.Done()
 		}
@@ -228,6 +704,7 @@ func (pw *ProducerWorker) produceInner(n int64) (int64, []BadOffset, error) {
 
 		if time.Since(pw.Status.lastCheckpoint) > 5*time.Second {
 			pw.Status.lastCheckpoint = time.Now()
+			pw.refreshLeaders(client)
 			pw.produceCheckpoint()
 		}
 	}
@@ -249,21 +726,84 @@ func (pw *ProducerWorker) produceInner(n int64) (int64, []BadOffset, error) {
 		if len(r) == 0 {
 			util.Die("No bad offsets but errored?")
 		}
+		pw.refreshLeaders(client)
+		pw.classifyBadOffsets(r)
 		successful_produced := produced - int64(len(r))
 		return successful_produced, r, nil
 	} else {
 		wg.Wait()
+		pw.reportPlacement(client)
 		return produced, nil, nil
 	}
 }
 
+// Once we have produced everything, how it is spread across the brokers
+func (pw *ProducerWorker) reportPlacement(client *kgo.Client) {
+	brokers, err := GetBrokers(client)
+	if err != nil {
+		log.Warnf("Error listing brokers for placement report: %v", err)
+		return
+	}
+	pw.refreshLeaders(client)
+
+	pw.offsetsLock.Lock()
+	report := NewPlacementReport(brokers, pw.leaders, pw.ackedRecords, pw.ackedBytes)
+	pw.offsetsLock.Unlock()
+	pw.Status.Placement = &report
+
+	data, err := json.Marshal(report)
+	util.Chk(err, "Status serialization error")
+	if report.Imbalanced {
+		log.Warnf("Produced data imbalanced across brokers (skew %.2f): %s", report.Skew, data)
+	} else {
+		log.Infof("Produced data placement (skew %.2f): %s", report.Skew, data)
+	}
+}
+
+// The offsets acked so far, in the same form as the valid_offsets file
+func (pw *ProducerWorker) ValidOffsetsJSON() ([]byte, error) {
+	pw.offsetsLock.Lock()
+	defer pw.offsetsLock.Unlock()
+	return json.Marshal(&pw.validOffsets)
+}
+
 func (pw *ProducerWorker) ResetStats() {
-	pw.Status = NewProducerWorkerStatus()
+	startedAt := pw.Status.StartedAt
+	pw.Status = NewProducerWorkerStatus(pw.config.nPartitions, pw.config.workerCfg.Seed)
+	pw.Status.StartedAt = startedAt
+	pw.config.workerCfg.Retries.Reset()
+	pw.config.workerCfg.ClientStats.Reset()
+	if pw.weights != nil {
+		pw.weights.ResetStats()
+	}
 }
 
 func (pw *ProducerWorker) GetStatus() interface{} {
 	// Update public summary from private statustics
 	pw.Status.Latency = worker.SummarizeHistogram(&pw.Status.latency)
+	pw.Status.QueueLatency = worker.SummarizeHistogram(&pw.Status.queueLatency)
+	pw.Status.BrokerLatency = worker.SummarizeHistogram(&pw.Status.brokerLatency)
+	pw.Status.Retries = pw.config.workerCfg.Retries.Summary()
+	pw.Status.Client = pw.config.workerCfg.ClientStats.Summary()
+	pw.Status.lock.Lock()
+	pw.Status.MessageSizes = pw.Status.sizes.Buckets()
+	pw.Status.lock.Unlock()
+	if gate := pw.config.workerCfg.LagGate; gate != nil {
+		backpressure := gate.Status()
+		pw.Status.Backpressure = &backpressure
+	}
+	if pw.weights != nil {
+		weights := pw.weights.Status()
+		pw.Status.LatencyWeights = &weights
+	}
 
 	return &pw.Status
 }
+
+func (pw *ProducerWorker) GetClientStats() *worker.ClientStats {
+	return pw.config.workerCfg.ClientStats
+}
+
+func (pw *ProducerWorker) GetSLIStats() *worker.SLIStats {
+	return pw.config.workerCfg.SLIs
+}
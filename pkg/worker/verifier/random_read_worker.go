@@ -29,6 +29,17 @@ type RandomWorkerStatus struct {
 	Validator ValidatorStatus `json:"validator"`
 	Active    bool            `json:"active"`
 	Errors    int             `json:"errors"`
+
+	// How many times did we restart a pass after a panic?
+	Restarts int64 `json:"restarts"`
+
+	Retries worker.RetrySummary `json:"retries"`
+
+	Client worker.ClientSummary `json:"client"`
+
+	Panic *worker.PanicError `json:"panic,omitempty"`
+
+	StartedAt time.Time `json:"started_at"`
 }
 
 func NewRandomReadConfig(wc worker.WorkerConfig, name string, nPartitions int32, readCount int) RandomReadConfig {
@@ -58,8 +69,21 @@ func (w *RandomReadWorker) newClient(opts []kgo.Opt) (*kgo.Client, error) {
 }
 
 func (w *RandomReadWorker) Wait() error {
+	err := worker.Supervise(w.config.name, w.config.workerCfg.PanicRestarts, func() {
+		w.Status.Restarts += 1
+	}, w.readPass)
+	if perr, ok := err.(*worker.PanicError); ok {
+		w.Status.Panic = perr
+	}
+	return err
+}
+
+func (w *RandomReadWorker) readPass() error {
 	w.Status.Active = true
 	defer func() { w.Status.Active = false }()
+	if w.Status.StartedAt.IsZero() {
+		w.Status.StartedAt = time.Now()
+	}
 
 	// Basic client to read offsets
 	client, err := w.newClient(make([]kgo.Opt, 0))
@@ -78,7 +102,11 @@ func (w *RandomReadWorker) Wait() error {
 	client.Close()
 	runtime.GC()
 
-	validRanges := LoadTopicOffsetRanges(w.config.workerCfg.Topic, w.config.nPartitions)
+	validRanges := LoadValidRanges(w.config.workerCfg, w.config.nPartitions)
+	if w.config.workerCfg.RefetchInvalid {
+		w.Status.Validator.SetRefetcher(NewRefetcher(w.config.workerCfg))
+	}
+	w.Status.Validator.EnableDeadLetters(w.config.workerCfg)
 
 	ctxLog := log.WithFields(log.Fields{"tag": w.config.name})
 
@@ -87,9 +115,22 @@ func (w *RandomReadWorker) Wait() error {
 	// Select a partition and location
 	ctxLog.Infof("Reading %d random offsets", w.config.readCount)
 
+	var owned []int32
+	if coordinator := w.config.workerCfg.Coordinator; coordinator != nil {
+		owned = coordinator.Partitions()
+		if len(owned) == 0 {
+			ctxLog.Warnf("No partitions assigned to this reader, skipping pass")
+			return nil
+		}
+	}
+
 	i := 0
 	for i < readCount {
+		w.config.workerCfg.Freeze.Wait()
 		p := rand.Int31n(w.config.nPartitions)
+		if owned != nil {
+			p = owned[rand.Intn(len(owned))]
+		}
 		pStart := startOffsets[p]
 		pEnd := endOffsets[p]
 
@@ -123,6 +164,7 @@ func (w *RandomReadWorker) Wait() error {
 		defer cancel()
 		fetches := client.PollRecords(ctx, 1)
 		ctxLog.Debugf("Read done for partition %d (%d-%d) at offset %d", p, pStart, pEnd, offset)
+		w.config.workerCfg.SLIs.OnFetches(fetches)
 		fetches.EachError(func(topic string, partition int32, e error) {
 			// In random read mode, we tolerate read errors: if the server is unavailable
 			// we will just proceed to read the next random offset.
@@ -130,7 +172,7 @@ func (w *RandomReadWorker) Wait() error {
 		})
 		fetches.EachRecord(func(r *kgo.Record) {
 			if r.Partition != p {
-				util.Die("Wrong partition %d in read at offset %d on partition %s/%d", r.Partition, r.Offset, w.config.workerCfg.Topic, p)
+				util.DieValidation("Wrong partition %d in read at offset %d on partition %s/%d", r.Partition, r.Offset, w.config.workerCfg.Topic, p)
 			}
 			w.Status.Validator.ValidateRecord(r, &validRanges)
 		})
@@ -147,13 +189,28 @@ func (w *RandomReadWorker) Wait() error {
 		client.Close()
 	}
 
+	w.Status.Validator.CheckDeadLetters()
 	return nil
 }
 
 func (rrw *RandomReadWorker) ResetStats() {
+	startedAt := rrw.Status.StartedAt
 	rrw.Status = RandomWorkerStatus{}
+	rrw.Status.StartedAt = startedAt
+	rrw.config.workerCfg.Retries.Reset()
+	rrw.config.workerCfg.ClientStats.Reset()
 }
 
 func (rrw *RandomReadWorker) GetStatus() interface{} {
+	rrw.Status.Retries = rrw.config.workerCfg.Retries.Summary()
+	rrw.Status.Client = rrw.config.workerCfg.ClientStats.Summary()
 	return &rrw.Status
 }
+
+func (rrw *RandomReadWorker) GetClientStats() *worker.ClientStats {
+	return rrw.config.workerCfg.ClientStats
+}
+
+func (rrw *RandomReadWorker) GetSLIStats() *worker.SLIStats {
+	return rrw.config.workerCfg.SLIs
+}
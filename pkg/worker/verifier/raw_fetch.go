@@ -0,0 +1,223 @@
+package verifier
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/twmb/franz-go/pkg/kbin"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// Raw fetch: issue Fetch requests directly to a particular broker and decode
+// the record batches ourselves, rather than going through the kgo consumer.
+// This lets us read from followers, and see batch-level metadata (CRCs,
+// offset deltas) that kgo hides from us.
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// Offset of the attributes field within a v2 record batch: everything from
+// here to the end of the batch is covered by the CRC.
+const batchCrcStart = 21
+
+// Size of a v2 record batch's header, which all of its fields fit in
+const batchHeaderSize = 61
+
+type RawBatch struct {
+	Header kmsg.RecordBatch
+
+	// Whether the CRC in the header matched the content
+	CrcOk bool
+
+	// Decoded records, absolute offsets are Header.FirstOffset + OffsetDelta
+	Records []kmsg.Record
+}
+
+func (rb *RawBatch) IsControl() bool {
+	return rb.Header.Attributes&0x20 != 0
+}
+
+// One of the batch's records, as the kgo consumer would have returned it
+func (rb *RawBatch) Record(topic string, partition int32, rr *kmsg.Record) *kgo.Record {
+	r := &kgo.Record{
+		Topic:     topic,
+		Partition: partition,
+		Offset:    rb.Header.FirstOffset + int64(rr.OffsetDelta),
+		Key:       rr.Key,
+		Value:     rr.Value,
+	}
+	for _, h := range rr.Headers {
+		r.Headers = append(r.Headers, kgo.RecordHeader{Key: h.Key, Value: h.Value})
+	}
+	return r
+}
+
+func decompressRecords(codec int16, data []byte) ([]byte, error) {
+	switch codec {
+	case 0:
+		return data, nil
+	case 1:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(r)
+	case 2:
+		// Kafka's java client historically wrote snappy in xerial framing
+		xerialHeader := []byte{130, 'S', 'N', 'A', 'P', 'P', 'Y', 0}
+		if !bytes.HasPrefix(data, xerialHeader) {
+			return snappy.Decode(nil, data)
+		}
+		data = data[16:]
+		var out []byte
+		for len(data) >= 4 {
+			chunkLen := int(binary.BigEndian.Uint32(data))
+			data = data[4:]
+			if chunkLen > len(data) {
+				return nil, errors.New("truncated xerial snappy chunk")
+			}
+			chunk, err := snappy.Decode(nil, data[:chunkLen])
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, chunk...)
+			data = data[chunkLen:]
+		}
+		return out, nil
+	case 3:
+		return ioutil.ReadAll(lz4.NewReader(bytes.NewReader(data)))
+	case 4:
+		dec, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer dec.Close()
+		return dec.DecodeAll(data, nil)
+	default:
+		return nil, fmt.Errorf("unknown compression codec %d", codec)
+	}
+}
+
+// Decode the RecordBatches field of a fetch response.  A trailing partial
+// batch (which brokers are allowed to send) is silently dropped.  A batch
+// whose length is too short to hold its header is returned as failing its
+// CRC, and ends the decoding: we can't tell where the next one starts.
+func DecodeRecordBatches(data []byte) ([]RawBatch, error) {
+	var result []RawBatch
+	for len(data) >= batchCrcStart {
+		length := int(int32(binary.BigEndian.Uint32(data[8:12])))
+		total := 12 + length
+		if total < batchHeaderSize {
+			var rb RawBatch
+			rb.Header.FirstOffset = int64(binary.BigEndian.Uint64(data[0:8]))
+			rb.Header.Length = int32(length)
+			result = append(result, rb)
+			break
+		}
+		if total > len(data) {
+			break
+		}
+		raw := data[:total]
+		data = data[total:]
+
+		magic := int8(raw[16])
+		if magic != 2 {
+			// Legacy message sets: nothing we produce looks like this
+			continue
+		}
+
+		var rb RawBatch
+		err := rb.Header.ReadFrom(raw)
+		if err != nil {
+			return result, err
+		}
+		rb.CrcOk = crc32.Checksum(raw[batchCrcStart:], crc32c) == uint32(rb.Header.CRC)
+
+		rb.Records, err = decodeRecords(&rb)
+		if err != nil && rb.CrcOk {
+			return result, err
+		}
+		// A batch that fails its CRC may well not decode either: that
+		// is corruption to report, not a reason to stop reading
+
+		result = append(result, rb)
+	}
+
+	return result, nil
+}
+
+func decodeRecords(rb *RawBatch) ([]kmsg.Record, error) {
+	records, err := decompressRecords(rb.Header.Attributes&0x07, rb.Header.Records)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []kmsg.Record
+	for i := int32(0); i < rb.Header.NumRecords && len(records) > 0; i++ {
+		recordLen, n := kbin.Varint(records)
+		if n == 0 || n+int(recordLen) > len(records) {
+			return result, errors.New("truncated record")
+		}
+		var r kmsg.Record
+		err = r.ReadFrom(records[:n+int(recordLen)])
+		if err != nil {
+			return result, err
+		}
+		result = append(result, r)
+		records = records[n+int(recordLen):]
+	}
+	return result, nil
+}
+
+// Fetch from a particular broker, whether or not it is the leader.  The topic
+// ID is only needed for brokers that negotiate Fetch v13+.
+func FetchRaw(ctx context.Context, client *kgo.Client, brokerId int32, topic string, topicId [16]byte, partition int32, offset int64, maxBytes int32) ([]RawBatch, error) {
+	req := kmsg.NewPtrFetchRequest()
+	req.ReplicaID = -1
+	req.MaxWaitMillis = 500
+	req.MinBytes = 1
+	req.MaxBytes = maxBytes
+	req.SessionEpoch = -1
+
+	reqTopic := kmsg.NewFetchRequestTopic()
+	reqTopic.Topic = topic
+	reqTopic.TopicID = topicId
+	reqPartition := kmsg.NewFetchRequestTopicPartition()
+	reqPartition.Partition = partition
+	reqPartition.FetchOffset = offset
+	reqPartition.PartitionMaxBytes = maxBytes
+	reqTopic.Partitions = append(reqTopic.Partitions, reqPartition)
+	req.Topics = append(req.Topics, reqTopic)
+
+	resp, err := req.RequestWith(ctx, client.Broker(int(brokerId)))
+	if err != nil {
+		return nil, err
+	}
+	if resp.ErrorCode != 0 {
+		return nil, kerr.ErrorForCode(resp.ErrorCode)
+	}
+
+	for _, t := range resp.Topics {
+		for _, p := range t.Partitions {
+			if p.Partition != partition {
+				continue
+			}
+			if p.ErrorCode != 0 {
+				return nil, kerr.ErrorForCode(p.ErrorCode)
+			}
+			return DecodeRecordBatches(p.RecordBatches)
+		}
+	}
+
+	return nil, fmt.Errorf("no data for %s/%d in fetch response from broker %d", topic, partition, brokerId)
+}
@@ -0,0 +1,275 @@
+package verifier
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redpanda-data/kgo-verifier/pkg/util"
+	worker "github.com/redpanda-data/kgo-verifier/pkg/worker"
+	log "github.com/sirupsen/logrus"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Give up on a partition after this many fetches in a row fail
+const rawReadMaxRetries = 10
+
+type RawReadConfig struct {
+	workerCfg   worker.WorkerConfig
+	name        string
+	nPartitions int32
+}
+
+func NewRawReadConfig(wc worker.WorkerConfig, name string, nPartitions int32) RawReadConfig {
+	return RawReadConfig{
+		workerCfg:   wc,
+		name:        name,
+		nPartitions: nPartitions,
+	}
+}
+
+type RawReadStatus struct {
+	Validator ValidatorStatus `json:"validator"`
+	Active    bool            `json:"active"`
+
+	// Batches read (control batches among them), and those whose CRC
+	// didn't match their content
+	Batches        int64 `json:"batches"`
+	ControlBatches int64 `json:"control_batches"`
+	BadCrcBatches  int64 `json:"bad_crc_batches"`
+
+	// Batches whose offsets don't add up: overlapping the batch before,
+	// record counts or offset deltas not matching the header.  And gaps
+	// between batches, which compaction and retention leave behind.
+	BadBoundaryBatches int64 `json:"bad_boundary_batches"`
+	BatchGaps          int64 `json:"batch_gaps"`
+
+	// Failed fetches, retried
+	Errors int64 `json:"errors"`
+
+	// How many times did we restart a pass after a panic?
+	Restarts int64 `json:"restarts"`
+
+	Panic *worker.PanicError `json:"panic,omitempty"`
+
+	StartedAt time.Time `json:"started_at"`
+
+	Client worker.ClientSummary `json:"client"`
+}
+
+// Read the whole topic with Fetch requests of our own to each partition's
+// leader, checking batch CRCs ourselves instead of trusting kgo's decoding,
+// to catch on-disk corruption that would otherwise show up, if at all, as
+// some other kind of failure.  Records in batches that pass are validated as
+// usual; batches that fail are counted, and fail the pass at the end.  So
+// are batches whose header offsets don't agree with their neighbours or
+// with their own records, as a broker rewriting batches wrongly (in
+// compaction, say) would leave them.
+type RawReadWorker struct {
+	config RawReadConfig
+	Status RawReadStatus
+}
+
+func NewRawReadWorker(cfg RawReadConfig) RawReadWorker {
+	return RawReadWorker{
+		config: cfg,
+		Status: RawReadStatus{Validator: NewValidatorStatus()},
+	}
+}
+
+func (rw *RawReadWorker) Wait() error {
+	err := worker.Supervise(rw.config.name, rw.config.workerCfg.PanicRestarts, func() {
+		rw.Status.Restarts += 1
+	}, rw.readPass)
+	if perr, ok := err.(*worker.PanicError); ok {
+		rw.Status.Panic = perr
+	}
+	return err
+}
+
+func (rw *RawReadWorker) readPass() error {
+	rw.Status.Active = true
+	defer func() { rw.Status.Active = false }()
+	if rw.Status.StartedAt.IsZero() {
+		rw.Status.StartedAt = time.Now()
+	}
+
+	client, err := kgo.NewClient(rw.config.workerCfg.MakeKgoOpts()...)
+	if err != nil {
+		log.Errorf("Error constructing client: %v", err)
+		return err
+	}
+	defer client.Close()
+
+	lwm := GetOffsets(client, rw.config.workerCfg.Topic, rw.config.nPartitions, -2)
+	hwm := GetOffsets(client, rw.config.workerCfg.Topic, rw.config.nPartitions, -1)
+	log.Infof("Raw read start offsets: %v", lwm)
+	log.Infof("Raw read end offsets: %v", hwm)
+
+	validRanges := LoadValidRanges(rw.config.workerCfg, rw.config.nPartitions)
+	rw.Status.Validator.EnableSequenceChecks()
+	rw.Status.Validator.EnableDeadLetters(rw.config.workerCfg)
+
+	for i := range lwm {
+		p := int32(i)
+		if coordinator := rw.config.workerCfg.Coordinator; coordinator != nil && !coordinator.Owns(p) {
+			continue
+		}
+		if err := rw.readPartition(client, p, lwm[p], hwm[p], &validRanges); err != nil {
+			return err
+		}
+	}
+
+	log.Infof("Raw read complete (validator status %v)", rw.Status.Validator.String())
+	rw.Status.Validator.CheckDeadLetters()
+	if rw.Status.BadCrcBatches > 0 {
+		util.DieValidation("%d of %d batches read had bad CRCs", rw.Status.BadCrcBatches, rw.Status.Batches)
+	}
+	if rw.Status.BadBoundaryBatches > 0 {
+		util.DieValidation("%d of %d batches read had inconsistent offsets", rw.Status.BadBoundaryBatches, rw.Status.Batches)
+	}
+	return nil
+}
+
+func (rw *RawReadWorker) readPartition(client *kgo.Client, p int32, start int64, end int64, validRanges *TopicOffsetRanges) error {
+	topic := rw.config.workerCfg.Topic
+	replicas, err := GetPartitionReplicas(client, topic, p)
+	failures := 0
+	offset := start
+	prevLast := int64(-1)
+	// First offset of a batch we couldn't step past, once reported
+	stuckAt := int64(-1)
+	for offset < end {
+		var batches []RawBatch
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			batches, err = FetchRaw(ctx, client, replicas.Leader, topic, replicas.TopicId, p, offset, int32(rw.config.workerCfg.BatchMaxbytes))
+			cancel()
+			if err == nil && len(batches) == 0 {
+				err = fmt.Errorf("no batches")
+			}
+		}
+		if err != nil {
+			rw.Status.Errors += 1
+			failures += 1
+			if failures >= rawReadMaxRetries {
+				return fmt.Errorf("raw fetch of %s/%d at %d failed %d times: %v", topic, p, offset, failures, err)
+			}
+			log.Warnf("Raw fetch of %s/%d at %d: %v, retrying", topic, p, offset, err)
+			time.Sleep(time.Second)
+			// Maybe leadership moved
+			replicas, err = GetPartitionReplicas(client, topic, p)
+			continue
+		}
+		failures = 0
+
+		from := offset
+		for i := range batches {
+			b := &batches[i]
+			last := b.Header.FirstOffset + int64(b.Header.LastOffsetDelta)
+			if i == 0 && last < offset {
+				// Fetches start from the batch holding the offset
+				continue
+			}
+			// A later batch behind us is a duplicate or rewrite, for
+			// onBatch to report as overlapping
+			rw.onBatch(topic, p, b, prevLast, offset, end, validRanges)
+			if last >= offset {
+				offset = last + 1
+				prevLast = last
+			}
+		}
+
+		if offset == from {
+			// A corrupt last offset delta puts the batch holding our
+			// offset behind it: report the batch once, and step past
+			// one offset at a time rather than fetch it forever
+			b := &batches[0]
+			if b.Header.FirstOffset != stuckAt {
+				stuckAt = b.Header.FirstOffset
+				rw.onBatch(topic, p, b, prevLast, offset, end, validRanges)
+				if problem := batchBoundaryProblem(b, prevLast); b.CrcOk && problem == "" {
+					rw.Status.BadBoundaryBatches += 1
+					log.Errorf("Bad batch %s/%d at offset %d: ends at %d, before the fetch offset %d", topic, p, b.Header.FirstOffset, b.Header.FirstOffset+int64(b.Header.LastOffsetDelta), from)
+				}
+			}
+			offset += 1
+		}
+	}
+	return nil
+}
+
+func (rw *RawReadWorker) onBatch(topic string, p int32, b *RawBatch, prevLast int64, from int64, end int64, validRanges *TopicOffsetRanges) {
+	rw.Status.Batches += 1
+	if !b.CrcOk {
+		rw.Status.BadCrcBatches += 1
+		log.Errorf("Bad CRC on batch %s/%d at offsets %d-%d", topic, p, b.Header.FirstOffset, b.Header.FirstOffset+int64(b.Header.LastOffsetDelta))
+		return
+	}
+
+	if prevLast >= 0 && b.Header.FirstOffset > prevLast+1 {
+		rw.Status.BatchGaps += 1
+		log.Debugf("Gap on %s/%d between offsets %d and %d", topic, p, prevLast, b.Header.FirstOffset)
+	}
+	if problem := batchBoundaryProblem(b, prevLast); problem != "" {
+		rw.Status.BadBoundaryBatches += 1
+		log.Errorf("Bad batch %s/%d at offset %d: %s", topic, p, b.Header.FirstOffset, problem)
+	}
+	if b.IsControl() {
+		rw.Status.ControlBatches += 1
+		return
+	}
+
+	for i := range b.Records {
+		r := b.Record(topic, p, &b.Records[i])
+		if r.Offset < from || r.Offset >= end {
+			continue
+		}
+		rw.Status.Validator.ValidateRecord(r, validRanges)
+	}
+}
+
+// What is wrong with the batch's offsets, if anything.  Compaction may drop
+// records from a batch, but never changes the offsets of those it keeps or
+// the batch's own range.
+func batchBoundaryProblem(b *RawBatch, prevLast int64) string {
+	if prevLast >= 0 && b.Header.FirstOffset <= prevLast {
+		return fmt.Sprintf("overlaps the batch before, which ended at %d", prevLast)
+	}
+	if b.Header.LastOffsetDelta < 0 {
+		return fmt.Sprintf("negative last offset delta %d", b.Header.LastOffsetDelta)
+	}
+	if int(b.Header.NumRecords) != len(b.Records) {
+		return fmt.Sprintf("header says %d records, found %d", b.Header.NumRecords, len(b.Records))
+	}
+	if b.IsControl() {
+		if b.Header.LastOffsetDelta != 0 || len(b.Records) != 1 {
+			return fmt.Sprintf("control batch with %d records, last offset delta %d", len(b.Records), b.Header.LastOffsetDelta)
+		}
+		return ""
+	}
+	prevDelta := int32(-1)
+	for _, r := range b.Records {
+		if r.OffsetDelta <= prevDelta || r.OffsetDelta > b.Header.LastOffsetDelta {
+			return fmt.Sprintf("record offset delta %d after %d, last offset delta %d", r.OffsetDelta, prevDelta, b.Header.LastOffsetDelta)
+		}
+		prevDelta = r.OffsetDelta
+	}
+	return ""
+}
+
+func (rw *RawReadWorker) ResetStats() {
+	startedAt := rw.Status.StartedAt
+	rw.Status = RawReadStatus{Validator: NewValidatorStatus()}
+	rw.Status.StartedAt = startedAt
+	rw.config.workerCfg.ClientStats.Reset()
+}
+
+func (rw *RawReadWorker) GetStatus() interface{} {
+	rw.Status.Client = rw.config.workerCfg.ClientStats.Summary()
+	return &rw.Status
+}
+
+func (rw *RawReadWorker) GetClientStats() *worker.ClientStats {
+	return rw.config.workerCfg.ClientStats
+}
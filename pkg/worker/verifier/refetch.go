@@ -0,0 +1,155 @@
+package verifier
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	worker "github.com/redpanda-data/kgo-verifier/pkg/worker"
+	log "github.com/sirupsen/logrus"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// When a record fails validation, read it a second time to find out whether
+// the bad content is really in the log (durable corruption), or whether the
+// first read was damaged somewhere between the broker's storage and us
+// (transient corruption in the read path or the client).
+type Refetcher struct {
+	workerCfg worker.WorkerConfig
+}
+
+func NewRefetcher(wc worker.WorkerConfig) *Refetcher {
+	// Our own fetches, sessionless follower fetches among them, aren't
+	// the reader's: keep them out of its fetch session stats
+	wc.FetchSessions = nil
+	return &Refetcher{workerCfg: wc}
+}
+
+type RefetchResult struct {
+	// "leader" or "follower-<broker id>"
+	Source string
+	Found  bool
+	Key    []byte
+	Value  []byte
+	Err    error
+}
+
+func (rr *RefetchResult) String() string {
+	if rr.Err != nil {
+		return fmt.Sprintf("%s: error %v", rr.Source, rr.Err)
+	} else if !rr.Found {
+		return fmt.Sprintf("%s: offset not found", rr.Source)
+	} else {
+		return fmt.Sprintf("%s: key '%s'", rr.Source, rr.Key)
+	}
+}
+
+func (rf *Refetcher) Refetch(r *kgo.Record) []RefetchResult {
+	results := []RefetchResult{rf.refetchLeader(r)}
+	if rf.workerCfg.RefetchFollower {
+		results = append(results, rf.refetchFollower(r))
+	}
+	return results
+}
+
+// Read through a fresh kgo client, the same way the original read was done
+func (rf *Refetcher) refetchLeader(r *kgo.Record) RefetchResult {
+	result := RefetchResult{Source: "leader"}
+
+	offsets := map[string]map[int32]kgo.Offset{
+		r.Topic: {r.Partition: kgo.NewOffset().At(r.Offset)},
+	}
+	opts := rf.workerCfg.MakeKgoOpts()
+	opts = append(opts, kgo.ConsumePartitions(offsets))
+	client, err := kgo.NewClient(opts...)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	fetches := client.PollRecords(ctx, 1)
+	fetches.EachError(func(t string, p int32, e error) {
+		result.Err = e
+	})
+	fetches.EachRecord(func(fr *kgo.Record) {
+		if fr.Partition == r.Partition && fr.Offset == r.Offset {
+			result.Found = true
+			result.Key = fr.Key
+			result.Value = fr.Value
+		}
+	})
+
+	return result
+}
+
+// Read directly from a follower replica, bypassing the kgo consumer entirely
+func (rf *Refetcher) refetchFollower(r *kgo.Record) RefetchResult {
+	result := RefetchResult{Source: "follower"}
+
+	client, err := kgo.NewClient(rf.workerCfg.MakeKgoOpts()...)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer client.Close()
+
+	replicas, err := GetPartitionReplicas(client, r.Topic, r.Partition)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	follower := int32(-1)
+	for _, id := range replicas.Replicas {
+		if id != replicas.Leader {
+			follower = id
+			break
+		}
+	}
+	if follower == -1 {
+		result.Err = fmt.Errorf("no followers for %s/%d", r.Topic, r.Partition)
+		return result
+	}
+	result.Source = fmt.Sprintf("follower-%d", follower)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	batches, err := FetchRaw(ctx, client, follower, r.Topic, replicas.TopicId, r.Partition, r.Offset, int32(rf.workerCfg.BatchMaxbytes))
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	for _, b := range batches {
+		for _, fr := range b.Records {
+			if b.Header.FirstOffset+int64(fr.OffsetDelta) == r.Offset {
+				result.Found = true
+				result.Key = fr.Key
+				result.Value = fr.Value
+			}
+		}
+	}
+
+	return result
+}
+
+// Classify a bad read: durable if a second read returns the same bad
+// content, transient if a second read returns the content we expected.
+func ClassifyRefetch(r *kgo.Record, expectKey string, results []RefetchResult) (transient bool, durable bool) {
+	for _, rr := range results {
+		log.Infof("Re-fetch %s/%d o=%d from %s", r.Topic, r.Partition, r.Offset, rr.String())
+		if rr.Err != nil || !rr.Found {
+			continue
+		}
+		if string(rr.Key) == expectKey {
+			transient = true
+		} else if bytes.Equal(rr.Key, r.Key) && bytes.Equal(rr.Value, r.Value) {
+			durable = true
+		}
+	}
+	return transient, durable
+}
@@ -0,0 +1,255 @@
+package verifier
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redpanda-data/kgo-verifier/pkg/util"
+	worker "github.com/redpanda-data/kgo-verifier/pkg/worker"
+	log "github.com/sirupsen/logrus"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+type ReplicaCompareConfig struct {
+	workerCfg   worker.WorkerConfig
+	name        string
+	nPartitions int32
+}
+
+func NewReplicaCompareConfig(wc worker.WorkerConfig, name string, nPartitions int32) ReplicaCompareConfig {
+	return ReplicaCompareConfig{
+		workerCfg:   wc,
+		name:        name,
+		nPartitions: nPartitions,
+	}
+}
+
+type ReplicaCompareStatus struct {
+	Active bool `json:"active"`
+
+	// Records compared between the leader and a follower, and of those,
+	// records that differed or that the follower didn't have
+	Compared   int64 `json:"compared"`
+	Mismatches int64 `json:"mismatches"`
+	Missing    int64 `json:"missing"`
+
+	// Failed fetches, retried, and followers we gave up on for a
+	// partition after retrying
+	Errors          int64 `json:"errors"`
+	SkippedReplicas int64 `json:"skipped_replicas"`
+
+	// How many times did we restart a pass after a panic?
+	Restarts int64 `json:"restarts"`
+
+	Panic *worker.PanicError `json:"panic,omitempty"`
+
+	StartedAt time.Time `json:"started_at"`
+
+	Client worker.ClientSummary `json:"client"`
+}
+
+// Read the same offsets from each partition's leader and from each of its
+// followers, fetching from each broker directly, and compare the records
+// byte for byte: checks that replicas agree, rather than inferring it from
+// reads that only ever go to the leader.  Replicas compact independently, so
+// this is only meaningful on topics without compaction.
+type ReplicaCompareWorker struct {
+	config ReplicaCompareConfig
+	Status ReplicaCompareStatus
+}
+
+func NewReplicaCompareWorker(cfg ReplicaCompareConfig) ReplicaCompareWorker {
+	return ReplicaCompareWorker{
+		config: cfg,
+		Status: ReplicaCompareStatus{},
+	}
+}
+
+func (rcw *ReplicaCompareWorker) Wait() error {
+	err := worker.Supervise(rcw.config.name, rcw.config.workerCfg.PanicRestarts, func() {
+		rcw.Status.Restarts += 1
+	}, rcw.comparePass)
+	if perr, ok := err.(*worker.PanicError); ok {
+		rcw.Status.Panic = perr
+	}
+	return err
+}
+
+func (rcw *ReplicaCompareWorker) comparePass() error {
+	rcw.Status.Active = true
+	defer func() { rcw.Status.Active = false }()
+	if rcw.Status.StartedAt.IsZero() {
+		rcw.Status.StartedAt = time.Now()
+	}
+
+	client, err := kgo.NewClient(rcw.config.workerCfg.MakeKgoOpts()...)
+	if err != nil {
+		log.Errorf("Error constructing client: %v", err)
+		return err
+	}
+	defer client.Close()
+
+	lwm := GetOffsets(client, rcw.config.workerCfg.Topic, rcw.config.nPartitions, -2)
+	hwm := GetOffsets(client, rcw.config.workerCfg.Topic, rcw.config.nPartitions, -1)
+
+	for i := range lwm {
+		p := int32(i)
+		if coordinator := rcw.config.workerCfg.Coordinator; coordinator != nil && !coordinator.Owns(p) {
+			continue
+		}
+		if lwm[p] >= hwm[p] {
+			continue
+		}
+		replicas, err := GetPartitionReplicas(client, rcw.config.workerCfg.Topic, p)
+		if err != nil {
+			log.Errorf("Error looking up replicas of %s/%d: %v", rcw.config.workerCfg.Topic, p, err)
+			return err
+		}
+		for _, follower := range replicas.Replicas {
+			if follower != replicas.Leader {
+				rcw.compare(client, p, replicas, follower, lwm[p], hwm[p])
+			}
+		}
+	}
+
+	log.Infof("Replica comparison complete: %d records compared, %d mismatched, %d missing, %d replicas skipped",
+		rcw.Status.Compared, rcw.Status.Mismatches, rcw.Status.Missing, rcw.Status.SkippedReplicas)
+	if rcw.Status.Mismatches > 0 || rcw.Status.Missing > 0 {
+		util.DieValidation("Replicas disagree: %d records mismatched and %d missing on followers", rcw.Status.Mismatches, rcw.Status.Missing)
+	}
+	return nil
+}
+
+// Compare the follower's copy of [start, end) with the leader's
+func (rcw *ReplicaCompareWorker) compare(client *kgo.Client, p int32, replicas PartitionReplicas, follower int32, start int64, end int64) {
+	topic := rcw.config.workerCfg.Topic
+	failures := 0
+	for offset := start; offset < end; {
+		leaderRecords, leaderLast, err := rcw.fetch(client, replicas.Leader, p, replicas.TopicId, offset)
+		var followerRecords map[int64]replicaRecord
+		var followerLast int64
+		if err == nil {
+			followerRecords, followerLast, err = rcw.fetch(client, follower, p, replicas.TopicId, offset)
+		}
+		if err != nil {
+			rcw.Status.Errors += 1
+			failures += 1
+			if failures >= rawReadMaxRetries {
+				log.Warnf("Giving up comparing %s/%d on broker %d at %d with the leader: %v", topic, p, follower, offset, err)
+				rcw.Status.SkippedReplicas += 1
+				return
+			}
+			log.Warnf("Error comparing %s/%d on broker %d at %d with the leader: %v, retrying", topic, p, follower, offset, err)
+			time.Sleep(time.Second)
+			continue
+		}
+		failures = 0
+
+		// Only as far as both fetches got
+		upTo := leaderLast
+		if followerLast < upTo {
+			upTo = followerLast
+		}
+		if end-1 < upTo {
+			upTo = end - 1
+		}
+		for o := offset; o <= upTo; o++ {
+			lr, ok := leaderRecords[o]
+			if !ok {
+				continue
+			}
+			rcw.Status.Compared += 1
+			fr, ok := followerRecords[o]
+			if !ok {
+				rcw.Status.Missing += 1
+				log.Errorf("Offset %d on %s/%d is on the leader %d but missing on broker %d", o, topic, p, replicas.Leader, follower)
+			} else if problem := recordDifference(&lr, &fr); problem != "" {
+				rcw.Status.Mismatches += 1
+				log.Errorf("Offset %d on %s/%d differs between the leader %d and broker %d: %s", o, topic, p, replicas.Leader, follower, problem)
+			}
+		}
+		offset = upTo + 1
+	}
+}
+
+// The records at and after the offset, by offset, and the last offset
+// covered by the batches fetched
+func (rcw *ReplicaCompareWorker) fetch(client *kgo.Client, broker int32, p int32, topicId [16]byte, offset int64) (map[int64]replicaRecord, int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	batches, err := FetchRaw(ctx, client, broker, rcw.config.workerCfg.Topic, topicId, p, offset, int32(rcw.config.workerCfg.BatchMaxbytes))
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(batches) == 0 {
+		return nil, 0, fmt.Errorf("no batches")
+	}
+
+	records := make(map[int64]replicaRecord)
+	last := int64(-1)
+	for _, b := range batches {
+		if l := b.Header.FirstOffset + int64(b.Header.LastOffsetDelta); l > last {
+			last = l
+		}
+		if !b.CrcOk {
+			// Its records count as missing, if this is a follower
+			log.Errorf("Bad CRC on batch %s/%d at %d from broker %d", rcw.config.workerCfg.Topic, p, b.Header.FirstOffset, broker)
+			continue
+		}
+		if b.IsControl() {
+			continue
+		}
+		for _, r := range b.Records {
+			o := b.Header.FirstOffset + int64(r.OffsetDelta)
+			records[o] = replicaRecord{Record: r, Timestamp: b.Header.FirstTimestamp + int64(r.TimestampDelta)}
+		}
+	}
+	return records, last, nil
+}
+
+// A record as fetched, with its absolute timestamp
+type replicaRecord struct {
+	kmsg.Record
+	Timestamp int64
+}
+
+// How two copies of a record differ, if they do
+func recordDifference(a *replicaRecord, b *replicaRecord) string {
+	if !bytes.Equal(a.Key, b.Key) {
+		return fmt.Sprintf("key '%s' against '%s'", a.Key, b.Key)
+	}
+	if !bytes.Equal(a.Value, b.Value) {
+		return fmt.Sprintf("values of %d and %d bytes differ", len(a.Value), len(b.Value))
+	}
+	if a.Timestamp != b.Timestamp {
+		return fmt.Sprintf("timestamp %d against %d", a.Timestamp, b.Timestamp)
+	}
+	if len(a.Headers) != len(b.Headers) {
+		return fmt.Sprintf("%d headers against %d", len(a.Headers), len(b.Headers))
+	}
+	for i := range a.Headers {
+		if a.Headers[i].Key != b.Headers[i].Key || !bytes.Equal(a.Headers[i].Value, b.Headers[i].Value) {
+			return fmt.Sprintf("header %d '%s' differs", i, a.Headers[i].Key)
+		}
+	}
+	return ""
+}
+
+func (rcw *ReplicaCompareWorker) ResetStats() {
+	startedAt := rcw.Status.StartedAt
+	rcw.Status = ReplicaCompareStatus{}
+	rcw.Status.StartedAt = startedAt
+	rcw.config.workerCfg.ClientStats.Reset()
+}
+
+func (rcw *ReplicaCompareWorker) GetStatus() interface{} {
+	rcw.Status.Client = rcw.config.workerCfg.ClientStats.Summary()
+	return &rcw.Status
+}
+
+func (rcw *ReplicaCompareWorker) GetClientStats() *worker.ClientStats {
+	return rcw.config.workerCfg.ClientStats
+}
@@ -0,0 +1,383 @@
+package verifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/redpanda-data/kgo-verifier/pkg/util"
+	worker "github.com/redpanda-data/kgo-verifier/pkg/worker"
+	log "github.com/sirupsen/logrus"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Offsets are scrubbed in aligned windows of this many
+const scrubWindow = 16
+
+// How often to pick up the offsets the producer has acked since
+const scrubReloadInterval = 30 * time.Second
+
+type ScrubConfig struct {
+	workerCfg   worker.WorkerConfig
+	name        string
+	nPartitions int32
+
+	// Records per second
+	rate float64
+}
+
+func NewScrubConfig(wc worker.WorkerConfig, name string, nPartitions int32, rate float64) ScrubConfig {
+	return ScrubConfig{
+		workerCfg:   wc,
+		name:        name,
+		nPartitions: nPartitions,
+		rate:        rate,
+	}
+}
+
+type ScrubStatus struct {
+	Name   string `json:"name"`
+	Active bool   `json:"active"`
+
+	// Windows read, the valid records checked in them, and of those
+	// records, the ones that read back wrong or not at all
+	Windows    int64 `json:"windows"`
+	Records    int64 `json:"records"`
+	Mismatches int64 `json:"mismatches"`
+	Missing    int64 `json:"missing"`
+
+	// Read errors, tolerated as they are by random reads
+	Errors int64 `json:"errors"`
+
+	// Valid offsets as of our last look, and the fraction of them
+	// scrubbed at least once
+	ValidOffsets int64   `json:"valid_offsets"`
+	Coverage     float64 `json:"coverage"`
+
+	StartedAt time.Time `json:"started_at"`
+
+	Client worker.ClientSummary `json:"client"`
+
+	lock sync.Mutex
+}
+
+// Slowly re-reads random windows of offsets that the producer acked,
+// throughout the run, to catch data that changes after it was first
+// validated: late corruption, or bugs in compaction or tiering.
+type ScrubWorker struct {
+	config ScrubConfig
+	Status ScrubStatus
+
+	// Per partition, the windows (offset / scrubWindow) scrubbed so far,
+	// and how many valid offsets they held
+	scrubbed []map[int64]bool
+	covered  int64
+}
+
+func NewScrubWorker(cfg ScrubConfig) ScrubWorker {
+	return ScrubWorker{
+		config:   cfg,
+		Status:   ScrubStatus{Name: cfg.name},
+		scrubbed: newScrubbed(cfg.nPartitions),
+	}
+}
+
+func newScrubbed(nPartitions int32) []map[int64]bool {
+	scrubbed := make([]map[int64]bool, nPartitions)
+	for i := range scrubbed {
+		scrubbed[i] = make(map[int64]bool)
+	}
+	return scrubbed
+}
+
+// Scrub until the context is cancelled
+func (sw *ScrubWorker) Run(ctx context.Context) {
+	sw.Status.Active = true
+	defer func() { sw.Status.Active = false }()
+	sw.Status.StartedAt = time.Now()
+
+	limiter := worker.NewRateLimiter(sw.config.rate, scrubWindow)
+	log.Infof("Scrubbing acked offsets at %g records/s", sw.config.rate)
+
+	var client *kgo.Client
+	for client == nil {
+		var err error
+		client, err = kgo.NewClient(sw.config.workerCfg.MakeKgoOpts()...)
+		if err != nil {
+			log.Warnf("Error constructing scrub client: %v", err)
+			sw.onError()
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}
+	defer client.Close()
+
+	var validRanges TopicOffsetRanges
+	var loadedAt time.Time
+	for ctx.Err() == nil {
+		if time.Since(loadedAt) > scrubReloadInterval {
+			validRanges = LoadValidRanges(sw.config.workerCfg, sw.config.nPartitions)
+			loadedAt = time.Now()
+		}
+
+		p, start, ok := sw.pickWindow(&validRanges)
+		if !ok {
+			log.Debugf("Nothing to scrub yet")
+			select {
+			case <-ctx.Done():
+			case <-time.After(5 * time.Second):
+			}
+			continue
+		}
+		if limiter.Wait(ctx, scrubWindow) != nil {
+			break
+		}
+		sw.scrub(ctx, client, &validRanges, p, start)
+	}
+}
+
+// A random window holding at least one valid offset, all of them being
+// equally likely to be picked
+func (sw *ScrubWorker) pickWindow(validRanges *TopicOffsetRanges) (int32, int64, bool) {
+	var owned map[int32]bool
+	if coordinator := sw.config.workerCfg.Coordinator; coordinator != nil {
+		owned = make(map[int32]bool)
+		for _, p := range coordinator.Partitions() {
+			owned[p] = true
+		}
+	}
+
+	var total int64
+	for p, ors := range validRanges.PartitionRanges {
+		if owned != nil && !owned[int32(p)] {
+			continue
+		}
+		for _, r := range ors.Ranges {
+			total += r.Upper - r.Lower
+		}
+	}
+	sw.Status.lock.Lock()
+	sw.Status.ValidOffsets = total
+	sw.Status.lock.Unlock()
+	if total == 0 {
+		return 0, 0, false
+	}
+
+	i := rand.Int63n(total)
+	for p, ors := range validRanges.PartitionRanges {
+		if owned != nil && !owned[int32(p)] {
+			continue
+		}
+		for _, r := range ors.Ranges {
+			if i < r.Upper-r.Lower {
+				o := r.Lower + i
+				return int32(p), o - o%scrubWindow, true
+			}
+			i -= r.Upper - r.Lower
+		}
+	}
+	return 0, 0, false
+}
+
+// Read the window straight from the partition's leader, as the raw reader
+// does, so that one client serves every window
+func (sw *ScrubWorker) scrub(ctx context.Context, client *kgo.Client, validRanges *TopicOffsetRanges, p int32, start int64) {
+	topic := sw.config.workerCfg.Topic
+	end := start + scrubWindow
+	replicas, err := GetPartitionReplicas(client, topic, p)
+	if err != nil {
+		log.Warnf("Error looking up leader of %s/%d to scrub: %v", topic, p, err)
+		sw.onError()
+		return
+	}
+
+	// Retention may have deleted the start of the window: offsets below
+	// the log start aren't missing, and compaction may have removed
+	// records within it, so read until we pass the end rather than a count
+	from := start
+	offset := start
+	seen := make(map[int64]bool)
+	last := int64(-1)
+	readCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	for offset < end && readCtx.Err() == nil {
+		batches, err := FetchRaw(readCtx, client, replicas.Leader, topic, replicas.TopicId, p, offset, int32(sw.config.workerCfg.BatchMaxbytes))
+		if errors.Is(err, kerr.OffsetOutOfRange) {
+			logStart, err := getLogStartOffset(client, topic, sw.config.nPartitions, p)
+			if err != nil || logStart <= offset {
+				log.Warnf("Error scrubbing %s/%d at %d: offset out of range, log start %d (%v)", topic, p, offset, logStart, err)
+				sw.onError()
+				break
+			}
+			log.Debugf("Scrubbing %s/%d from log start %d rather than %d", topic, p, logStart, offset)
+			from, offset = logStart, logStart
+			continue
+		} else if err != nil {
+			if readCtx.Err() == nil {
+				log.Warnf("Error scrubbing %s/%d at %d: %v", topic, p, offset, err)
+				sw.onError()
+			}
+			break
+		}
+
+		progress := false
+		for i := range batches {
+			b := &batches[i]
+			bLast := b.Header.FirstOffset + int64(b.Header.LastOffsetDelta)
+			if bLast < offset {
+				// Fetches start from the batch holding the offset
+				continue
+			}
+			offset, last, progress = bLast+1, bLast, true
+			if !b.CrcOk {
+				sw.onBadBatch(topic, p, b, start, end, validRanges, seen)
+				continue
+			}
+			if b.IsControl() {
+				continue
+			}
+			for j := range b.Records {
+				r := b.Record(topic, p, &b.Records[j])
+				if r.Offset < start || r.Offset >= end || !validRanges.Contains(r.Partition, r.Offset) {
+					continue
+				}
+				seen[r.Offset] = true
+				if mismatch := scrubMismatch(r, validRanges); mismatch != "" {
+					validRanges.ackEvidence.Report(r.Topic, r.Partition, r.Offset)
+					log.Errorf("Scrub mismatch at offset %d on partition %s/%d: %s", r.Offset, r.Topic, r.Partition, mismatch)
+					sw.Status.lock.Lock()
+					sw.Status.Mismatches += 1
+					sw.Status.lock.Unlock()
+				}
+			}
+		}
+		if !progress {
+			// Up to the high watermark, or a batch we can't step past
+			break
+		}
+	}
+
+	// Valid offsets we read past without seeing have gone missing
+	checked := int64(len(seen))
+	for o := from; o < end && o <= last; o++ {
+		if validRanges.Contains(p, o) && !seen[o] {
+			validRanges.ackEvidence.Report(sw.config.workerCfg.Topic, p, o)
+			log.Errorf("Scrub found offset %d missing on partition %s/%d", o, sw.config.workerCfg.Topic, p)
+			sw.Status.lock.Lock()
+			sw.Status.Missing += 1
+			sw.Status.lock.Unlock()
+			checked += 1
+		}
+	}
+	if checked == 0 {
+		return
+	}
+
+	sw.Status.lock.Lock()
+	defer sw.Status.lock.Unlock()
+	sw.Status.Windows += 1
+	sw.Status.Records += checked
+	if w := start / scrubWindow; !sw.scrubbed[p][w] {
+		sw.scrubbed[p][w] = true
+		sw.covered += checked
+	}
+}
+
+// Why a valid record doesn't read back as it should, if it doesn't
+func scrubMismatch(r *kgo.Record, validRanges *TopicOffsetRanges) string {
+	if validRanges.RunId != "" {
+		if runId := recordRunId(r); runId != validRanges.RunId {
+			return fmt.Sprintf("expect run '%s', found '%s'", validRanges.RunId, runId)
+		}
+	}
+	if expect, found, checked := recordChecksum(r); checked && found != expect {
+		return fmt.Sprintf("expect checksum %s, found %s", expect, found)
+	}
+	expectKey := validRanges.ExpectedKey(r)
+	if expectKey != string(r.Key) && validRanges.keyPattern == nil && ParseKeyVersion(r.Key) == LegacyKeyVersion {
+		if validRanges.legacyKeys == LegacyKeysSkip {
+			return ""
+		}
+		expectKey = legacyKey(r.Offset)
+	}
+	if expectKey != string(r.Key) {
+		return fmt.Sprintf("expect key '%s', found '%s'", expectKey, r.Key)
+	}
+	return ""
+}
+
+// Every valid offset in the window that a batch with a bad CRC covers
+// is a mismatch: we can't trust any of its records
+func (sw *ScrubWorker) onBadBatch(topic string, p int32, b *RawBatch, start int64, end int64, validRanges *TopicOffsetRanges, seen map[int64]bool) {
+	bLast := b.Header.FirstOffset + int64(b.Header.LastOffsetDelta)
+	log.Errorf("Scrub found bad CRC on batch %s/%d at offsets %d-%d", topic, p, b.Header.FirstOffset, bLast)
+	for o := b.Header.FirstOffset; o <= bLast; o++ {
+		if o < start || o >= end || !validRanges.Contains(p, o) {
+			continue
+		}
+		seen[o] = true
+		validRanges.ackEvidence.Report(topic, p, o)
+		sw.Status.lock.Lock()
+		sw.Status.Mismatches += 1
+		sw.Status.lock.Unlock()
+	}
+}
+
+func getLogStartOffset(client *kgo.Client, topic string, nPartitions int32, p int32) (int64, error) {
+	offsets, err := getOffsetsInner(client, topic, nPartitions, -2)
+	if err != nil {
+		return -1, err
+	}
+	return offsets[p], nil
+}
+
+func (sw *ScrubWorker) onError() {
+	sw.Status.lock.Lock()
+	defer sw.Status.lock.Unlock()
+	sw.Status.Errors += 1
+}
+
+// Fail validation if the scrub found anything wrong
+func (sw *ScrubWorker) Check() {
+	sw.Status.lock.Lock()
+	mismatches, missing := sw.Status.Mismatches, sw.Status.Missing
+	sw.Status.lock.Unlock()
+	if mismatches > 0 || missing > 0 {
+		util.DieValidation("Scrub found %d mismatched and %d missing records", mismatches, missing)
+	}
+}
+
+func (sw *ScrubWorker) ResetStats() {
+	sw.Status.lock.Lock()
+	defer sw.Status.lock.Unlock()
+	sw.Status.Windows = 0
+	sw.Status.Records = 0
+	sw.Status.Mismatches = 0
+	sw.Status.Missing = 0
+	sw.Status.Errors = 0
+	sw.scrubbed = newScrubbed(sw.config.nPartitions)
+	sw.covered = 0
+	sw.config.workerCfg.ClientStats.Reset()
+}
+
+func (sw *ScrubWorker) GetStatus() interface{} {
+	sw.Status.lock.Lock()
+	defer sw.Status.lock.Unlock()
+	sw.Status.Coverage = 0
+	if sw.Status.ValidOffsets > 0 {
+		sw.Status.Coverage = float64(sw.covered) / float64(sw.Status.ValidOffsets)
+	}
+	sw.Status.Client = sw.config.workerCfg.ClientStats.Summary()
+	return &sw.Status
+}
+
+func (sw *ScrubWorker) GetClientStats() *worker.ClientStats {
+	return sw.config.workerCfg.ClientStats
+}
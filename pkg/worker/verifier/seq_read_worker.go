@@ -2,6 +2,10 @@ package verifier
 
 import (
 	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	worker "github.com/redpanda-data/kgo-verifier/pkg/worker"
 	log "github.com/sirupsen/logrus"
@@ -12,37 +16,78 @@ type SeqReadConfig struct {
 	workerCfg   worker.WorkerConfig
 	name        string
 	nPartitions int32
+
+	// Split the partitions between this many readers, each with its own
+	// client, to read large topics faster
+	shards int
 }
 
-func NewSeqReadConfig(wc worker.WorkerConfig, name string, nPartitions int32) SeqReadConfig {
+func NewSeqReadConfig(wc worker.WorkerConfig, name string, nPartitions int32, shards int) SeqReadConfig {
 	return SeqReadConfig{
 		workerCfg:   wc,
 		name:        name,
 		nPartitions: nPartitions,
+		shards:      shards,
 	}
 }
 
 type SeqWorkerStatus struct {
-	Validator ValidatorStatus `json:"validator"`
-	Active    bool            `json:"active"`
-	Errors    int             `json:"errors"`
+	Validator ValidatorStatus      `json:"validator"`
+	Active    bool                 `json:"active"`
+	Errors    int                  `json:"errors"`
+	Stuck     StuckPartitionStatus `json:"stuck"`
+
+	// Partitions delivering late relative to the rest, if tracked
+	ArrivalSkew ArrivalSkewStatus `json:"arrival_skew"`
+
+	// This pass's checksums of what we read, if computed, filled in
+	// when status is requested
+	Checksums *TopicChecksumStatus `json:"checksums,omitempty"`
+
+	// How many times did we restart the reader after a panic?
+	Restarts int64 `json:"restarts"`
+
+	Retries worker.RetrySummary `json:"retries"`
+
+	Client worker.ClientSummary `json:"client"`
+
+	// Fetch session usage, if tracked
+	FetchSessions worker.FetchSessionSummary `json:"fetch_sessions"`
+
+	// Simulated processing failures and their retries, if enabled
+	ProcessingFailures ProcessingFailureStatus `json:"processing_failures"`
+
+	Panic *worker.PanicError `json:"panic,omitempty"`
+
+	StartedAt time.Time `json:"started_at"`
 }
 
 type SeqReadWorker struct {
 	config SeqReadConfig
 	Status SeqWorkerStatus
+	paused PausedPartitions
+
+	// This pass's arrival skew tracker, shared by the shards, if any
+	skew *ArrivalSkewTracker
+
+	// This pass's checksums, shared by the shards, if any
+	checksums *TopicChecksums
 }
 
 func NewSeqReadWorker(cfg SeqReadConfig) SeqReadWorker {
 	return SeqReadWorker{
 		config: cfg,
 		Status: SeqWorkerStatus{},
+		paused: NewPausedPartitions(cfg.workerCfg.Topic),
 	}
 }
 
 func (srw *SeqReadWorker) Wait() error {
 	srw.Status.Active = true
 	defer func() { srw.Status.Active = false }()
+	if srw.Status.StartedAt.IsZero() {
+		srw.Status.StartedAt = time.Now()
+	}
 
 	client, err := kgo.NewClient(srw.config.workerCfg.MakeKgoOpts()...)
 	if err != nil {
@@ -51,28 +96,120 @@ func (srw *SeqReadWorker) Wait() error {
 	}
 
 	hwm := GetOffsets(client, srw.config.workerCfg.Topic, srw.config.nPartitions, -1)
+	client.Close()
+
+	srw.skew = nil
+	if factor := srw.config.workerCfg.ArrivalSkewFactor; factor > 0 {
+		srw.skew = NewArrivalSkewTracker(srw.config.workerCfg.Topic, srw.config.nPartitions, factor, srw.config.workerCfg.ArrivalSkewMin, &srw.Status.ArrivalSkew)
+	}
+
+	if srw.config.workerCfg.TopicChecksum {
+		srw.checksums = NewTopicChecksums(srw.config.workerCfg.Topic, srw.config.nPartitions)
+	}
+
+	shards := srw.assignShards()
+	errs := make([]error, len(shards))
+	var wg sync.WaitGroup
+	for i := range shards {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = srw.readShard(shards[i], hwm)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	srw.checksums.Finish()
+	srw.Status.Validator.CheckDeadLetters()
+	return nil
+}
+
+// Which partitions each shard reads, dealing out those that this process
+// reads in turn.  There is always at least one shard, even if it has nothing
+// to read.
+func (srw *SeqReadWorker) assignShards() [][]bool {
+	var owned []int32
+	for p := int32(0); p < srw.config.nPartitions; p++ {
+		if coordinator := srw.config.workerCfg.Coordinator; coordinator != nil && !coordinator.Owns(p) {
+			// Another process is reading this one
+			continue
+		}
+		owned = append(owned, p)
+	}
+
+	n := srw.config.shards
+	if n > len(owned) {
+		n = len(owned)
+	}
+	if n < 1 {
+		n = 1
+	}
+	shards := make([][]bool, n)
+	for i := range shards {
+		shards[i] = make([]bool, srw.config.nPartitions)
+	}
+	for i, p := range owned {
+		shards[i%n][p] = true
+	}
+	return shards
+}
+
+// Read the shard's partitions up to the given offsets, restarting the reader
+// on errors
+func (srw *SeqReadWorker) readShard(mine []bool, hwm []int64) error {
 	lwm := make([]int64, srw.config.nPartitions)
 
+	// Lasts the whole pass, so that restarts don't process records again
+	var failures *ProcessingFailures
+	if f := srw.config.workerCfg.ProcessingFailureFraction; f > 0 {
+		failures = NewProcessingFailures(srw.config.workerCfg.Topic, f, srw.config.workerCfg.ProcessingRetryBackoff, &srw.Status.ProcessingFailures)
+	}
+
 	for {
-		var err error
-		lwm, err = srw.sequentialReadInner(lwm, hwm)
-		if err != nil {
+		err := worker.Supervise("sequential reader", srw.config.workerCfg.PanicRestarts, func() {
+			atomic.AddInt64(&srw.Status.Restarts, 1)
+		}, func() error {
+			var err error
+			lwm, err = srw.sequentialReadInner(mine, lwm, hwm, failures)
+			return err
+		})
+		if perr, ok := err.(*worker.PanicError); ok {
+			srw.Status.Panic = perr
+			return err
+		} else if err != nil {
 			log.Warnf("Restarting reader for error %v", err)
 			// Loop around
 		} else {
+			if failures != nil {
+				failures.Check()
+			}
 			return nil
 		}
 	}
 }
 
-func (srw *SeqReadWorker) sequentialReadInner(startAt []int64, upTo []int64) ([]int64, error) {
+func (srw *SeqReadWorker) sequentialReadInner(mine []bool, startAt []int64, upTo []int64, failures *ProcessingFailures) ([]int64, error) {
 	log.Infof("Sequential read start offsets: %v", startAt)
 	log.Infof("Sequential read end offsets: %v", upTo)
 
 	offsets := make(map[string]map[int32]kgo.Offset)
 	partOffsets := make(map[int32]kgo.Offset, srw.config.nPartitions)
 	complete := make([]bool, srw.config.nPartitions)
+	// The watchdog only waits for data on our own partitions
+	watchUpTo := make([]int64, srw.config.nPartitions)
 	for i, o := range startAt {
+		if !mine[i] {
+			// Another process or shard is reading this one
+			complete[i] = true
+			watchUpTo[i] = o
+			continue
+		}
+		watchUpTo[i] = upTo[i]
 		partOffsets[int32(i)] = kgo.NewOffset().At(o)
 		log.Infof("Sequential start offset %s/%d  %#v...", srw.config.workerCfg.Topic, i, partOffsets[int32(i)])
 		if o == upTo[i] {
@@ -80,8 +217,17 @@ func (srw *SeqReadWorker) sequentialReadInner(startAt []int64, upTo []int64) ([]
 		}
 	}
 	offsets[srw.config.workerCfg.Topic] = partOffsets
+	if len(partOffsets) == 0 {
+		log.Warnf("No partitions assigned to this reader, skipping pass")
+		return startAt, nil
+	}
 
-	validRanges := LoadTopicOffsetRanges(srw.config.workerCfg.Topic, srw.config.nPartitions)
+	validRanges := LoadValidRanges(srw.config.workerCfg, srw.config.nPartitions)
+	if srw.config.workerCfg.RefetchInvalid {
+		srw.Status.Validator.SetRefetcher(NewRefetcher(srw.config.workerCfg))
+	}
+	srw.Status.Validator.EnableSequenceChecks()
+	srw.Status.Validator.EnableDeadLetters(srw.config.workerCfg)
 
 	opts := srw.config.workerCfg.MakeKgoOpts()
 	opts = append(opts, []kgo.Opt{
@@ -92,16 +238,53 @@ func (srw *SeqReadWorker) sequentialReadInner(startAt []int64, upTo []int64) ([]
 		log.Errorf("Error creating Kafka client: %v", err)
 		return nil, err
 	}
+	defer client.Close()
+	srw.paused.Register(client)
+	defer srw.paused.Unregister(client)
+
+	var watchdog *StuckPartitionWatchdog
+	if window := srw.config.workerCfg.StuckPartitionWindow; window > 0 {
+		watchdog = NewStuckPartitionWatchdog(window, startAt, watchUpTo, &srw.Status.Stuck)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go watchdog.Run(ctx, &srw.paused, func(p int32) {
+			watchdog.Diagnose(client, srw.config.workerCfg.Topic, p)
+			if srw.config.workerCfg.StuckPartitionRecover {
+				watchdog.Recover(client, srw.config.workerCfg.Topic, p, true)
+			}
+		})
+	}
 
 	last_read := make([]int64, srw.config.nPartitions)
 
 	for {
+		srw.config.workerCfg.Freeze.Wait()
 		log.Debugf("Calling PollFetches (last_read=%v status %s)", last_read, srw.Status.Validator.String())
-		fetches := client.PollFetches(context.Background())
+		pollCtx, cancelPoll := context.Background(), func() {}
+		if failures != nil {
+			if wait, ok := failures.NextRetry(); ok {
+				pollCtx, cancelPoll = context.WithTimeout(context.Background(), wait)
+			}
+		}
+		fetches := client.PollFetches(pollCtx)
+		timedOut := pollCtx.Err() != nil
+		cancelPoll()
 		log.Debugf("PollFetches returned %d fetches", len(fetches))
+		if failures != nil {
+			failures.ResumeDue(client, &srw.paused)
+			if timedOut && len(fetches.Records()) == 0 {
+				// Only woke up to retry
+				continue
+			}
+		}
+		srw.config.workerCfg.SLIs.OnFetches(fetches)
 
 		var r_err error
 		fetches.EachError(func(t string, p int32, err error) {
+			if timedOut && errors.Is(err, context.DeadlineExceeded) {
+				// Our own retry wakeup, not a fetch error
+				return
+			}
 			log.Warnf("Sequential fetch %s/%d e=%v...", t, p, err)
 			r_err = err
 		})
@@ -115,6 +298,9 @@ func (srw *SeqReadWorker) sequentialReadInner(startAt []int64, upTo []int64) ([]
 
 		fetches.EachRecord(func(r *kgo.Record) {
 			log.Debugf("Sequential read %s/%d o=%d...", srw.config.workerCfg.Topic, r.Partition, r.Offset)
+			if failures != nil && !failures.Process(client, r) {
+				return
+			}
 			if r.Offset > last_read[r.Partition] {
 				last_read[r.Partition] = r.Offset
 			}
@@ -124,6 +310,16 @@ func (srw *SeqReadWorker) sequentialReadInner(startAt []int64, upTo []int64) ([]
 			}
 
 			srw.Status.Validator.ValidateRecord(r, &validRanges)
+			srw.config.workerCfg.SLIs.ObserveE2E(r)
+			srw.skew.OnRecord(r)
+			if r.Offset < upTo[r.Partition] {
+				// Only what the pass set out to read, however far
+				// fetches happen to run past it
+				srw.checksums.OnRecord(r)
+			}
+			if watchdog != nil {
+				watchdog.OnRecord(r)
+			}
 		})
 
 		any_incomplete := false
@@ -140,14 +336,43 @@ func (srw *SeqReadWorker) sequentialReadInner(startAt []int64, upTo []int64) ([]
 	}
 
 	log.Infof("Sequential read complete up to %v (validator status %v)", last_read, srw.Status.Validator.String())
+	checkFetchSessions(srw.config.workerCfg)
 
 	return last_read, nil
 }
 
 func (srw *SeqReadWorker) ResetStats() {
+	startedAt := srw.Status.StartedAt
 	srw.Status = SeqWorkerStatus{}
+	srw.Status.StartedAt = startedAt
+	srw.config.workerCfg.Retries.Reset()
+	srw.config.workerCfg.ClientStats.Reset()
+	srw.config.workerCfg.FetchSessions.Reset()
+}
+
+func (srw *SeqReadWorker) PausePartitions(partitions []int32) []int32 {
+	return srw.paused.Pause(partitions)
+}
+
+func (srw *SeqReadWorker) ResumePartitions(partitions []int32) []int32 {
+	return srw.paused.Resume(partitions)
 }
 
 func (srw *SeqReadWorker) GetStatus() interface{} {
+	srw.Status.Retries = srw.config.workerCfg.Retries.Summary()
+	srw.Status.Client = srw.config.workerCfg.ClientStats.Summary()
+	srw.Status.FetchSessions = srw.config.workerCfg.FetchSessions.Summary()
+	if srw.checksums != nil {
+		checksums := srw.checksums.Status()
+		srw.Status.Checksums = &checksums
+	}
 	return &srw.Status
 }
+
+func (srw *SeqReadWorker) GetClientStats() *worker.ClientStats {
+	return srw.config.workerCfg.ClientStats
+}
+
+func (srw *SeqReadWorker) GetSLIStats() *worker.SLIStats {
+	return srw.config.workerCfg.SLIs
+}
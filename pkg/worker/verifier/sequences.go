@@ -0,0 +1,93 @@
+package verifier
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Header carrying "<producer id>.<sequence>".  Each producer numbers its
+// records 0, 1, 2... separately for each partition, independent of offsets,
+// so that a consumer can spot lost records even where other writers have
+// filled in the offsets around them.
+const SequenceHeader = "kgo-verifier-seq"
+
+// Unique to this producer instance, so that a restarted producer's
+// sequences aren't mistaken for a regression.
+func newProducerId() string {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano() ^ int64(os.Getpid())<<32))
+	return fmt.Sprintf("%016x", rng.Uint64())
+}
+
+func makeSequenceHeader(producerId string, sequence int64) kgo.RecordHeader {
+	return kgo.RecordHeader{Key: SequenceHeader, Value: []byte(fmt.Sprintf("%s.%d", producerId, sequence))}
+}
+
+func recordSequence(r *kgo.Record) (string, int64, bool) {
+	for _, h := range r.Headers {
+		if h.Key != SequenceHeader {
+			continue
+		}
+		i := bytes.LastIndexByte(h.Value, '.')
+		if i <= 0 {
+			return "", 0, false
+		}
+		seq, err := strconv.ParseInt(string(h.Value[i+1:]), 10, 64)
+		if err != nil {
+			return "", 0, false
+		}
+		return string(h.Value[:i]), seq, true
+	}
+	return "", 0, false
+}
+
+type sequencePosition struct {
+	offset   int64
+	sequence int64
+}
+
+// The last sequence seen from each producer on each partition.  Records at
+// or behind the last offset seen are re-reads (e.g. after a consumer
+// restart or rebalance) and aren't checked.
+type SequenceTracker struct {
+	lock sync.Mutex
+	last map[string]map[int32]sequencePosition
+}
+
+func NewSequenceTracker() *SequenceTracker {
+	return &SequenceTracker{
+		last: make(map[string]map[int32]sequencePosition),
+	}
+}
+
+// Returns the sequence we expected, and whether the record had one to check
+func (st *SequenceTracker) Observe(r *kgo.Record) (producerId string, expect int64, found int64, checked bool) {
+	producerId, found, ok := recordSequence(r)
+	if !ok {
+		return "", 0, 0, false
+	}
+
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	partitions, ok := st.last[producerId]
+	if !ok {
+		partitions = make(map[int32]sequencePosition)
+		st.last[producerId] = partitions
+	}
+	last, seen := partitions[r.Partition]
+	if seen && r.Offset <= last.offset {
+		return producerId, 0, 0, false
+	}
+	partitions[r.Partition] = sequencePosition{offset: r.Offset, sequence: found}
+	if !seen {
+		// Might have started reading part way through
+		return producerId, found, found, true
+	}
+	return producerId, last.sequence + 1, found, true
+}
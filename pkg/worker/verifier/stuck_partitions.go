@@ -0,0 +1,178 @@
+package verifier
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+type StuckPartitionStatus struct {
+	// How many times did a partition with data remaining stop
+	// delivering records for longer than the watchdog window?
+	StuckPartitions int64 `json:"stuck_partitions"`
+
+	// Of the stuck partitions we tried to un-stick, how many started
+	// delivering records again within the next window?
+	Recoveries       int64 `json:"recoveries"`
+	FailedRecoveries int64 `json:"failed_recoveries"`
+}
+
+// Watch for partitions that stop delivering records while there is still
+// data left to read on them.  This is a common symptom of client or broker
+// bugs that otherwise just looks like a reader that never finishes.  The
+// status may be shared by several watchdogs, one per reader shard, so is
+// only updated atomically.
+type StuckPartitionWatchdog struct {
+	lock   sync.Mutex
+	window time.Duration
+	status *StuckPartitionStatus
+
+	lastProgress []time.Time
+	// Next offset we expect to read, and the offset we are reading up to
+	// (exclusive)
+	next []int64
+	upTo []int64
+
+	stuck []bool
+	// When we last tried to recover the partition, or zero if not recovering
+	recoveringAt []time.Time
+}
+
+func NewStuckPartitionWatchdog(window time.Duration, next []int64, upTo []int64, status *StuckPartitionStatus) *StuckPartitionWatchdog {
+	now := time.Now()
+	wd := StuckPartitionWatchdog{
+		window:       window,
+		status:       status,
+		lastProgress: make([]time.Time, len(next)),
+		next:         make([]int64, len(next)),
+		upTo:         make([]int64, len(upTo)),
+		stuck:        make([]bool, len(next)),
+		recoveringAt: make([]time.Time, len(next)),
+	}
+	copy(wd.next, next)
+	copy(wd.upTo, upTo)
+	for i := range wd.lastProgress {
+		wd.lastProgress[i] = now
+	}
+	return &wd
+}
+
+func (wd *StuckPartitionWatchdog) OnRecord(r *kgo.Record) {
+	wd.lock.Lock()
+	defer wd.lock.Unlock()
+
+	if r.Offset < wd.next[r.Partition] {
+		return
+	}
+	wd.next[r.Partition] = r.Offset + 1
+	wd.lastProgress[r.Partition] = time.Now()
+	wd.stuck[r.Partition] = false
+	if !wd.recoveringAt[r.Partition].IsZero() {
+		log.Infof("Stuck partition %s/%d recovered at offset %d", r.Topic, r.Partition, r.Offset)
+		atomic.AddInt64(&wd.status.Recoveries, 1)
+		wd.recoveringAt[r.Partition] = time.Time{}
+	}
+}
+
+// Returns partitions that have newly become stuck since the last check,
+// and accounts for any recovery attempts that didn't work.
+func (wd *StuckPartitionWatchdog) Check(paused []int32) []int32 {
+	wd.lock.Lock()
+	defer wd.lock.Unlock()
+
+	isPaused := make(map[int32]bool)
+	for _, p := range paused {
+		isPaused[p] = true
+	}
+
+	var newlyStuck []int32
+	for i := range wd.next {
+		p := int32(i)
+
+		if !wd.recoveringAt[p].IsZero() && time.Since(wd.recoveringAt[p]) > wd.window {
+			atomic.AddInt64(&wd.status.FailedRecoveries, 1)
+			wd.recoveringAt[p] = time.Time{}
+			// Give it another window before we flag it again
+			wd.stuck[p] = false
+			wd.lastProgress[p] = time.Now()
+		}
+
+		if isPaused[p] {
+			// Not stuck if we asked it to stop
+			wd.lastProgress[p] = time.Now()
+			continue
+		}
+
+		lag := wd.upTo[p] - wd.next[p]
+		if lag > 0 && !wd.stuck[p] && time.Since(wd.lastProgress[p]) > wd.window {
+			wd.stuck[p] = true
+			atomic.AddInt64(&wd.status.StuckPartitions, 1)
+			newlyStuck = append(newlyStuck, p)
+		}
+	}
+
+	return newlyStuck
+}
+
+func (wd *StuckPartitionWatchdog) OnRecoveryAttempt(p int32) {
+	wd.lock.Lock()
+	defer wd.lock.Unlock()
+	wd.recoveringAt[p] = time.Now()
+}
+
+// What we know about a partition, for diagnostics
+func (wd *StuckPartitionWatchdog) Describe(p int32) (next int64, upTo int64, idle time.Duration) {
+	wd.lock.Lock()
+	defer wd.lock.Unlock()
+	return wd.next[p], wd.upTo[p], time.Since(wd.lastProgress[p])
+}
+
+// Periodically check for stuck partitions until the context is cancelled,
+// calling onStuck for each partition that becomes stuck.
+func (wd *StuckPartitionWatchdog) Run(ctx context.Context, paused *PausedPartitions, onStuck func(p int32)) {
+	interval := wd.window / 4
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, p := range wd.Check(paused.List()) {
+				onStuck(p)
+			}
+		}
+	}
+}
+
+// Log what we know about a stuck partition
+func (wd *StuckPartitionWatchdog) Diagnose(client *kgo.Client, topic string, p int32) {
+	next, upTo, idle := wd.Describe(p)
+	leader, epoch, err := client.PartitionLeader(topic, p)
+	log.Warnf("Stuck partition %s/%d: no records for %v, next offset %d, reading up to %d, leader %d (epoch %d, err %v), buffered %d",
+		topic, p, idle, next, upTo, leader, epoch, err, client.BufferedFetchRecords())
+}
+
+// Try to get a stuck partition moving again by seeking to where we expect to
+// read next and refreshing metadata.  Seeking is only appropriate for clients
+// that are not in a consumer group.
+func (wd *StuckPartitionWatchdog) Recover(client *kgo.Client, topic string, p int32, seek bool) {
+	next, _, _ := wd.Describe(p)
+	wd.OnRecoveryAttempt(p)
+	if seek {
+		log.Infof("Stuck partition %s/%d: seeking to %d", topic, p, next)
+		client.SetOffsets(map[string]map[int32]kgo.EpochOffset{
+			topic: {p: kgo.EpochOffset{Epoch: -1, Offset: next}},
+		})
+	}
+	log.Infof("Stuck partition %s/%d: refreshing metadata", topic, p)
+	client.ForceMetadataRefresh()
+}
@@ -0,0 +1,211 @@
+package verifier
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	worker "github.com/redpanda-data/kgo-verifier/pkg/worker"
+	log "github.com/sirupsen/logrus"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+type TenantConfig struct {
+	workerCfg    worker.WorkerConfig
+	tenants      int
+	partitions   int32
+	replicas     int16
+	messageSize  int
+	messageCount int
+	rateMsgs     float64
+
+	// With %d for the tenant number, if tenants authenticate as
+	// principals of their own
+	userPattern     string
+	passwordPattern string
+}
+
+func NewTenantConfig(wc worker.WorkerConfig, tenants int, partitions int32, replicas int16,
+	messageSize int, messageCount int, rateMsgs float64, userPattern string, passwordPattern string) TenantConfig {
+	return TenantConfig{
+		workerCfg:       wc,
+		tenants:         tenants,
+		partitions:      partitions,
+		replicas:        replicas,
+		messageSize:     messageSize,
+		messageCount:    messageCount,
+		rateMsgs:        rateMsgs,
+		userPattern:     userPattern,
+		passwordPattern: passwordPattern,
+	}
+}
+
+type TenantStatus struct {
+	Tenant   int    `json:"tenant"`
+	Topic    string `json:"topic"`
+	ClientId string `json:"client_id"`
+	User     string `json:"user,omitempty"`
+
+	// What the tenant's producer got acked, and what its reader then
+	// validated
+	Acked      int64 `json:"acked"`
+	BadOffsets int64 `json:"bad_offsets"`
+	ValidReads int64 `json:"valid_reads"`
+
+	Done  bool   `json:"done"`
+	Error string `json:"error,omitempty"`
+}
+
+type TenantsStatus struct {
+	Tenants []TenantStatus `json:"tenants"`
+
+	// Tenants that finished, and those that stopped with an error
+	Done   int `json:"done"`
+	Failed int `json:"failed"`
+
+	StartedAt time.Time `json:"started_at"`
+}
+
+// Emulates many small tenants sharing a cluster: each tenant has its own
+// topic, client ID and optionally SASL principal, and a producer held to a
+// small rate followed by a sequential reader that validates what it wrote.
+// Tenants run concurrently and are validated independently, each against
+// its own valid offsets.
+type TenantWorker struct {
+	config TenantConfig
+	Status TenantsStatus
+	lock   sync.Mutex
+}
+
+func NewTenantWorker(cfg TenantConfig) *TenantWorker {
+	return &TenantWorker{config: cfg}
+}
+
+func (tw *TenantWorker) tenantConfig(i int) worker.WorkerConfig {
+	wc := tw.config.workerCfg
+	wc.Topic = fmt.Sprintf("%s-tenant-%03d", tw.config.workerCfg.Topic, i)
+	wc.Name = fmt.Sprintf("%s-tenant-%03d", tw.config.workerCfg.Name, i)
+	if tw.config.userPattern != "" {
+		wc.SaslUser = tenantName(tw.config.userPattern, i)
+		wc.SaslPass = tenantName(tw.config.passwordPattern, i)
+	}
+
+	// Each tenant is on its own, and held to its own rate
+	wc.Retries = worker.NewRetryStats()
+	wc.ClientStats = worker.NewClientStats()
+	wc.RateLimits = worker.NewClientRateLimits(tw.config.rateMsgs, 0, 0)
+	wc.Coordinator = nil
+	wc.LagGate = nil
+	wc.TraceRecorder = nil
+	wc.TraceReplay = nil
+	return wc
+}
+
+func tenantName(pattern string, i int) string {
+	if strings.Contains(pattern, "%") {
+		return fmt.Sprintf(pattern, i)
+	}
+	return pattern
+}
+
+func (tw *TenantWorker) Wait() error {
+	tw.Status.StartedAt = time.Now()
+	tw.Status.Tenants = make([]TenantStatus, tw.config.tenants)
+	for i := range tw.Status.Tenants {
+		wc := tw.tenantConfig(i)
+		tw.Status.Tenants[i] = TenantStatus{Tenant: i, Topic: wc.Topic, ClientId: wc.Name, User: wc.SaslUser}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < tw.config.tenants; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := tw.runTenant(i)
+			tw.lock.Lock()
+			defer tw.lock.Unlock()
+			if err != nil {
+				log.Errorf("Tenant %d failed: %v", i, err)
+				tw.Status.Tenants[i].Error = err.Error()
+				tw.Status.Failed += 1
+			} else {
+				tw.Status.Tenants[i].Done = true
+				tw.Status.Done += 1
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	log.Infof("Tenants complete: %d done, %d failed", tw.Status.Done, tw.Status.Failed)
+	if tw.Status.Failed > 0 {
+		return fmt.Errorf("%d of %d tenants failed", tw.Status.Failed, tw.config.tenants)
+	}
+	return nil
+}
+
+func (tw *TenantWorker) runTenant(i int) error {
+	wc := tw.tenantConfig(i)
+	nPartitions, err := tw.ensureTopic(wc)
+	if err != nil {
+		return err
+	}
+
+	pw := NewProducerWorker(NewProducerConfig(wc, "producer", nPartitions, tw.config.messageSize, nil, tw.config.messageCount,
+		-1, PayloadZeros, -1, 0))
+	// One tenant's failure mustn't stop the others
+	pw.TolerateFailures()
+	if err := pw.Wait(); err != nil {
+		return fmt.Errorf("producing: %v", err)
+	}
+	tw.lock.Lock()
+	tw.Status.Tenants[i].Acked = pw.Status.Acked
+	tw.Status.Tenants[i].BadOffsets = pw.Status.BadOffsets
+	tw.lock.Unlock()
+
+	srw := NewSeqReadWorker(NewSeqReadConfig(wc, "sequential", nPartitions, 1))
+	srw.Status.Validator.TolerateFailures()
+	if err := srw.Wait(); err != nil {
+		return fmt.Errorf("reading: %v", err)
+	}
+	tw.lock.Lock()
+	tw.Status.Tenants[i].ValidReads = srw.Status.Validator.ValidReads
+	tw.lock.Unlock()
+	if err := srw.Status.Validator.Failure(); err != nil {
+		return fmt.Errorf("validating: %v", err)
+	}
+	return nil
+}
+
+// Create the tenant's topic if it doesn't exist yet, returning how many
+// partitions it has
+func (tw *TenantWorker) ensureTopic(wc worker.WorkerConfig) (int32, error) {
+	client, err := kgo.NewClient(wc.MakeKgoOpts()...)
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+
+	n, err := GetPartitionCount(client, wc.Topic)
+	if errors.Is(err, kerr.UnknownTopicOrPartition) {
+		log.Infof("Creating tenant topic %s", wc.Topic)
+		if err := CreateTopic(client, wc.Topic, tw.config.partitions, tw.config.replicas); err != nil {
+			return 0, err
+		}
+		return tw.config.partitions, nil
+	}
+	return n, err
+}
+
+func (tw *TenantWorker) ResetStats() {
+}
+
+func (tw *TenantWorker) GetStatus() interface{} {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+	status := tw.Status
+	status.Tenants = append([]TenantStatus(nil), tw.Status.Tenants...)
+	return &status
+}
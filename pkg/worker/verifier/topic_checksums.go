@@ -0,0 +1,132 @@
+package verifier
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+type PartitionChecksum struct {
+	Partition int32  `json:"partition"`
+	Records   int64  `json:"records"`
+	Bytes     int64  `json:"bytes"`
+	Checksum  string `json:"checksum"`
+
+	// For finding where two clusters diverge: offsets needn't match
+	// between them, so the checksums don't cover them
+	FirstOffset int64 `json:"first_offset"`
+	LastOffset  int64 `json:"last_offset"`
+}
+
+type TopicChecksumStatus struct {
+	// Of each partition we read, and of the partitions' in turn.  Only
+	// comparable with another pass once complete.
+	Partitions []PartitionChecksum `json:"partitions"`
+	Checksum   string              `json:"checksum"`
+	Complete   bool                `json:"complete"`
+}
+
+// A rolling SHA-256 of each partition's record keys and values in offset
+// order, so that two copies of a topic, such as a source and its mirror,
+// can be compared by their checksums without moving their data.  Each key
+// and value goes in with its length, or -1 if null, so that the checksum
+// covers exactly the records' bytes and nothing is ambiguous.  Records at
+// or behind the last offset covered are re-reads and aren't counted again.
+type TopicChecksums struct {
+	lock       sync.Mutex
+	topic      string
+	partitions []*partitionChecksum
+	complete   bool
+}
+
+type partitionChecksum struct {
+	h      hash.Hash
+	status PartitionChecksum
+}
+
+func NewTopicChecksums(topic string, nPartitions int32) *TopicChecksums {
+	return &TopicChecksums{
+		topic:      topic,
+		partitions: make([]*partitionChecksum, nPartitions),
+	}
+}
+
+func (tc *TopicChecksums) OnRecord(r *kgo.Record) {
+	if tc == nil {
+		return
+	}
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	pc := tc.partitions[r.Partition]
+	if pc == nil {
+		pc = &partitionChecksum{
+			h:      sha256.New(),
+			status: PartitionChecksum{Partition: r.Partition, FirstOffset: r.Offset, LastOffset: -1},
+		}
+		tc.partitions[r.Partition] = pc
+	} else if r.Offset <= pc.status.LastOffset {
+		return
+	}
+	writeChecksummed(pc.h, r.Key)
+	writeChecksummed(pc.h, r.Value)
+	pc.status.Records += 1
+	pc.status.Bytes += int64(len(r.Key) + len(r.Value))
+	pc.status.LastOffset = r.Offset
+}
+
+func writeChecksummed(h hash.Hash, b []byte) {
+	var buf [binary.MaxVarintLen64]byte
+	n := int64(len(b))
+	if b == nil {
+		n = -1
+	}
+	h.Write(buf[:binary.PutVarint(buf[:], n)])
+	h.Write(b)
+}
+
+// The pass has read everything: log the checksums for comparing
+func (tc *TopicChecksums) Finish() {
+	if tc == nil {
+		return
+	}
+	tc.lock.Lock()
+	tc.complete = true
+	tc.lock.Unlock()
+
+	status := tc.Status()
+	for _, p := range status.Partitions {
+		log.Infof("Checksum %s/%d: %s (%d records, %d bytes, offsets %d-%d)", tc.topic, p.Partition, p.Checksum, p.Records, p.Bytes, p.FirstOffset, p.LastOffset)
+	}
+	log.Infof("Topic checksum %s: %s", tc.topic, status.Checksum)
+}
+
+func (tc *TopicChecksums) Status() TopicChecksumStatus {
+	if tc == nil {
+		return TopicChecksumStatus{}
+	}
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	status := TopicChecksumStatus{Partitions: []PartitionChecksum{}, Complete: tc.complete}
+	topic := sha256.New()
+	for _, pc := range tc.partitions {
+		if pc == nil {
+			continue
+		}
+		p := pc.status
+		sum := pc.h.Sum(nil)
+		p.Checksum = hex.EncodeToString(sum)
+		status.Partitions = append(status.Partitions, p)
+
+		var buf [4]byte
+		binary.BigEndian.PutUint32(buf[:], uint32(p.Partition))
+		topic.Write(buf[:])
+		topic.Write(sum)
+	}
+	status.Checksum = hex.EncodeToString(topic.Sum(nil))
+	return status
+}
@@ -2,11 +2,13 @@ package verifier
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 
 	"github.com/redpanda-data/kgo-verifier/pkg/util"
+	worker "github.com/redpanda-data/kgo-verifier/pkg/worker"
 	log "github.com/sirupsen/logrus"
 	"github.com/twmb/franz-go/pkg/kgo"
 )
@@ -33,6 +35,56 @@ type ValidatorStatus struct {
 	// data was written to the topic)
 	OutOfScopeInvalidReads int64 `json:"out_of_scope_invalid_reads"`
 
+	// How many records were skipped because they were stamped
+	// with a different run ID (or none) to the one we are validating
+	OtherRunReads int64 `json:"other_run_reads"`
+
+	// How many records were skipped because they were not written by
+	// this version of the verifier, or (with the "count" foreign
+	// record policy) not by the verifier at all
+	ForeignReads int64 `json:"foreign_reads"`
+
+	// Of the invalid reads that we re-fetched, how many read back
+	// correctly the second time (transient) vs. how many read back
+	// the same bad content (durable)
+	RefetchTransient int64 `json:"refetch_transient"`
+	RefetchDurable   int64 `json:"refetch_durable"`
+
+	// Records whose producer sequence skipped ahead (records lost
+	// in between) or went backwards, at an advancing offset
+	SequenceGaps        int64 `json:"sequence_gaps"`
+	SequenceRegressions int64 `json:"sequence_regressions"`
+
+	// Records whose value doesn't match the checksum header it was
+	// produced with
+	ChecksumMismatches int64 `json:"checksum_mismatches"`
+
+	// Records that failed validation and went to the dead-letter topic
+	DeadLettered int64 `json:"dead_lettered"`
+
+	// The first validation failure, if we carried on past it
+	FirstFailure string `json:"first_failure,omitempty"`
+
+	// If set, invalid reads are re-fetched before we give up
+	refetcher *Refetcher
+
+	// If set, producer sequences are checked for continuity
+	sequences *SequenceTracker
+
+	// If set, invalid records go here instead of stopping validation,
+	// counting against the anomaly budget if there is one
+	deadLetters *DeadLetters
+	anomalies   *worker.AnomalyBudget
+
+	// A bad read to re-fetch, and a dead letter to send, queued under
+	// the lock and handled once it is released
+	pendingRefetch *badRead
+	pendingLetter  *deadLetter
+
+	// If set, validation failures don't stop the process: the caller
+	// checks Failure once done
+	tolerant bool
+
 	// Concurrent access happens when doing random reads
 	// with multiple reader fibers
 	lock sync.Mutex
@@ -41,18 +93,121 @@ type ValidatorStatus struct {
 	lastCheckpoint time.Time
 }
 
+type deadLetter struct {
+	r      *kgo.Record
+	reason string
+}
+
+type badRead struct {
+	r           *kgo.Record
+	expectKey   string
+	validRanges *TopicOffsetRanges
+}
+
 func (cs *ValidatorStatus) ValidateRecord(r *kgo.Record, validRanges *TopicOffsetRanges) {
-	expect_key := fmt.Sprintf("%06d.%018d", 0, r.Offset)
-	log.Debugf("Consumed %s on p=%d at o=%d", r.Key, r.Partition, r.Offset)
 	cs.lock.Lock()
-	defer cs.lock.Unlock()
+	cs.validateRecord(r, validRanges)
+	if bad := cs.pendingRefetch; bad != nil {
+		cs.pendingRefetch = nil
+		rf := cs.refetcher
+		cs.lock.Unlock()
+
+		// Re-fetching builds clients and polls, so don't hold up the
+		// other fibers meanwhile
+		results := rf.Refetch(bad.r)
+
+		cs.lock.Lock()
+		cs.refetched(bad, results)
+	}
+	letter := cs.pendingLetter
+	cs.pendingLetter = nil
+	dl := cs.deadLetters
+	cs.lock.Unlock()
+
+	// Producing may block, so don't hold up the other fibers meanwhile
+	if letter != nil {
+		dl.Send(letter.r, letter.reason)
+	}
+}
+
+// Call with cs.lock held
+func (cs *ValidatorStatus) validateRecord(r *kgo.Record, validRanges *TopicOffsetRanges) {
+	expect_key := validRanges.ExpectedKey(r)
+	log.Debugf("Consumed %s on p=%d at o=%d", r.Key, r.Partition, r.Offset)
+
+	if validRanges.keyPattern == nil && ParseKeyVersion(r.Key) == ForeignKeyVersion {
+		switch validRanges.foreignRecords {
+		case ForeignRecordsFail:
+			cs.InvalidReads += 1
+			cs.invalid(r, "Foreign record at offset %d on partition %s/%d, key '%s'", r.Offset, r.Topic, r.Partition, r.Key)
+			return
+		case ForeignRecordsCount:
+			if !validRanges.Contains(r.Partition, r.Offset) {
+				cs.ForeignReads += 1
+				log.Debugf("Skipping foreign record (%s) on p=%d at o=%d", r.Key, r.Partition, r.Offset)
+				return
+			}
+		}
+	}
+
+	if validRanges.RunId != "" {
+		if runId := recordRunId(r); runId != validRanges.RunId {
+			if validRanges.Contains(r.Partition, r.Offset) {
+				cs.InvalidReads += 1
+				validRanges.ackEvidence.Report(r.Topic, r.Partition, r.Offset)
+				cs.invalid(r, "Bad read at offset %d on partition %s/%d.  Expect run '%s', found '%s'", r.Offset, r.Topic, r.Partition, validRanges.RunId, runId)
+				return
+			}
+			cs.OtherRunReads += 1
+			log.Debugf("Skipping record from run '%s' on p=%d at o=%d", runId, r.Partition, r.Offset)
+			return
+		}
+	}
+
+	if expect, found, checked := recordChecksum(r); checked && found != expect {
+		cs.ChecksumMismatches += 1
+		validRanges.ackEvidence.Report(r.Topic, r.Partition, r.Offset)
+		cs.invalid(r, "Bad payload at offset %d on partition %s/%d.  Expect checksum %s, found %s", r.Offset, r.Topic, r.Partition, expect, found)
+		return
+	}
+
+	if cs.sequences != nil {
+		if producerId, expect, found, checked := cs.sequences.Observe(r); checked && found != expect {
+			if found > expect {
+				cs.SequenceGaps += 1
+				validRanges.ackEvidence.Report(r.Topic, r.Partition, r.Offset)
+				cs.invalid(r, "Sequence gap at offset %d on partition %s/%d.  Producer %s expect sequence %d, found %d", r.Offset, r.Topic, r.Partition, producerId, expect, found)
+			} else {
+				cs.SequenceRegressions += 1
+				cs.invalid(r, "Sequence regression at offset %d on partition %s/%d.  Producer %s expect sequence %d, found %d", r.Offset, r.Topic, r.Partition, producerId, expect, found)
+			}
+			return
+		}
+	}
+
+	if expect_key != string(r.Key) && validRanges.keyPattern == nil {
+		if ParseKeyVersion(r.Key) == LegacyKeyVersion {
+			if validRanges.legacyKeys == LegacyKeysSkip {
+				cs.ForeignReads += 1
+				log.Debugf("Skipping legacy keyed record (%s) on p=%d at o=%d", r.Key, r.Partition, r.Offset)
+				return
+			}
+			expect_key = legacyKey(r.Offset)
+		}
+	}
 
 	if expect_key != string(r.Key) {
 		shouldBeValid := validRanges.Contains(r.Partition, r.Offset)
 
 		if shouldBeValid {
 			cs.InvalidReads += 1
-			util.Die("Bad read at offset %d on partition %s/%d.  Expect '%s', found '%s'", r.Offset, r.Topic, r.Partition, expect_key, r.Key)
+			if cs.refetcher != nil {
+				// Fails validation once re-fetched, in ValidateRecord
+				cs.pendingRefetch = &badRead{r: r, expectKey: expect_key, validRanges: validRanges}
+				return
+			}
+			cs.failBadRead(r, expect_key, validRanges)
+			return
 		} else {
 			cs.OutOfScopeInvalidReads += 1
 			log.Infof("Ignoring read validation at offset outside valid range %s/%d %d", r.Topic, r.Partition, r.Offset)
@@ -68,6 +223,106 @@ func (cs *ValidatorStatus) ValidateRecord(r *kgo.Record, validRanges *TopicOffse
 	}
 }
 
+// Call with cs.lock held
+func (cs *ValidatorStatus) refetched(bad *badRead, results []RefetchResult) {
+	r := bad.r
+	transient, durable := ClassifyRefetch(r, bad.expectKey, results)
+	if transient {
+		cs.RefetchTransient += 1
+	}
+	if durable {
+		cs.RefetchDurable += 1
+	}
+	log.Errorf("Re-fetch of bad read at offset %d on partition %s/%d: transient=%v durable=%v", r.Offset, r.Topic, r.Partition, transient, durable)
+	cs.Checkpoint()
+	cs.failBadRead(r, bad.expectKey, bad.validRanges)
+}
+
+func (cs *ValidatorStatus) failBadRead(r *kgo.Record, expectKey string, validRanges *TopicOffsetRanges) {
+	validRanges.ackEvidence.Report(r.Topic, r.Partition, r.Offset)
+	cs.invalid(r, "Bad read at offset %d on partition %s/%d.  Expect '%s', found '%s'", r.Offset, r.Topic, r.Partition, expectKey, r.Key)
+}
+
+// Validation failed: stop, unless we have a dead-letter topic to carry on
+// with or are tolerating failures
+func (cs *ValidatorStatus) invalid(r *kgo.Record, msg string, args ...interface{}) {
+	if cs.deadLetters == nil && !cs.tolerant {
+		util.DieValidation(msg, args...)
+	}
+	reason := fmt.Sprintf(msg, args...)
+	log.Error(reason)
+	if cs.FirstFailure == "" {
+		cs.FirstFailure = reason
+	}
+	if cs.deadLetters != nil {
+		cs.DeadLettered += 1
+		cs.pendingLetter = &deadLetter{r: r, reason: reason}
+	}
+	cs.anomalies.Observe("invalid_read", reason)
+}
+
+// Carry on past validation failures, for a caller that has other work to
+// finish regardless
+func (cs *ValidatorStatus) TolerateFailures() {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	cs.tolerant = true
+}
+
+// The first validation failure, if we tolerated any
+func (cs *ValidatorStatus) Failure() error {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	if cs.FirstFailure == "" {
+		return nil
+	}
+	return errors.New(cs.FirstFailure)
+}
+
+func recordRunId(r *kgo.Record) string {
+	for _, h := range r.Headers {
+		if h.Key == RunIdHeader {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func (cs *ValidatorStatus) SetRefetcher(rf *Refetcher) {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	cs.refetcher = rf
+}
+
+// Check producer sequences on the records we validate.  Only for readers
+// that see each partition's records in offset order.
+func (cs *ValidatorStatus) EnableSequenceChecks() {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	if cs.sequences == nil {
+		cs.sequences = NewSequenceTracker()
+	}
+}
+
+func (cs *ValidatorStatus) EnableDeadLetters(wc worker.WorkerConfig) {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	if cs.deadLetters == nil && wc.DeadLetterTopic != "" {
+		cs.deadLetters = NewDeadLetters(wc)
+		cs.anomalies = wc.Anomalies
+	}
+}
+
+// At the end of a pass, if we have been dead-lettering
+func (cs *ValidatorStatus) CheckDeadLetters() {
+	cs.lock.Lock()
+	dl := cs.deadLetters
+	cs.lock.Unlock()
+	if dl != nil {
+		dl.Check()
+	}
+}
+
 func (cs *ValidatorStatus) Checkpoint() {
 	log.Infof("Validator status: %s", cs.String())
 }
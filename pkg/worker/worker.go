@@ -86,11 +86,25 @@ func (ms *MessageStats) Reset() {
 	ms.E2e_latency.Clear()
 }
 
+// Worker statuses include started_at, when the worker started after any
+// --start-delay
 type Worker interface {
 	GetStatus() interface{}
 	ResetStats()
 }
 
+// Workers whose clients record wire-level statistics
+type ClientStatsWorker interface {
+	GetClientStats() *ClientStats
+}
+
+// Consuming workers that can stop fetching from particular partitions
+// on request, returning the resulting set of paused partitions.
+type PausableWorker interface {
+	PausePartitions(partitions []int32) []int32
+	ResumePartitions(partitions []int32) []int32
+}
+
 type KeySpace struct {
 	UniqueCount uint64
 }
@@ -99,8 +113,30 @@ type ValueGenerator struct {
 	PayloadSize uint64
 }
 
+func ParseCompression(s string) (kgo.CompressionCodec, error) {
+	switch s {
+	case "none":
+		return kgo.NoCompression(), nil
+	case "gzip":
+		return kgo.GzipCompression(), nil
+	case "snappy":
+		return kgo.SnappyCompression(), nil
+	case "lz4":
+		return kgo.Lz4Compression(), nil
+	case "zstd":
+		return kgo.ZstdCompression(), nil
+	default:
+		return kgo.NoCompression(), fmt.Errorf("unknown compression '%s'", s)
+	}
+}
+
 type WorkerConfig struct {
-	Name               string
+	Name string
+
+	// Reported to brokers in ApiVersions requests (KIP-511), if set
+	SoftwareName    string
+	SoftwareVersion string
+
 	Brokers            string
 	Trace              bool
 	Topic              string
@@ -109,6 +145,130 @@ type WorkerConfig struct {
 	BatchMaxbytes      uint
 	SaslUser           string
 	SaslPass           string
+
+	// Producers: batch compression, none unless set
+	Compression kgo.CompressionCodec
+
+	// Producers: shift traffic away from partitions whose ack latency is
+	// over this (0 to disable)
+	LatencyWeightThreshold time.Duration
+
+	// Producers: pace the records we make to these rates (0 for no
+	// limit), counting keys and values before compression and batching
+	ProduceMsgRate  float64
+	ProduceByteRate float64
+
+	// Producers: only produce to partitions led from brokers in this
+	// rack, following leadership as it moves
+	ProduceRack string
+
+	// Producers: seed for choosing partitions, so that a run's choices
+	// can be made again
+	Seed int64
+
+	// Verifier: re-read records that fail validation, optionally
+	// from a follower as well as the leader
+	RefetchInvalid  bool
+	RefetchFollower bool
+
+	// Readers: send records that fail validation to this topic and carry
+	// on, failing at the end of the pass instead
+	DeadLetterTopic string
+
+	// Sequential readers: pretend to fail processing this fraction of
+	// records, retrying each by seeking back after the backoff
+	ProcessingFailureFraction float64
+	ProcessingRetryBackoff    time.Duration
+
+	// Readers: flag partitions that deliver nothing for this long while
+	// data remains (0 to disable), and optionally try to recover them
+	StuckPartitionWindow  time.Duration
+	StuckPartitionRecover bool
+
+	// Readers: flag partitions whose records arrive more than this many
+	// times later than the median partition's (0 to disable), and more
+	// than the minimum later
+	ArrivalSkewFactor float64
+	ArrivalSkewMin    time.Duration
+
+	// Sequential readers: checksum each partition's keys and values
+	TopicChecksum bool
+
+	// Consumer group membership timeouts (0 for client defaults)
+	SessionTimeout    time.Duration
+	HeartbeatInterval time.Duration
+	RebalanceTimeout  time.Duration
+
+	// If set, records are stamped with this run ID, and readers only
+	// validate records from the same run
+	RunId string
+
+	// If set, readers fetch the producer's valid offsets from this URL
+	// rather than reading them from the local filesystem
+	OffsetsUrl string
+
+	// If set, readers take their expectations from this manifest instead
+	// of from our producer, e.g. to validate another tool's data
+	ExpectationsFile string
+
+	// Readers: "validate" or "skip" records keyed in the format used
+	// before keys were versioned
+	LegacyKeys string
+
+	// Readers: "ignore", "count" or "fail" records that the verifier
+	// didn't write
+	ForeignRecords string
+
+	// How many times a worker loop may be restarted after a panic before
+	// giving up
+	PanicRestarts int
+
+	// Client retry policy (zero values for client defaults)
+	RequestRetries         int
+	RetryTimeout           time.Duration
+	RetryBackoffMin        time.Duration
+	RetryBackoffMax        time.Duration
+	RequestTimeoutOverhead time.Duration
+
+	// If set, clients count their retries here
+	Retries *RetryStats
+
+	// If set, clients record wire-level statistics here
+	ClientStats *ClientStats
+
+	// If set, clients are held to these rates
+	RateLimits *ClientRateLimits
+
+	// If set, producers report their availability here for
+	// maintenance window reports
+	Maintenance *MaintenanceWindow
+
+	// If set, workers measure SLIs here
+	SLIs *SLIStats
+
+	// If set, workers report anomalies they carry on past here, to stop
+	// the run once there are too many
+	Anomalies *AnomalyBudget
+
+	// If set, clients track their fetch session usage here, and
+	// readers may fail the run if sessions weren't used
+	FetchSessions        *FetchSessionStats
+	RequireFetchSessions bool
+
+	// If set, producers and readers only work on the partitions this
+	// process is assigned
+	Coordinator *Coordinator
+
+	// If set, producers wait on this while a linked consumer group lags
+	LagGate *LagGate
+
+	// If set, workers stop where they are once validation fails
+	Freeze *Freeze
+
+	// If set, producers write what they do to this trace, or do exactly
+	// what this trace says instead of choosing for themselves
+	TraceRecorder *TraceRecorder
+	TraceReplay   *TraceReplay
 }
 
 func (wc *WorkerConfig) MakeKgoOpts() []kgo.Opt {
@@ -127,6 +287,9 @@ func (wc *WorkerConfig) MakeKgoOpts() []kgo.Opt {
 		opts = append(opts, kgo.ClientID(wc.Name))
 
 	}
+	if wc.SoftwareName != "" {
+		opts = append(opts, kgo.SoftwareNameAndVersion(wc.SoftwareName, wc.SoftwareVersion))
+	}
 
 	// Disable auth if username not given
 	if len(wc.SaslUser) > 0 {
@@ -139,12 +302,65 @@ func (wc *WorkerConfig) MakeKgoOpts() []kgo.Opt {
 			kgo.SASL(auth))
 	}
 
+	if wc.RequestRetries != 0 {
+		opts = append(opts, kgo.RequestRetries(wc.RequestRetries))
+	}
+	if wc.RetryTimeout != 0 {
+		opts = append(opts, kgo.RetryTimeout(wc.RetryTimeout))
+	}
+	if wc.RequestTimeoutOverhead != 0 {
+		opts = append(opts, kgo.RequestTimeoutOverhead(wc.RequestTimeoutOverhead))
+	}
+	if wc.RetryBackoffMin != 0 || wc.RetryBackoffMax != 0 || wc.Retries != nil {
+		onBackoff := func() {}
+		if wc.Retries != nil {
+			onBackoff = wc.Retries.onBackoff
+		}
+		opts = append(opts, kgo.RetryBackoffFn(makeRetryBackoff(wc.RetryBackoffMin, wc.RetryBackoffMax, onBackoff)))
+	}
+	if wc.Retries != nil {
+		opts = append(opts, kgo.WithHooks(wc.Retries))
+	}
+	if wc.ClientStats != nil {
+		opts = append(opts, kgo.WithHooks(wc.ClientStats))
+	}
+	if wc.RateLimits != nil {
+		opts = append(opts, kgo.WithHooks(wc.RateLimits))
+	}
+	if wc.Maintenance != nil {
+		opts = append(opts, kgo.WithHooks(wc.Maintenance))
+	}
+
+	var logger kgo.Logger
 	if wc.Trace {
-		opts = append(opts, kgo.WithLogger(kgo.BasicLogger(os.Stderr, kgo.LogLevelDebug, func() string {
+		logger = kgo.BasicLogger(os.Stderr, kgo.LogLevelDebug, func() string {
 			return fmt.Sprintf("time=\"%s\" name=%s", time.Now().UTC().Format(time.RFC3339), wc.Name)
-		})))
+		})
+	}
+	if wc.FetchSessions != nil {
+		opts = append(opts, kgo.Dialer(wc.FetchSessions.dial))
+		logger = &fetchSessionLogger{inner: logger, stats: wc.FetchSessions}
 	}
+	if logger != nil {
+		opts = append(opts, kgo.WithLogger(logger))
+	}
+
+	return opts
+}
 
+// Options for clients that are members of a consumer group, in addition
+// to those from MakeKgoOpts
+func (wc *WorkerConfig) MakeGroupOpts() []kgo.Opt {
+	var opts []kgo.Opt
+	if wc.SessionTimeout != 0 {
+		opts = append(opts, kgo.SessionTimeout(wc.SessionTimeout))
+	}
+	if wc.HeartbeatInterval != 0 {
+		opts = append(opts, kgo.HeartbeatInterval(wc.HeartbeatInterval))
+	}
+	if wc.RebalanceTimeout != 0 {
+		opts = append(opts, kgo.RebalanceTimeout(wc.RebalanceTimeout))
+	}
 	return opts
 }
 
@@ -0,0 +1,208 @@
+package worker
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Identifies a workload trace file, and its format version
+const traceMagic = "kgo-verifier-trace-1\n"
+
+// One produced record: how long after the one before it was sent, where to
+// and how big its value was
+type TraceOp struct {
+	Delay     time.Duration
+	Partition int32
+	Size      int
+}
+
+// Writes the producer's operations to a trace file as they happen, for
+// replaying later.  Each operation is three varints: the delay since the
+// operation before in microseconds, the partition and the value size.
+type TraceRecorder struct {
+	lock sync.Mutex
+	path string
+	f    *os.File
+	w    *bufio.Writer
+	last time.Time
+	ops  int64
+}
+
+func NewTraceRecorder(path string) (*TraceRecorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	if _, err := w.WriteString(traceMagic); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &TraceRecorder{path: path, f: f, w: w}, nil
+}
+
+func (tr *TraceRecorder) Record(partition int32, size int) error {
+	tr.lock.Lock()
+	defer tr.lock.Unlock()
+	now := time.Now()
+	var delay time.Duration
+	if !tr.last.IsZero() {
+		delay = now.Sub(tr.last)
+	}
+	tr.last = now
+
+	var buf [3 * binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(delay.Microseconds()))
+	n += binary.PutUvarint(buf[n:], uint64(partition))
+	n += binary.PutUvarint(buf[n:], uint64(size))
+	_, err := tr.w.Write(buf[:n])
+	tr.ops += 1
+	return err
+}
+
+// Safe to call more than once
+func (tr *TraceRecorder) Close() error {
+	tr.lock.Lock()
+	defer tr.lock.Unlock()
+	if tr.f == nil {
+		return nil
+	}
+	err := tr.w.Flush()
+	if cerr := tr.f.Close(); err == nil {
+		err = cerr
+	}
+	tr.f = nil
+	return err
+}
+
+func (tr *TraceRecorder) Ops() int64 {
+	tr.lock.Lock()
+	defer tr.lock.Unlock()
+	return tr.ops
+}
+
+// A recorded trace, handing out its operations in order, each once it is
+// due relative to the first
+type TraceReplay struct {
+	lock    sync.Mutex
+	ops     []TraceOp
+	next    int
+	started time.Time
+	due     time.Duration
+
+	// Multiplies the recorded pace, 0 for no waiting at all
+	speed float64
+}
+
+func LoadTrace(path string) (*TraceReplay, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	magic := make([]byte, len(traceMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != traceMagic {
+		return nil, fmt.Errorf("%s is not a workload trace", path)
+	}
+
+	var ops []TraceOp
+	for {
+		delay, err := binary.ReadUvarint(r)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var partition, size uint64
+		if err == nil {
+			partition, err = binary.ReadUvarint(r)
+		}
+		if err == nil {
+			size, err = binary.ReadUvarint(r)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("trace %s truncated after %d operations: %v", path, len(ops), err)
+		}
+		ops = append(ops, TraceOp{
+			Delay:     time.Duration(delay) * time.Microsecond,
+			Partition: int32(partition),
+			Size:      int(size),
+		})
+	}
+	return &TraceReplay{ops: ops, speed: 1}, nil
+}
+
+// Parses a replay speed: a positive multiple of the recorded pace, or "max"
+// to replay as fast as we can
+func ParseReplaySpeed(s string) (float64, error) {
+	if s == "max" {
+		return 0, nil
+	}
+	speed, err := strconv.ParseFloat(s, 64)
+	if err != nil || speed <= 0 || math.IsInf(speed, 0) {
+		return 0, fmt.Errorf("bad replay speed '%s': use a positive number or 'max'", s)
+	}
+	return speed, nil
+}
+
+func (tr *TraceReplay) SetSpeed(speed float64) {
+	tr.lock.Lock()
+	defer tr.lock.Unlock()
+	tr.speed = speed
+}
+
+func (tr *TraceReplay) Len() int {
+	return len(tr.ops)
+}
+
+// Operations not yet handed out
+func (tr *TraceReplay) Remaining() int {
+	tr.lock.Lock()
+	defer tr.lock.Unlock()
+	return len(tr.ops) - tr.next
+}
+
+// The highest partition the trace produces to, or -1 if it is empty
+func (tr *TraceReplay) MaxPartition() int32 {
+	max := int32(-1)
+	for _, op := range tr.ops {
+		if op.Partition > max {
+			max = op.Partition
+		}
+	}
+	return max
+}
+
+// Waits until the next operation is due, and returns it.  False once the
+// trace is used up.
+func (tr *TraceReplay) Next() (TraceOp, bool) {
+	tr.lock.Lock()
+	if tr.next >= len(tr.ops) {
+		tr.lock.Unlock()
+		return TraceOp{}, false
+	}
+	op := tr.ops[tr.next]
+	tr.next += 1
+	if tr.started.IsZero() {
+		tr.started = time.Now()
+	}
+	var wait time.Duration
+	if tr.speed > 0 {
+		tr.due += time.Duration(float64(op.Delay) / tr.speed)
+		wait = time.Until(tr.started.Add(tr.due))
+	}
+	tr.lock.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+	return op, true
+}